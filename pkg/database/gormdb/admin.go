@@ -0,0 +1,203 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+type AdminDbStatus struct {
+	DbType    string
+	Connected bool
+	// password is replaced
+	Dsn     string
+	Dialect string
+	// migrated by Config.AutoMigrate in this process
+	Migrated   bool
+	Migrations []*MigrationStatus
+	// error of reading Migrations
+	MigrationErr string
+	Pool         *PoolStats
+	LastPing     time.Time
+	PingLatency  time.Duration
+	PingErr      string
+	// closed, open or half_open, empty without Config.BreakerThreshold
+	Breaker    string
+	BreakerErr string
+	// newest first
+	SlowQueries []*SlowQuery
+}
+
+// return status of db types passed to NewGormDb and every connected db type, ordered by db type name
+//
+// migrations are read only from connected db types, so it never connects a db type
+// nor waits for a db type being connected
+func (o *GormDb[T]) AdminStatus(ctx context.Context) []*AdminDbStatus {
+	type connected struct {
+		status *AdminDbStatus
+		dbType T
+	}
+
+	o.mutex.RLock()
+	dbTypes := append([]T{}, o.dbTypes...)
+	for dbType := range o.db {
+		dbTypes = append(dbTypes, dbType)
+	}
+	byName := make(map[string]*AdminDbStatus, len(dbTypes))
+	result := make([]*AdminDbStatus, 0, len(dbTypes))
+	connecteds := []*connected{}
+	for _, dbType := range dbTypes {
+		name := fmt.Sprint(dbType)
+		if _, ok := byName[name]; ok {
+			continue
+		}
+
+		status := &AdminDbStatus{
+			DbType:  name,
+			Dialect: string(o.getDialect(dbType)),
+		}
+		_, status.Migrated = o.migrated[dbType]
+		if _, ok := o.db[dbType]; ok {
+			status.Connected = true
+			status.Dsn = o.dsns[dbType]
+			connecteds = append(connecteds, &connected{status: status, dbType: dbType})
+		}
+		if breaker, ok := o.breakers[dbType]; ok {
+			var err error
+			status.Breaker, err = breaker.status()
+			if err != nil && status.Breaker != breakerClosed.String() {
+				status.BreakerErr = err.Error()
+			}
+		}
+		if slowQueries, ok := o.slowQueries[dbType]; ok {
+			status.SlowQueries = slowQueries.snapshot()
+		}
+
+		byName[name] = status
+		result = append(result, status)
+	}
+	o.mutex.RUnlock()
+
+	stats := o.Stats()
+	health := o.Health()
+	for _, c := range connecteds {
+		c.status.Pool = stats[c.dbType]
+
+		if h, ok := health[c.dbType]; ok {
+			c.status.LastPing = h.LastCheck
+			c.status.PingLatency = h.Latency
+			if h.Err != nil {
+				c.status.PingErr = h.Err.Error()
+			}
+		}
+
+		migrations, err := o.getConnectedMigrationStatus(ctx, c.dbType)
+		if err != nil {
+			c.status.MigrationErr = err.Error()
+		}
+		c.status.Migrations = migrations
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].DbType < result[j].DbType
+	})
+	return result
+}
+
+// same as Status but return nil instead of connecting dbType
+func (o *GormDb[T]) getConnectedMigrationStatus(ctx context.Context, dbType T) ([]*MigrationStatus, error) {
+	migrations, err := getMigrations(dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.RLock()
+	db, ok := o.db[dbType]
+	o.mutex.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	return getMigrationStatus(db.WithContext(ctx), migrations)
+}
+
+// return a http.Handler shows AdminStatus, json by default, html by ?format=html or a browser
+//
+// the dsn is redacted but the handler still exposes internals, mount it on an admin only address
+func (o *GormDb[T]) AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		statuses := o.AdminStatus(ctx)
+
+		format := r.URL.Query().Get("format")
+		if format == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			format = "html"
+		}
+
+		var err error
+		if format == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			err = adminTemplate.Execute(w, statuses)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			encoder := json.NewEncoder(w)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(statuses)
+		}
+		if err != nil {
+			o.log().WarnContext(ctx, "gormdb write admin status failed",
+				slog.Any("err", serr.ToJSON(serr.Wrap(err), true)))
+		}
+	})
+}
+
+var adminTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gormdb</title>
+<style>
+body { font-family: monospace; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 2px 6px; text-align: left; vertical-align: top; }
+</style>
+</head>
+<body>
+{{range .}}
+<h2>{{.DbType}}</h2>
+<table>
+<tr><th>connected</th><td>{{.Connected}}</td></tr>
+<tr><th>dsn</th><td>{{.Dsn}}</td></tr>
+<tr><th>dialect</th><td>{{.Dialect}}</td></tr>
+<tr><th>migrated</th><td>{{.Migrated}}</td></tr>
+{{if .MigrationErr}}<tr><th>migration error</th><td>{{.MigrationErr}}</td></tr>{{end}}
+{{with .Pool}}<tr><th>pool</th><td>open {{.Primary.OpenConnections}}, in use {{.Primary.InUse}}, idle {{.Primary.Idle}}, wait {{.Primary.WaitCount}} ({{.Primary.WaitDuration}}){{range $i, $r := .Replicas}}<br>replica {{$i}}: open {{$r.OpenConnections}}, in use {{$r.InUse}}, idle {{$r.Idle}}{{end}}</td></tr>{{end}}
+<tr><th>last ping</th><td>{{if not .LastPing.IsZero}}{{.LastPing.Format "2006-01-02 15:04:05"}} {{.PingLatency}} {{.PingErr}}{{end}}</td></tr>
+{{if .Breaker}}<tr><th>breaker</th><td>{{.Breaker}} {{.BreakerErr}}</td></tr>{{end}}
+</table>
+{{if .Migrations}}
+<table>
+<tr><th>version</th><th>name</th><th>applied</th><th>applied at</th></tr>
+{{range .Migrations}}<tr><td>{{.Version}}</td><td>{{.Name}}</td><td>{{if .Unknown}}unknown{{else}}{{.Applied}}{{end}}</td><td>{{if .Applied}}{{.AppliedAt.Format "2006-01-02 15:04:05"}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}
+{{if .SlowQueries}}
+<table>
+<tr><th>time</th><th>elapsed</th><th>rows</th><th>sql</th><th>file</th></tr>
+{{range .SlowQueries}}<tr><td>{{.Time.Format "2006-01-02 15:04:05"}}</td><td>{{.Elapsed}}</td><td>{{.Rows}}</td><td>{{.Sql}}</td><td>{{.File}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
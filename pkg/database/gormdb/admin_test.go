@@ -0,0 +1,187 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type adminDbType int
+
+func (adminDbType) GetMigrations() []*Migration {
+	return []*Migration{
+		{
+			Version: 1,
+			Name:    "init",
+			Up: func(tx *gorm.DB) error {
+				return nil
+			},
+		},
+	}
+}
+
+// db types 1 and 2 are passed to NewGormDb, only 1 is connected.
+// each db type is the sqlite file <db type>.sqlite in dir, the dsn has the password
+func newAdminTestDb(t *testing.T, dir string) *GormDb[adminDbType] {
+	config := &Config{
+		Password:         "secret",
+		AutoMigrate:      true,
+		BreakerThreshold: 3,
+		Log:              slog.New(slog.NewTextHandler(io.Discard, nil)),
+		LogLevel:         logger.Silent,
+	}
+	config.dsnOverride = func(dbType any, config *Config) (Dialect, string) {
+		return DialectSqlite, fmt.Sprintf("file:%s?password=%s", filepath.Join(dir, fmt.Sprint(dbType)+".sqlite"), config.Password)
+	}
+	gormDb := NewGormDb[adminDbType](config, 1, 2)
+	t.Cleanup(func() {
+		gormDb.Stop(context.Background())
+	})
+
+	if _, err := gormDb.TryGetDb(1); err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+	return gormDb
+}
+
+func TestAdminStatus(t *testing.T) {
+	dir := t.TempDir()
+	gormDb := newAdminTestDb(t, dir)
+
+	statuses := gormDb.AdminStatus(context.Background())
+	if len(statuses) != 2 {
+		t.Fatalf("statuses:%d want:2", len(statuses))
+	}
+
+	connected := statuses[0]
+	if connected.DbType != "1" || !connected.Connected || !connected.Migrated || connected.Dialect != string(DialectSqlite) {
+		t.Fatalf("status:%+v", connected)
+	}
+	if strings.Contains(connected.Dsn, "secret") || !strings.Contains(connected.Dsn, "password=***") {
+		t.Fatalf("dsn not redacted. dsn:%s", connected.Dsn)
+	}
+	if len(connected.Migrations) != 1 || !connected.Migrations[0].Applied || connected.MigrationErr != "" {
+		t.Fatalf("migrations:%v err:%s", connected.Migrations, connected.MigrationErr)
+	}
+	if connected.Pool == nil || connected.Breaker != "closed" {
+		t.Fatalf("pool:%v breaker:%s", connected.Pool, connected.Breaker)
+	}
+
+	notConnected := statuses[1]
+	if notConnected.DbType != "2" || notConnected.Connected || notConnected.Migrated ||
+		notConnected.Dsn != "" || notConnected.Migrations != nil || notConnected.Pool != nil {
+		t.Fatalf("status:%+v", notConnected)
+	}
+
+	// AdminStatus never connects a db type
+	gormDb.mutex.RLock()
+	_, ok := gormDb.db[2]
+	gormDb.mutex.RUnlock()
+	if ok {
+		t.Fatalf("db type connected by admin status")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2.sqlite")); !os.IsNotExist(err) {
+		t.Fatalf("database of db type opened by admin status. err:%v", err)
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	tests := []struct {
+		name            string
+		query           string
+		accept          string
+		wantContentType string
+		wantBody        []string
+	}{
+		{
+			name:            "json",
+			wantContentType: "application/json",
+		},
+		{
+			name:            "html by accept",
+			accept:          "text/html,application/xhtml+xml",
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        []string{"<h2>1</h2>", "<h2>2</h2>", "password=***", "<td>init</td>"},
+		},
+		{
+			name:            "html by query",
+			query:           "?format=html",
+			wantContentType: "text/html; charset=utf-8",
+			wantBody:        []string{"<h2>1</h2>", "<h2>2</h2>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			gormDb := newAdminTestDb(t, dir)
+			server := httptest.NewServer(gormDb.AdminHandler())
+			defer server.Close()
+
+			req, err := http.NewRequest(http.MethodGet, server.URL+tt.query, nil)
+			if err != nil {
+				t.Fatalf("new request fail. err:%v", err)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rsp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatalf("request fail. err:%v", err)
+			}
+			defer rsp.Body.Close()
+			body, err := io.ReadAll(rsp.Body)
+			if err != nil {
+				t.Fatalf("read fail. err:%v", err)
+			}
+
+			if rsp.StatusCode != http.StatusOK || rsp.Header.Get("Content-Type") != tt.wantContentType {
+				t.Fatalf("status:%d content type:%s", rsp.StatusCode, rsp.Header.Get("Content-Type"))
+			}
+			if strings.Contains(string(body), "secret") {
+				t.Fatalf("password in body:%s", body)
+			}
+			for _, want := range tt.wantBody {
+				if !strings.Contains(string(body), want) {
+					t.Fatalf("%q not in body:%s", want, body)
+				}
+			}
+			if _, err := os.Stat(filepath.Join(dir, "2.sqlite")); !os.IsNotExist(err) {
+				t.Fatalf("database of db type opened by admin handler. err:%v", err)
+			}
+
+			if tt.wantContentType != "application/json" {
+				return
+			}
+			statuses := []map[string]interface{}{}
+			if err := json.Unmarshal(body, &statuses); err != nil {
+				t.Fatalf("unmarshal fail. err:%v body:%s", err, body)
+			}
+			if len(statuses) != 2 {
+				t.Fatalf("statuses:%s", body)
+			}
+			for _, key := range []string{"DbType", "Connected", "Dsn", "Dialect", "Migrated", "Migrations", "MigrationErr",
+				"Pool", "LastPing", "PingLatency", "PingErr", "Breaker", "BreakerErr", "SlowQueries"} {
+				if _, ok := statuses[0][key]; !ok {
+					t.Fatalf("no key %s in status:%v", key, statuses[0])
+				}
+			}
+			if statuses[0]["DbType"] != "1" || statuses[0]["Connected"] != true || statuses[0]["Dsn"] != gormDb.dsns[1] {
+				t.Fatalf("status:%v", statuses[0])
+			}
+			if statuses[1]["DbType"] != "2" || statuses[1]["Connected"] != false || statuses[1]["Migrations"] != nil {
+				t.Fatalf("status:%v", statuses[1])
+			}
+		})
+	}
+}
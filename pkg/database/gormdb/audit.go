@@ -0,0 +1,415 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	auditActorContextKey contextKey = "audit_actor"
+
+	auditOldSettingKey = "jf:audit_old"
+)
+
+type AuditAction string
+
+const (
+	AuditActionCreate AuditAction = "create"
+	AuditActionUpdate AuditAction = "update"
+	AuditActionDelete AuditAction = "delete"
+)
+
+// models of GetAuditTables are audited, see NewAuditPlugin
+type DbTypeGetAuditTables interface {
+	GetAuditTables() []interface{}
+}
+
+type AuditChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// column name to change, saved as json
+type AuditChanges map[string]*AuditChange
+
+func (o AuditChanges) Value() (driver.Value, error) {
+	data, err := json.Marshal(o)
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+	return string(data), nil
+}
+
+func (o *AuditChanges) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, o)
+	case string:
+		return json.Unmarshal([]byte(v), o)
+	case nil:
+		*o = nil
+		return nil
+	default:
+		return serr.Errorf("unsupported type:%T", value)
+	}
+}
+
+func (AuditChanges) GormDataType() string {
+	return string(schema.String)
+}
+
+type AuditLog struct {
+	Id    int64  `gorm:"primaryKey"`
+	Table string `gorm:"size:191;index:idx_jf_audit_logs_record,priority:1"`
+	// primary key values joined by comma
+	RecordId  string      `gorm:"size:191;index:idx_jf_audit_logs_record,priority:2"`
+	Action    AuditAction `gorm:"size:16"`
+	ActorId   string      `gorm:"size:191"`
+	Changes   AuditChanges
+	CreatedAt time.Time
+}
+
+func (AuditLog) TableName() string {
+	return "jf_audit_logs"
+}
+
+// the actor of changes made with the returned ctx, saved in AuditLog.ActorId
+func WithAuditActor(ctx context.Context, actorId string) context.Context {
+	return context.WithValue(ctx, auditActorContextKey, actorId)
+}
+
+func GetAuditActor(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	v, _ := ctx.Value(auditActorContextKey).(string)
+	return v
+}
+
+// a gorm plugin save changes of tables to AuditLog in the transaction of the change
+type auditPlugin struct {
+	tables []interface{}
+	// table names of tables
+	audited map[string]struct{}
+}
+
+// the plugin records create, update and delete of the models by gorm,
+// raw sql, global updates and statements with SkipDefaultTransaction outside a transaction
+// are not recorded in the same transaction or not at all
+//
+// GormDb uses it for db types implement DbTypeGetAuditTables
+func NewAuditPlugin(tables ...interface{}) gorm.Plugin {
+	return &auditPlugin{
+		tables: tables,
+	}
+}
+
+func (o *auditPlugin) Name() string {
+	return "jf:audit"
+}
+
+func (o *auditPlugin) Initialize(db *gorm.DB) error {
+	o.audited = make(map[string]struct{}, len(o.tables))
+	cache := &sync.Map{}
+	for _, table := range o.tables {
+		s, err := schema.Parse(table, cache, db.NamingStrategy)
+		if err != nil {
+			return serr.Wrap(err)
+		}
+		o.audited[s.Table] = struct{}{}
+	}
+
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().After("gorm:create").Before("gorm:commit_or_rollback_transaction").Register("jf:audit_after", o.afterCreate),
+		callback.Update().After("gorm:before_update").Before("gorm:update").Register("jf:audit", o.beforeChange),
+		callback.Update().After("gorm:update").Before("gorm:commit_or_rollback_transaction").Register("jf:audit_after", o.afterUpdate),
+		callback.Delete().After("gorm:before_delete").Before("gorm:delete").Register("jf:audit", o.beforeChange),
+		callback.Delete().After("gorm:delete").Before("gorm:commit_or_rollback_transaction").Register("jf:audit_after", o.afterDelete),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *auditPlugin) isAudited(db *gorm.DB) bool {
+	if db.Statement.Schema == nil {
+		return false
+	}
+	_, ok := o.audited[db.Statement.Schema.Table]
+	return ok
+}
+
+// a new statement on the connection of db, so reads and writes are in the same transaction
+func auditSession(db *gorm.DB) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true, Context: ForcePrimary(db.Statement.Context)})
+}
+
+func auditRecordId(ctx context.Context, s *schema.Schema, rv reflect.Value) string {
+	ids := make([]string, 0, len(s.PrimaryFields))
+	for _, field := range s.PrimaryFields {
+		v, _ := field.ValueOf(ctx, rv)
+		ids = append(ids, fmt.Sprint(v))
+	}
+	return strings.Join(ids, ",")
+}
+
+// each struct of a struct, a slice or an array
+func eachStruct(rv reflect.Value, fc func(rv reflect.Value)) {
+	rv = reflect.Indirect(rv)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			eachStruct(rv.Index(i), fc)
+		}
+	case reflect.Struct:
+		fc(rv)
+	}
+}
+
+func (o *auditPlugin) save(db *gorm.DB, logs []*AuditLog) {
+	if len(logs) == 0 {
+		return
+	}
+
+	if err := auditSession(db).Create(&logs).Error; err != nil {
+		db.AddError(serr.Wrap(err))
+	}
+}
+
+func (o *auditPlugin) newLog(db *gorm.DB, action AuditAction, recordId string, changes AuditChanges) *AuditLog {
+	return &AuditLog{
+		Table:    db.Statement.Schema.Table,
+		RecordId: recordId,
+		Action:   action,
+		ActorId:  GetAuditActor(db.Statement.Context),
+		Changes:  changes,
+	}
+}
+
+func (o *auditPlugin) afterCreate(db *gorm.DB) {
+	if db.Error != nil || !o.isAudited(db) {
+		return
+	}
+
+	ctx := db.Statement.Context
+	s := db.Statement.Schema
+	logs := []*AuditLog{}
+	eachStruct(db.Statement.ReflectValue, func(rv reflect.Value) {
+		changes := AuditChanges{}
+		for _, field := range s.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			v, _ := field.ValueOf(ctx, rv)
+			changes[field.DBName] = &AuditChange{To: v}
+		}
+		logs = append(logs, o.newLog(db, AuditActionCreate, auditRecordId(ctx, s, rv), changes))
+	})
+
+	o.save(db, logs)
+}
+
+// read rows matched by expressions and primary keys of byPrimaryKeys, invalid if no condition
+//
+// byPrimaryKeys is a struct, a slice or an array of structs
+func findAuditRows(db *gorm.DB, s *schema.Schema, expressions []clause.Expression, byPrimaryKeys reflect.Value) (reflect.Value, error) {
+	ctx := db.Statement.Context
+	if len(s.PrimaryFields) == 0 {
+		return reflect.Value{}, nil
+	}
+	if byPrimaryKeys.IsValid() {
+		switch kind := reflect.Indirect(byPrimaryKeys).Kind(); kind {
+		case reflect.Struct, reflect.Slice, reflect.Array:
+		default:
+			return reflect.Value{}, serr.Errorf("audit can not read primary keys. table:%s kind:%s", s.Table, kind)
+		}
+	}
+
+	if len(s.PrimaryFields) == 1 {
+		field := s.PrimaryFields[0]
+		values := []interface{}{}
+		eachStruct(byPrimaryKeys, func(rv reflect.Value) {
+			if v, isZero := field.ValueOf(ctx, rv); !isZero {
+				values = append(values, v)
+			}
+		})
+		if len(values) != 0 {
+			expressions = append(expressions, clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Values: values})
+		}
+	} else {
+		// (a = ? AND b = ?) OR (a = ? AND b = ?) ...
+		rowExpressions := []clause.Expression{}
+		eachStruct(byPrimaryKeys, func(rv reflect.Value) {
+			eqs := []clause.Expression{}
+			for _, field := range s.PrimaryFields {
+				if v, isZero := field.ValueOf(ctx, rv); !isZero {
+					eqs = append(eqs, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: v})
+				}
+			}
+			if len(eqs) != 0 {
+				rowExpressions = append(rowExpressions, clause.And(eqs...))
+			}
+		})
+		// gorm joins an OrConditions of one expression to others by OR
+		if len(rowExpressions) == 1 {
+			expressions = append(expressions, rowExpressions[0])
+		} else if len(rowExpressions) > 1 {
+			expressions = append(expressions, clause.Or(rowExpressions...))
+		}
+	}
+
+	if len(expressions) == 0 {
+		return reflect.Value{}, nil
+	}
+
+	rows := reflect.New(reflect.SliceOf(reflect.PtrTo(s.ModelType)))
+	if err := auditSession(db).Model(reflect.New(s.ModelType).Interface()).Clauses(clause.Where{Exprs: expressions}).Find(rows.Interface()).Error; err != nil {
+		return reflect.Value{}, serr.Wrap(err)
+	}
+
+	return rows.Elem(), nil
+}
+
+func (o *auditPlugin) beforeChange(db *gorm.DB) {
+	if db.Error != nil || !o.isAudited(db) {
+		return
+	}
+
+	expressions := []clause.Expression{}
+	if c, ok := db.Statement.Clauses["WHERE"]; ok {
+		if where, ok := c.Expression.(clause.Where); ok {
+			expressions = append(expressions, where.Exprs...)
+		}
+	}
+
+	// gorm adds primary keys of the model to conditions later
+	byPrimaryKeys := db.Statement.ReflectValue
+	if db.Statement.Model != nil {
+		byPrimaryKeys = reflect.ValueOf(db.Statement.Model)
+	}
+
+	rows, err := findAuditRows(db, db.Statement.Schema, expressions, byPrimaryKeys)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if rows.IsValid() {
+		db.Statement.Settings.Store(auditOldSettingKey, rows)
+	}
+}
+
+func (o *auditPlugin) afterUpdate(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(auditOldSettingKey)
+	if !ok || db.Error != nil {
+		return
+	}
+	oldRows := v.(reflect.Value)
+	if oldRows.Len() == 0 {
+		return
+	}
+
+	// read again by primary keys, the conditions may not match the updated rows
+	newRows, err := findAuditRows(db, db.Statement.Schema, nil, oldRows)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	if !newRows.IsValid() {
+		return
+	}
+
+	ctx := db.Statement.Context
+	s := db.Statement.Schema
+	newRowById := make(map[string]reflect.Value, newRows.Len())
+	eachStruct(newRows, func(rv reflect.Value) {
+		newRowById[auditRecordId(ctx, s, rv)] = rv
+	})
+
+	logs := []*AuditLog{}
+	eachStruct(oldRows, func(oldRow reflect.Value) {
+		recordId := auditRecordId(ctx, s, oldRow)
+		newRow, ok := newRowById[recordId]
+		if !ok {
+			return
+		}
+
+		changes := AuditChanges{}
+		for _, field := range s.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			from, _ := field.ValueOf(ctx, oldRow)
+			to, _ := field.ValueOf(ctx, newRow)
+			if !reflect.DeepEqual(from, to) {
+				changes[field.DBName] = &AuditChange{From: from, To: to}
+			}
+		}
+		if len(changes) != 0 {
+			logs = append(logs, o.newLog(db, AuditActionUpdate, recordId, changes))
+		}
+	})
+
+	o.save(db, logs)
+}
+
+func (o *auditPlugin) afterDelete(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(auditOldSettingKey)
+	if !ok || db.Error != nil {
+		return
+	}
+	oldRows := v.(reflect.Value)
+
+	ctx := db.Statement.Context
+	s := db.Statement.Schema
+	logs := []*AuditLog{}
+	eachStruct(oldRows, func(rv reflect.Value) {
+		changes := AuditChanges{}
+		for _, field := range s.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			v, _ := field.ValueOf(ctx, rv)
+			changes[field.DBName] = &AuditChange{From: v}
+		}
+		logs = append(logs, o.newLog(db, AuditActionDelete, auditRecordId(ctx, s, rv), changes))
+	})
+
+	o.save(db, logs)
+}
+
+// changes of the record of model with primary key id in ascending order,
+// id of a composite primary key is the values joined by comma
+func (o *GormDb[T]) AuditHistory(ctx context.Context, dbType T, model interface{}, id interface{}) ([]*AuditLog, error) {
+	db, err := o.TryFromCtx(ctx, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	logs := []*AuditLog{}
+	if err := db.Where(&AuditLog{Table: stmt.Schema.Table, RecordId: fmt.Sprint(id)}).Order("id").Find(&logs).Error; err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return logs, nil
+}
@@ -0,0 +1,216 @@
+package gormdb_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+)
+
+type auditItem struct {
+	Id    int64 `gorm:"primaryKey"`
+	Name  string
+	Level int
+}
+
+type auditPairItem struct {
+	A    int64 `gorm:"primaryKey;autoIncrement:false"`
+	B    int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name string
+}
+
+type auditSkippedItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+type auditDbType int
+
+func (auditDbType) GetTables() []interface{} {
+	return []interface{}{&auditItem{}, &auditPairItem{}, &auditSkippedItem{}}
+}
+
+func (auditDbType) GetAuditTables() []interface{} {
+	return []interface{}{&auditItem{}, &auditPairItem{}}
+}
+
+// "table record action actor column:from>to ..." of each audit log
+func formatAuditLogs(logs []*gormdb.AuditLog) []string {
+	result := []string{}
+	for _, log := range logs {
+		changes := []string{}
+		for column, change := range log.Changes {
+			changes = append(changes, fmt.Sprintf("%s:%v>%v", column, change.From, change.To))
+		}
+		sort.Strings(changes)
+		result = append(result, fmt.Sprintf("%s %s %s %s %s", log.Table, log.RecordId, log.Action, log.ActorId, strings.Join(changes, " ")))
+	}
+	return result
+}
+
+func TestAudit(t *testing.T) {
+	tests := []struct {
+		name    string
+		actor   string
+		run     func(db *gorm.DB) error
+		wantErr bool
+		want    []string
+	}{
+		{
+			name:  "create",
+			actor: "alice",
+			run: func(db *gorm.DB) error {
+				return db.Create(&auditItem{Id: 3, Name: "c", Level: 3}).Error
+			},
+			want: []string{"audit_items 3 create alice id:<nil>>3 level:<nil>>3 name:<nil>>c"},
+		},
+		{
+			name: "create in batch",
+			run: func(db *gorm.DB) error {
+				return db.Create([]*auditItem{{Id: 3, Name: "c"}, {Id: 4, Name: "d"}}).Error
+			},
+			want: []string{
+				"audit_items 3 create  id:<nil>>3 level:<nil>>0 name:<nil>>c",
+				"audit_items 4 create  id:<nil>>4 level:<nil>>0 name:<nil>>d",
+			},
+		},
+		{
+			name: "save",
+			run: func(db *gorm.DB) error {
+				return db.Save(&auditItem{Id: 1, Name: "a2", Level: 1}).Error
+			},
+			want: []string{"audit_items 1 update  name:a>a2"},
+		},
+		{
+			name: "update by model",
+			run: func(db *gorm.DB) error {
+				return db.Model(&auditItem{Id: 2}).Update("level", 5).Error
+			},
+			want: []string{"audit_items 2 update  level:2>5"},
+		},
+		{
+			name: "update by conditions",
+			run: func(db *gorm.DB) error {
+				return db.Model(&auditItem{}).Where("level >= ?", 1).Updates(map[string]interface{}{"name": "x"}).Error
+			},
+			want: []string{
+				"audit_items 1 update  name:a>x",
+				"audit_items 2 update  name:b>x",
+			},
+		},
+		{
+			name: "update without change",
+			run: func(db *gorm.DB) error {
+				return db.Model(&auditItem{Id: 1}).Update("name", "a").Error
+			},
+			want: []string{},
+		},
+		{
+			name: "delete by primary key",
+			run: func(db *gorm.DB) error {
+				return db.Delete(&auditItem{Id: 1}).Error
+			},
+			want: []string{"audit_items 1 delete  id:1><nil> level:1><nil> name:a><nil>"},
+		},
+		{
+			name: "delete by conditions",
+			run: func(db *gorm.DB) error {
+				return db.Where("name = ?", "b").Delete(&auditItem{}).Error
+			},
+			want: []string{"audit_items 2 delete  id:2><nil> level:2><nil> name:b><nil>"},
+		},
+		{
+			name: "composite primary key",
+			run: func(db *gorm.DB) error {
+				return db.Model(&auditPairItem{A: 1, B: 2}).Update("name", "y").Error
+			},
+			want: []string{"audit_pair_items 1,2 update  name:p>y"},
+		},
+		{
+			name: "not audited table",
+			run: func(db *gorm.DB) error {
+				return db.Create(&auditSkippedItem{Id: 1}).Error
+			},
+			want: []string{},
+		},
+		{
+			name: "rolled back",
+			run: func(db *gorm.DB) error {
+				return db.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Create(&auditItem{Id: 3}).Error; err != nil {
+						return err
+					}
+					return errTxTest
+				})
+			},
+			wantErr: true,
+			want:    []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[auditDbType](t, nil, 0)
+			db := gormDb.GetDb(0)
+			if err := db.Create([]*auditItem{{Id: 1, Name: "a", Level: 1}, {Id: 2, Name: "b", Level: 2}}).Error; err != nil {
+				t.Fatalf("create fail. err:%v", err)
+			}
+			if err := db.Create(&auditPairItem{A: 1, B: 2, Name: "p"}).Error; err != nil {
+				t.Fatalf("create fail. err:%v", err)
+			}
+			if err := db.Where("1 = 1").Delete(&gormdb.AuditLog{}).Error; err != nil {
+				t.Fatalf("delete logs fail. err:%v", err)
+			}
+
+			ctx := gormdb.WithAuditActor(context.Background(), tt.actor)
+			if err := tt.run(db.WithContext(ctx)); (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+
+			logs := []*gormdb.AuditLog{}
+			if err := db.Order("id").Find(&logs).Error; err != nil {
+				t.Fatalf("find logs fail. err:%v", err)
+			}
+			if got := formatAuditLogs(logs); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Fatalf("got:%q want:%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuditHistory(t *testing.T) {
+	gormDb := gormdbtest.New[auditDbType](t, nil, 0)
+	ctx := gormdb.WithAuditActor(context.Background(), "bob")
+	db := gormDb.FromCtx(ctx, 0)
+
+	item := &auditItem{Id: 1, Name: "a"}
+	if err := db.Create(item).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+	if err := db.Create(&auditItem{Id: 2, Name: "other"}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+	if err := db.Model(item).Update("level", 7).Error; err != nil {
+		t.Fatalf("update fail. err:%v", err)
+	}
+	if err := db.Delete(item).Error; err != nil {
+		t.Fatalf("delete fail. err:%v", err)
+	}
+
+	logs, err := gormDb.AuditHistory(ctx, 0, &auditItem{}, 1)
+	if err != nil {
+		t.Fatalf("history fail. err:%v", err)
+	}
+	want := []string{
+		"audit_items 1 create bob id:<nil>>1 level:<nil>>0 name:<nil>>a",
+		"audit_items 1 update bob level:0>7",
+		"audit_items 1 delete bob id:1><nil> level:7><nil> name:a><nil>",
+	}
+	if got := formatAuditLogs(logs); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", want) {
+		t.Fatalf("got:%q want:%q", got, want)
+	}
+}
@@ -0,0 +1,288 @@
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/helper"
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrBatchWriterFull    = errors.New("batch writer full")
+	ErrBatchWriterStopped = errors.New("batch writer stopped")
+)
+
+// called with rows of a batch still failed after retries
+type DeadLetterFunc[M any] func(ctx context.Context, rows []*M, err error)
+
+type BatchWriterConfig[M any] struct {
+	// flush when this many rows are queued, also the rows of one INSERT. default is 500
+	BatchSize int
+	// flush queued rows at least this often, default is 1 second
+	FlushInterval time.Duration
+	// Write blocks when this many rows are queued, default is 10 * BatchSize
+	QueueSize int
+	// retry times of a failed batch, default is 3, negative to disable
+	MaxRetry int
+	// backoff before the first retry, doubled each retry, default is 100 milliseconds
+	RetryBackoff time.Duration
+	// default logs the error and the row count
+	DeadLetter DeadLetterFunc[M]
+}
+
+// insert rows of M in batches in background, so callers not wait for the database
+type BatchWriter[M any] struct {
+	config BatchWriterConfig[M]
+	name   string
+	getDb  func(ctx context.Context) (*gorm.DB, error)
+	log    *slog.Logger
+	queue  chan *M
+	mutex  sync.Mutex
+	state  batchWriterState
+	// Write in progress, the queue is drained after they return
+	writing sync.WaitGroup
+	// closed by Stop, so blocked Write returns
+	stopping chan struct{}
+	stop     chan context.Context
+	done     chan struct{}
+}
+
+type batchWriterState int
+
+const (
+	batchWriterNew batchWriterState = iota
+	batchWriterRunning
+	batchWriterStopped
+)
+
+var _ helper.Service = (*BatchWriter[int])(nil)
+
+// config may be nil
+func NewBatchWriter[M any, T comparable](gormDb *GormDb[T], dbType T, config *BatchWriterConfig[M]) *BatchWriter[M] {
+	c := BatchWriterConfig[M]{}
+	if config != nil {
+		c = *config
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 500
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = time.Second
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = 10 * c.BatchSize
+	}
+	if c.MaxRetry == 0 {
+		c.MaxRetry = 3
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = 100 * time.Millisecond
+	}
+
+	o := &BatchWriter[M]{
+		config: c,
+		name:   fmt.Sprintf("gormdb batch writer %T", new(M)),
+		getDb: func(ctx context.Context) (*gorm.DB, error) {
+			return gormDb.getDb(ctx, dbType)
+		},
+		log:      gormDb.log(),
+		queue:    make(chan *M, c.QueueSize),
+		stopping: make(chan struct{}),
+		stop:     make(chan context.Context, 1),
+		done:     make(chan struct{}),
+	}
+	if o.config.DeadLetter == nil {
+		o.config.DeadLetter = o.logDeadLetter
+	}
+
+	return o
+}
+
+func (o *BatchWriter[M]) Name() string {
+	return o.name
+}
+
+func (o *BatchWriter[M]) Start(ctx context.Context) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	switch o.state {
+	case batchWriterRunning:
+		return serr.New("batch writer already started")
+	case batchWriterStopped:
+		return ErrBatchWriterStopped
+	}
+	o.state = batchWriterRunning
+
+	go o.run()
+	return nil
+}
+
+// stop accepting rows and flush queued rows, wait until flushed or ctx done.
+// rows failed in the final flush go to Config.DeadLetter
+func (o *BatchWriter[M]) Stop(ctx context.Context) error {
+	o.mutex.Lock()
+	state := o.state
+	if state != batchWriterStopped {
+		o.state = batchWriterStopped
+		close(o.stopping)
+	}
+	o.mutex.Unlock()
+
+	switch state {
+	case batchWriterNew:
+		// rows written before Start
+		go func() {
+			defer close(o.done)
+			o.drain(ctx, make([]*M, 0, o.config.BatchSize))
+		}()
+	case batchWriterRunning:
+		o.stop <- ctx
+	}
+
+	select {
+	case <-o.done:
+		return nil
+	case <-ctx.Done():
+		return serr.Wrap(ctx.Err())
+	}
+}
+
+// return a func to call after the row is queued, or ErrBatchWriterStopped
+func (o *BatchWriter[M]) beginWrite() (func(), error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.state == batchWriterStopped {
+		return nil, ErrBatchWriterStopped
+	}
+	o.writing.Add(1)
+	return o.writing.Done, nil
+}
+
+// queue m, block when the queue is full until there is space, ctx done or Stop
+func (o *BatchWriter[M]) Write(ctx context.Context, m *M) error {
+	end, err := o.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	select {
+	case o.queue <- m:
+		return nil
+	case <-ctx.Done():
+		return serr.Wrap(ctx.Err())
+	case <-o.stopping:
+		return ErrBatchWriterStopped
+	}
+}
+
+// queue m, return ErrBatchWriterFull instead of blocking when the queue is full
+func (o *BatchWriter[M]) WriteNoWait(m *M) error {
+	end, err := o.beginWrite()
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	select {
+	case o.queue <- m:
+		return nil
+	default:
+		return ErrBatchWriterFull
+	}
+}
+
+// number of rows queued and not flushed yet
+func (o *BatchWriter[M]) Len() int {
+	return len(o.queue)
+}
+
+func (o *BatchWriter[M]) run() {
+	defer close(o.done)
+
+	ticker := time.NewTicker(o.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*M, 0, o.config.BatchSize)
+	for {
+		select {
+		case m := <-o.queue:
+			batch = append(batch, m)
+			if len(batch) >= o.config.BatchSize {
+				o.flush(context.Background(), batch)
+				batch = make([]*M, 0, o.config.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) != 0 {
+				o.flush(context.Background(), batch)
+				batch = make([]*M, 0, o.config.BatchSize)
+			}
+		case ctx := <-o.stop:
+			o.drain(ctx, batch)
+			return
+		}
+	}
+}
+
+// flush batch and queued rows after Stop
+func (o *BatchWriter[M]) drain(ctx context.Context, batch []*M) {
+	// no more Write after they return, so the queue only shrinks
+	o.writing.Wait()
+
+	for len(o.queue) != 0 {
+		batch = append(batch, <-o.queue)
+		if len(batch) >= o.config.BatchSize {
+			o.flush(ctx, batch)
+			batch = make([]*M, 0, o.config.BatchSize)
+		}
+	}
+	if len(batch) != 0 {
+		o.flush(ctx, batch)
+	}
+}
+
+func (o *BatchWriter[M]) flush(ctx context.Context, batch []*M) {
+	var err error
+	for retry := 0; ; retry++ {
+		if err = o.insert(ctx, batch); err == nil {
+			return
+		}
+		if retry >= o.config.MaxRetry {
+			break
+		}
+
+		if sleepErr := sleepContext(ctx, backoffWithJitter(o.config.RetryBackoff, retry, 0)); sleepErr != nil {
+			err = errors.Join(err, sleepErr)
+			break
+		}
+	}
+
+	o.config.DeadLetter(ctx, batch, err)
+}
+
+func (o *BatchWriter[M]) insert(ctx context.Context, batch []*M) error {
+	db, err := o.getDb(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := db.WithContext(ctx).CreateInBatches(batch, o.config.BatchSize).Error; err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+func (o *BatchWriter[M]) logDeadLetter(ctx context.Context, rows []*M, err error) {
+	o.log.ErrorContext(ctx, "gormdb batch writer drop rows",
+		slog.Any("err", serr.ToJSON(err, true)),
+		slog.String("name", o.name),
+		slog.Int("rows", len(rows)))
+}
@@ -0,0 +1,221 @@
+package gormdb_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm/logger"
+)
+
+type batchItem struct {
+	Id int64 `gorm:"primaryKey"`
+}
+
+type batchDbType int
+
+func (batchDbType) GetTables() []interface{} {
+	return []interface{}{&batchItem{}}
+}
+
+func countBatchItems(t *testing.T, gormDb *gormdb.GormDb[batchDbType]) int64 {
+	var count int64
+	if err := gormDb.GetDb(0).Model(&batchItem{}).Count(&count).Error; err != nil {
+		t.Fatalf("count fail. err:%v", err)
+	}
+	return count
+}
+
+func waitBatchItems(t *testing.T, gormDb *gormdb.GormDb[batchDbType], want int64) {
+	for deadline := time.Now().Add(time.Second); countBatchItems(t, gormDb) != want; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("count:%d want:%d", countBatchItems(t, gormDb), want)
+		}
+	}
+}
+
+func TestBatchWriterFlush(t *testing.T) {
+	tests := []struct {
+		name   string
+		config gormdb.BatchWriterConfig[batchItem]
+		rows   int
+	}{
+		{
+			name:   "batch size",
+			config: gormdb.BatchWriterConfig[batchItem]{BatchSize: 3, FlushInterval: time.Hour},
+			rows:   3,
+		},
+		{
+			name:   "flush interval",
+			config: gormdb.BatchWriterConfig[batchItem]{BatchSize: 100, FlushInterval: 10 * time.Millisecond},
+			rows:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[batchDbType](t, nil, 0)
+			writer := gormdb.NewBatchWriter(gormDb, 0, &tt.config)
+			if err := writer.Start(context.Background()); err != nil {
+				t.Fatalf("start fail. err:%v", err)
+			}
+			t.Cleanup(func() {
+				writer.Stop(context.Background())
+			})
+
+			for i := 1; i <= tt.rows; i++ {
+				if err := writer.Write(context.Background(), &batchItem{Id: int64(i)}); err != nil {
+					t.Fatalf("write fail. err:%v", err)
+				}
+			}
+			waitBatchItems(t, gormDb, int64(tt.rows))
+		})
+	}
+}
+
+func TestBatchWriterStop(t *testing.T) {
+	tests := []struct {
+		name  string
+		start bool
+	}{
+		{
+			name:  "drain queued rows",
+			start: true,
+		},
+		{
+			name: "stop before start",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[batchDbType](t, nil, 0)
+			writer := gormdb.NewBatchWriter(gormDb, 0, &gormdb.BatchWriterConfig[batchItem]{
+				BatchSize:     2,
+				FlushInterval: time.Hour,
+			})
+			if tt.start {
+				if err := writer.Start(context.Background()); err != nil {
+					t.Fatalf("start fail. err:%v", err)
+				}
+			}
+
+			for i := 1; i <= 5; i++ {
+				if err := writer.WriteNoWait(&batchItem{Id: int64(i)}); err != nil {
+					t.Fatalf("write fail. err:%v", err)
+				}
+			}
+			if err := writer.Stop(context.Background()); err != nil {
+				t.Fatalf("stop fail. err:%v", err)
+			}
+			if count := countBatchItems(t, gormDb); count != 5 {
+				t.Fatalf("count:%d want:5", count)
+			}
+
+			if err := writer.Write(context.Background(), &batchItem{Id: 6}); !errors.Is(err, gormdb.ErrBatchWriterStopped) {
+				t.Fatalf("write after stop err:%v", err)
+			}
+			if err := writer.Start(context.Background()); !errors.Is(err, gormdb.ErrBatchWriterStopped) {
+				t.Fatalf("start after stop err:%v", err)
+			}
+			if err := writer.Stop(context.Background()); err != nil {
+				t.Fatalf("stop again fail. err:%v", err)
+			}
+		})
+	}
+}
+
+func TestBatchWriterStartTwice(t *testing.T) {
+	gormDb := gormdbtest.New[batchDbType](t, nil, 0)
+	writer := gormdb.NewBatchWriter[batchItem](gormDb, 0, nil)
+	if err := writer.Start(context.Background()); err != nil {
+		t.Fatalf("start fail. err:%v", err)
+	}
+	defer writer.Stop(context.Background())
+
+	if err := writer.Start(context.Background()); err == nil {
+		t.Fatalf("want error")
+	}
+}
+
+func TestBatchWriterFull(t *testing.T) {
+	gormDb := gormdbtest.New[batchDbType](t, nil, 0)
+	writer := gormdb.NewBatchWriter(gormDb, 0, &gormdb.BatchWriterConfig[batchItem]{
+		QueueSize: 1,
+	})
+
+	if err := writer.WriteNoWait(&batchItem{Id: 1}); err != nil {
+		t.Fatalf("write fail. err:%v", err)
+	}
+	if err := writer.WriteNoWait(&batchItem{Id: 2}); !errors.Is(err, gormdb.ErrBatchWriterFull) {
+		t.Fatalf("err:%v want:%v", err, gormdb.ErrBatchWriterFull)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := writer.Write(ctx, &batchItem{Id: 2}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err:%v want:%v", err, context.DeadlineExceeded)
+	}
+
+	// a blocked Write returns when stopped
+	writeErr := make(chan error, 1)
+	go func() {
+		writeErr <- writer.Write(context.Background(), &batchItem{Id: 3})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := writer.Stop(context.Background()); err != nil {
+		t.Fatalf("stop fail. err:%v", err)
+	}
+	if err := <-writeErr; !errors.Is(err, gormdb.ErrBatchWriterStopped) {
+		t.Fatalf("blocked write err:%v want:%v", err, gormdb.ErrBatchWriterStopped)
+	}
+	if count := countBatchItems(t, gormDb); count != 1 {
+		t.Fatalf("count:%d want:1", count)
+	}
+}
+
+func TestBatchWriterDeadLetter(t *testing.T) {
+	gormDb := gormdbtest.New[batchDbType](t, &gormdb.Config{LogLevel: logger.Silent}, 0)
+	if err := gormDb.GetDb(0).Create(&batchItem{Id: 1}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+
+	mutex := sync.Mutex{}
+	deadRows := []*batchItem{}
+	var deadErr error
+	writer := gormdb.NewBatchWriter(gormDb, 0, &gormdb.BatchWriterConfig[batchItem]{
+		FlushInterval: time.Hour,
+		MaxRetry:      1,
+		RetryBackoff:  time.Millisecond,
+		DeadLetter: func(ctx context.Context, rows []*batchItem, err error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			deadRows = append(deadRows, rows...)
+			deadErr = err
+		},
+	})
+	if err := writer.Start(context.Background()); err != nil {
+		t.Fatalf("start fail. err:%v", err)
+	}
+
+	// id 1 exists, so the batch fails every retry
+	for _, id := range []int64{1, 2} {
+		if err := writer.Write(context.Background(), &batchItem{Id: id}); err != nil {
+			t.Fatalf("write fail. err:%v", err)
+		}
+	}
+	if err := writer.Stop(context.Background()); err != nil {
+		t.Fatalf("stop fail. err:%v", err)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if len(deadRows) != 2 || deadErr == nil {
+		t.Fatalf("dead rows:%d err:%v", len(deadRows), deadErr)
+	}
+	if count := countBatchItems(t, gormDb); count != 1 {
+		t.Fatalf("count:%d want:1", count)
+	}
+}
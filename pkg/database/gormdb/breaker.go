@@ -0,0 +1,251 @@
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+const breakerTrialSettingKey = "jf:breaker_trial"
+
+// check by errors.Is, the error is a *CircuitOpenError
+var ErrCircuitOpen = errors.New("circuit open")
+
+// returned instead of running the statement while the circuit breaker of the db type is open,
+// the database is not reachable or too slow, callers should reply busy instead of retrying
+type CircuitOpenError struct {
+	DbType string
+	// a statement is let through to try after this time
+	RetryAt time.Time
+	// the last error opened the breaker
+	Cause error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open. db_type:%s retry_at:%s cause:%v", e.DbType, e.RetryAt.Format(time.RFC3339), e.Cause)
+}
+
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	// open duration passed, a trial statement is running until openUntil
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half_open"
+	default:
+		return "closed"
+	}
+}
+
+// a gorm plugin fails statements fast after Config.BreakerThreshold timeouts or connection errors in a row
+type circuitBreaker struct {
+	dbType       string
+	threshold    int
+	openDuration time.Duration
+	mutex        sync.Mutex
+	state        breakerState
+	failures     int
+	openUntil    time.Time
+	lastErr      error
+}
+
+func newCircuitBreaker(dbType string, threshold int, openDuration time.Duration) *circuitBreaker {
+	if openDuration <= 0 {
+		openDuration = 10 * time.Second
+	}
+
+	return &circuitBreaker{
+		dbType:       dbType,
+		threshold:    threshold,
+		openDuration: openDuration,
+	}
+}
+
+// return nil without Config.BreakerThreshold
+//
+// caller must hold o.mutex
+func (o *GormDb[T]) getCircuitBreaker(dbType T) *circuitBreaker {
+	if o.config.BreakerThreshold <= 0 {
+		return nil
+	}
+
+	breaker, ok := o.breakers[dbType]
+	if !ok {
+		breaker = newCircuitBreaker(fmt.Sprint(dbType), o.config.BreakerThreshold, o.config.BreakerOpenDuration)
+		o.breakers[dbType] = breaker
+	}
+
+	return breaker
+}
+
+func (o *circuitBreaker) Name() string {
+	return "jf:breaker"
+}
+
+func (o *circuitBreaker) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("*").Register("jf:breaker", o.before),
+		callback.Create().After("*").Register("jf:breaker_after", o.after),
+		callback.Query().Before("*").Register("jf:breaker", o.before),
+		callback.Query().After("*").Register("jf:breaker_after", o.after),
+		callback.Update().Before("*").Register("jf:breaker", o.before),
+		callback.Update().After("*").Register("jf:breaker_after", o.after),
+		callback.Delete().Before("*").Register("jf:breaker", o.before),
+		callback.Delete().After("*").Register("jf:breaker_after", o.after),
+		callback.Row().Before("*").Register("jf:breaker", o.before),
+		callback.Row().After("*").Register("jf:breaker_after", o.after),
+		callback.Raw().Before("*").Register("jf:breaker", o.before),
+		callback.Raw().After("*").Register("jf:breaker_after", o.after),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+// a timeout or a broken connection means the database is unhealthy, other errors are answers of it
+func isBreakerFailure(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || isConnectionError(err)
+}
+
+func (o *circuitBreaker) openError() error {
+	return &CircuitOpenError{
+		DbType:  o.dbType,
+		RetryAt: o.openUntil,
+		Cause:   o.lastErr,
+	}
+}
+
+// return CircuitOpenError if open or the trial is running, not take the trial
+func (o *circuitBreaker) check() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.state != breakerClosed && time.Now().Before(o.openUntil) {
+		return o.openError()
+	}
+	return nil
+}
+
+// return the state name and the last error opened the breaker
+func (o *circuitBreaker) status() (string, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	state := o.state
+	// a statement will be let through as the trial
+	if state == breakerOpen && !time.Now().Before(o.openUntil) {
+		state = breakerHalfOpen
+	}
+	return state.String(), o.lastErr
+}
+
+// return true if the statement is the trial of half open
+//
+// a trial not finished in the open duration may hang, so another statement becomes the trial
+func (o *circuitBreaker) allow() (bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	switch o.state {
+	case breakerOpen, breakerHalfOpen:
+		now := time.Now()
+		if now.Before(o.openUntil) {
+			return false, o.openError()
+		}
+		o.state = breakerHalfOpen
+		o.openUntil = now.Add(o.openDuration)
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+func (o *circuitBreaker) record(trial bool, err error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	failed := isBreakerFailure(err)
+	if failed {
+		o.lastErr = err
+	}
+
+	if trial {
+		if failed {
+			o.state = breakerOpen
+			o.openUntil = time.Now().Add(o.openDuration)
+		} else {
+			o.state = breakerClosed
+			o.failures = 0
+		}
+		return
+	}
+
+	// statements started before the breaker opened
+	if o.state != breakerClosed {
+		return
+	}
+
+	if !failed {
+		o.failures = 0
+		return
+	}
+	o.failures++
+	if o.failures >= o.threshold {
+		o.state = breakerOpen
+		o.openUntil = time.Now().Add(o.openDuration)
+	}
+}
+
+func (o *circuitBreaker) before(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+
+	trial, err := o.allow()
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.Statement.Settings.Store(breakerTrialSettingKey, trial)
+}
+
+func (o *circuitBreaker) after(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(breakerTrialSettingKey)
+	if !ok {
+		return
+	}
+
+	o.record(v.(bool), db.Error)
+}
+
+// return CircuitOpenError if the circuit breaker of dbType is open
+func (o *GormDb[T]) checkBreaker(dbType T) error {
+	o.mutex.RLock()
+	breaker, ok := o.breakers[dbType]
+	o.mutex.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	return breaker.check()
+}
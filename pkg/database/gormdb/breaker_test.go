@@ -0,0 +1,150 @@
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	errAnswer := errors.New("duplicate key")
+
+	type step struct {
+		// statement result, ignored if the statement is not allowed
+		err error
+		// move the clock past the open duration before the statement
+		elapse bool
+		// the statement not finished, its result is never recorded
+		hang      bool
+		wantAllow bool
+		wantState string
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "open after threshold failures in a row",
+			steps: []step{
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "open"},
+				{wantAllow: false, wantState: "open"},
+			},
+		},
+		{
+			name: "success resets failures",
+			steps: []step{
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+			},
+		},
+		{
+			name: "answers of the database are not failures",
+			steps: []step{
+				{err: errAnswer, wantAllow: true, wantState: "closed"},
+				{err: errAnswer, wantAllow: true, wantState: "closed"},
+				{err: errAnswer, wantAllow: true, wantState: "closed"},
+			},
+		},
+		{
+			name: "trial succeeds",
+			steps: []step{
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "open"},
+				{elapse: true, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+			},
+		},
+		{
+			name: "trial fails",
+			steps: []step{
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "open"},
+				{elapse: true, err: context.DeadlineExceeded, wantAllow: true, wantState: "open"},
+				{wantAllow: false, wantState: "open"},
+			},
+		},
+		{
+			name: "trial hangs",
+			steps: []step{
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "closed"},
+				{err: context.DeadlineExceeded, wantAllow: true, wantState: "open"},
+				{elapse: true, hang: true, wantAllow: true, wantState: "half_open"},
+				{wantAllow: false, wantState: "half_open"},
+				{elapse: true, wantAllow: true, wantState: "closed"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker := newCircuitBreaker("test", 3, time.Hour)
+			for i, step := range tt.steps {
+				if step.elapse {
+					breaker.openUntil = time.Now().Add(-time.Second)
+					if state, _ := breaker.status(); state != "half_open" {
+						t.Fatalf("step:%d state:%s want:half_open", i, state)
+					}
+				}
+
+				trial, err := breaker.allow()
+				if allowed := err == nil; allowed != step.wantAllow {
+					t.Fatalf("step:%d allowed:%v want:%v", i, allowed, step.wantAllow)
+				}
+				if err != nil {
+					if !errors.Is(err, ErrCircuitOpen) {
+						t.Fatalf("step:%d err:%v want ErrCircuitOpen", i, err)
+					}
+				} else {
+					if trial {
+						// only one trial runs while half open
+						if _, err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+							t.Fatalf("step:%d second trial allowed", i)
+						}
+						if err := breaker.check(); !errors.Is(err, ErrCircuitOpen) {
+							t.Fatalf("step:%d check err:%v while the trial is running", i, err)
+						}
+					}
+					if !step.hang {
+						breaker.record(trial, step.err)
+					}
+				}
+
+				if state, _ := breaker.status(); state != step.wantState {
+					t.Fatalf("step:%d state:%s want:%s", i, state, step.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerLateResult(t *testing.T) {
+	breaker := newCircuitBreaker("test", 1, time.Hour)
+	if _, err := breaker.allow(); err != nil {
+		t.Fatalf("allow fail. err:%v", err)
+	}
+	if _, err := breaker.allow(); err != nil {
+		t.Fatalf("allow fail. err:%v", err)
+	}
+
+	breaker.record(false, context.DeadlineExceeded)
+	// a statement started before the breaker opened must not close it
+	breaker.record(false, nil)
+	state, err := breaker.status()
+	if state != "open" {
+		t.Fatalf("state:%s want:open", state)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("last err:%v want:%v", err, context.DeadlineExceeded)
+	}
+	if err := breaker.check(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("check err:%v want ErrCircuitOpen", err)
+	}
+}
@@ -0,0 +1,504 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CacheConfig struct {
+	// prefix of keys in the store, default is the table name.
+	// set it if caches of different db types share a store
+	Prefix string
+	// default is 1 minute
+	Ttl time.Duration
+	// not found is cached for this duration, 0 means not cache not found
+	NegativeTtl time.Duration
+	// timeout of reading the database on miss, default is 10 seconds.
+	// the read is shared by concurrent misses, so it is not canceled with ctx of Get
+	LoadTimeout time.Duration
+}
+
+type CacheMetric struct {
+	Prefix string
+	Hits   int64
+	// not found served by the store
+	NegativeHits int64
+	Misses       int64
+	// reads of the database, less than Misses when concurrent misses are collapsed
+	Loads      int64
+	LoadErrors int64
+	// invalidations of ids or the whole prefix
+	Invalidations int64
+	StoreErrors   int64
+}
+
+type cacheCounters struct {
+	hits          atomic.Int64
+	negativeHits  atomic.Int64
+	misses        atomic.Int64
+	loads         atomic.Int64
+	loadErrors    atomic.Int64
+	invalidations atomic.Int64
+	storeErrors   atomic.Int64
+}
+
+// a running load of a key, waited by concurrent misses of the key
+type cacheCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+// read-through cache of model M by primary key, saved as json in a CacheStore
+//
+// creates, updates and deletes of M by gorm invalidate the cache, see cachePlugin.
+// raw sql is not tracked, call Invalidate after it
+type Cache[M any] struct {
+	config     CacheConfig
+	store      CacheStore
+	table      string
+	primaryKey string
+	getTx      func(ctx context.Context) (*gorm.DB, bool)
+	getDb      func(ctx context.Context) (*gorm.DB, error)
+	log        *slog.Logger
+	// increased by each invalidation, a load started before it is not saved
+	generation atomic.Int64
+	// loads save under read lock, invalidations under write lock
+	storeMutex sync.RWMutex
+	callMutex  sync.Mutex
+	calls      map[string]*cacheCall
+	counters   cacheCounters
+}
+
+// invalidation of a cache by cachePlugin
+type cacheInvalidator interface {
+	getTable() string
+	// nil ids means every id
+	invalidate(ctx context.Context, ids []string)
+	Metric() *CacheMetric
+}
+
+// config may be nil. M must have a primary key
+func TryNewCache[M any, T comparable](gormDb *GormDb[T], dbType T, store CacheStore, config *CacheConfig) (*Cache[M], error) {
+	s, err := gormDb.parseSchema(new(M))
+	if err != nil {
+		return nil, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return nil, serr.Errorf("no primary key. table:%s", s.Table)
+	}
+
+	c := CacheConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.Prefix == "" {
+		c.Prefix = s.Table
+	}
+	if c.Ttl <= 0 {
+		c.Ttl = time.Minute
+	}
+	if c.LoadTimeout <= 0 {
+		c.LoadTimeout = 10 * time.Second
+	}
+
+	o := &Cache[M]{
+		config:     c,
+		store:      store,
+		table:      s.Table,
+		primaryKey: s.PrioritizedPrimaryField.DBName,
+		getTx: func(ctx context.Context) (*gorm.DB, bool) {
+			return gormDb.getTx(ctx, dbType)
+		},
+		getDb: func(ctx context.Context) (*gorm.DB, error) {
+			return gormDb.getDb(ctx, dbType)
+		},
+		log:   gormDb.log(),
+		calls: make(map[string]*cacheCall),
+	}
+
+	gormDb.mutex.Lock()
+	gormDb.getCachePlugin(dbType).add(o)
+	gormDb.mutex.Unlock()
+
+	return o, nil
+}
+
+// return a Cache or panic, see TryNewCache
+func NewCache[M any, T comparable](gormDb *GormDb[T], dbType T, store CacheStore, config *CacheConfig) *Cache[M] {
+	o, err := TryNewCache[M](gormDb, dbType, store, config)
+	if err != nil {
+		panic(err)
+	}
+
+	return o
+}
+
+func (o *Cache[M]) getTable() string {
+	return o.table
+}
+
+func (o *Cache[M]) key(id string) string {
+	return o.config.Prefix + ":" + id
+}
+
+// return the row of primary key id from the store, or read the primary database and save it on miss.
+// concurrent misses of the same id read the database once
+//
+// return gorm.ErrRecordNotFound (check by errors.Is) if not found.
+// in a transaction of WithTx the cache is bypassed, so uncommitted rows are not cached
+func (o *Cache[M]) Get(ctx context.Context, id interface{}) (*M, error) {
+	if tx, ok := o.getTx(ctx); ok {
+		return o.take(tx.WithContext(ctx), id)
+	}
+
+	key := o.key(fmt.Sprint(id))
+	value, ok, err := o.store.Get(ctx, key)
+	if err != nil {
+		o.storeError(ctx, err, key)
+	} else if ok {
+		if len(value) == 0 {
+			o.counters.negativeHits.Add(1)
+			return nil, serr.Wrap(gorm.ErrRecordNotFound)
+		}
+		if m, err := o.decode(value); err == nil {
+			o.counters.hits.Add(1)
+			return m, nil
+		}
+	}
+
+	o.counters.misses.Add(1)
+	value, err = o.load(ctx, key, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, serr.Wrap(gorm.ErrRecordNotFound)
+	}
+
+	return o.decode(value)
+}
+
+// remove ids from the store, for changes not made by gorm like raw sql
+func (o *Cache[M]) Invalidate(ctx context.Context, ids ...interface{}) error {
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, o.key(fmt.Sprint(id)))
+	}
+
+	o.storeMutex.Lock()
+	defer o.storeMutex.Unlock()
+
+	o.generation.Add(1)
+	o.counters.invalidations.Add(1)
+	if err := o.store.Delete(ctx, keys...); err != nil {
+		o.counters.storeErrors.Add(1)
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+// remove every id of the cache from the store
+func (o *Cache[M]) InvalidateAll(ctx context.Context) error {
+	o.storeMutex.Lock()
+	defer o.storeMutex.Unlock()
+
+	o.generation.Add(1)
+	o.counters.invalidations.Add(1)
+	if err := o.store.DeletePrefix(ctx, o.config.Prefix+":"); err != nil {
+		o.counters.storeErrors.Add(1)
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+func (o *Cache[M]) invalidate(ctx context.Context, ids []string) {
+	var err error
+	if ids == nil {
+		err = o.InvalidateAll(ctx)
+	} else {
+		values := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			values = append(values, id)
+		}
+		err = o.Invalidate(ctx, values...)
+	}
+
+	if err != nil {
+		o.log.WarnContext(ctx, "gormdb cache invalidate fail",
+			slog.Any("err", serr.ToJSON(err, true)),
+			slog.String("prefix", o.config.Prefix))
+	}
+}
+
+func (o *Cache[M]) Metric() *CacheMetric {
+	return &CacheMetric{
+		Prefix:        o.config.Prefix,
+		Hits:          o.counters.hits.Load(),
+		NegativeHits:  o.counters.negativeHits.Load(),
+		Misses:        o.counters.misses.Load(),
+		Loads:         o.counters.loads.Load(),
+		LoadErrors:    o.counters.loadErrors.Load(),
+		Invalidations: o.counters.invalidations.Load(),
+		StoreErrors:   o.counters.storeErrors.Load(),
+	}
+}
+
+func (o *Cache[M]) load(ctx context.Context, key string, id interface{}) ([]byte, error) {
+	generation := o.generation.Load()
+	// a miss after an invalidation not wait for the load started before it
+	callKey := fmt.Sprintf("%s@%d", key, generation)
+
+	o.callMutex.Lock()
+	call, ok := o.calls[callKey]
+	if !ok {
+		call = &cacheCall{
+			done: make(chan struct{}),
+		}
+		o.calls[callKey] = call
+
+		// a canceled caller does not cancel the load waited by others
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), o.config.LoadTimeout)
+		go func() {
+			defer cancel()
+			call.value, call.err = o.loadDb(loadCtx, key, id, generation)
+
+			o.callMutex.Lock()
+			delete(o.calls, callKey)
+			o.callMutex.Unlock()
+			close(call.done)
+		}()
+	}
+	o.callMutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		return nil, serr.Wrap(ctx.Err())
+	}
+}
+
+// return empty value if not found
+func (o *Cache[M]) loadDb(ctx context.Context, key string, id interface{}, generation int64) ([]byte, error) {
+	o.counters.loads.Add(1)
+
+	db, err := o.getDb(ctx)
+	if err != nil {
+		o.counters.loadErrors.Add(1)
+		return nil, err
+	}
+
+	// replicas may not have the change invalidated the cache yet
+	ttl := o.config.Ttl
+	value := []byte{}
+	m, err := o.take(db.WithContext(ForcePrimary(ctx)), id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if o.config.NegativeTtl <= 0 {
+			return value, nil
+		}
+		ttl = o.config.NegativeTtl
+	} else if err != nil {
+		o.counters.loadErrors.Add(1)
+		return nil, err
+	} else {
+		if value, err = json.Marshal(m); err != nil {
+			o.counters.loadErrors.Add(1)
+			return nil, serr.Wrap(err)
+		}
+	}
+
+	o.storeMutex.RLock()
+	defer o.storeMutex.RUnlock()
+
+	// the row may be changed during reading
+	if o.generation.Load() != generation {
+		return value, nil
+	}
+	if err := o.store.Set(ctx, key, value, ttl); err != nil {
+		o.storeError(ctx, err, key)
+	}
+
+	return value, nil
+}
+
+func (o *Cache[M]) take(db *gorm.DB, id interface{}) (*M, error) {
+	m := new(M)
+	column := clause.Column{Table: clause.CurrentTable, Name: o.primaryKey}
+	if err := db.Where(clause.Eq{Column: column, Value: id}).Take(m).Error; err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return m, nil
+}
+
+func (o *Cache[M]) decode(value []byte) (*M, error) {
+	m := new(M)
+	if err := json.Unmarshal(value, m); err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return m, nil
+}
+
+// the store is skipped on error, read the database instead
+func (o *Cache[M]) storeError(ctx context.Context, err error, key string) {
+	o.counters.storeErrors.Add(1)
+	o.log.WarnContext(ctx, "gormdb cache store fail",
+		slog.Any("err", serr.ToJSON(err, true)),
+		slog.String("key", key))
+}
+
+// a gorm plugin invalidates caches of the table after create, update and delete
+//
+// rows with primary keys in the statement model are invalidated, otherwise the whole cache.
+// in a transaction of WithTx they are invalidated again after commit,
+// since the old row may be cached again before commit
+type cachePlugin struct {
+	mutex sync.RWMutex
+	// table name to caches
+	caches map[string][]cacheInvalidator
+}
+
+// caller must hold o.mutex
+func (o *GormDb[T]) getCachePlugin(dbType T) *cachePlugin {
+	plugin, ok := o.caches[dbType]
+	if !ok {
+		plugin = &cachePlugin{
+			caches: make(map[string][]cacheInvalidator),
+		}
+		o.caches[dbType] = plugin
+	}
+
+	return plugin
+}
+
+func (o *cachePlugin) Name() string {
+	return "jf:cache"
+}
+
+func (o *cachePlugin) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().After("gorm:commit_or_rollback_transaction").Register("jf:cache", o.afterChange),
+		callback.Update().After("gorm:commit_or_rollback_transaction").Register("jf:cache", o.afterChange),
+		callback.Delete().After("gorm:commit_or_rollback_transaction").Register("jf:cache", o.afterChange),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *cachePlugin) add(cache cacheInvalidator) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	o.caches[cache.getTable()] = append(o.caches[cache.getTable()], cache)
+}
+
+func (o *cachePlugin) empty() bool {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return len(o.caches) == 0
+}
+
+func (o *cachePlugin) get(table string) []cacheInvalidator {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	return o.caches[table]
+}
+
+func (o *cachePlugin) snapshot() []*CacheMetric {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	result := []*CacheMetric{}
+	for _, caches := range o.caches {
+		for _, cache := range caches {
+			result = append(result, cache.Metric())
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Prefix < result[j].Prefix
+	})
+
+	return result
+}
+
+func (o *cachePlugin) afterChange(db *gorm.DB) {
+	if db.Error != nil || db.RowsAffected == 0 || db.Statement.Schema == nil {
+		return
+	}
+	caches := o.get(db.Statement.Table)
+	if len(caches) == 0 {
+		return
+	}
+
+	ctx := db.Statement.Context
+	ids := changedIds(db)
+	for _, cache := range caches {
+		cache.invalidate(ctx, ids)
+	}
+
+	if _, ok := db.Statement.ConnPool.(gorm.TxCommitter); ok {
+		addAfterCommit(ctx, func() {
+			for _, cache := range caches {
+				cache.invalidate(context.WithoutCancel(ctx), ids)
+			}
+		})
+	}
+}
+
+// primary keys of the statement model, nil if any row has no primary key
+func changedIds(db *gorm.DB) []string {
+	ctx := db.Statement.Context
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return nil
+	}
+
+	ids := []string{}
+	all := false
+	eachStruct(db.Statement.ReflectValue, func(rv reflect.Value) {
+		v, isZero := field.ValueOf(ctx, rv)
+		if isZero {
+			all = true
+			return
+		}
+		ids = append(ids, fmt.Sprint(v))
+	})
+	if all || len(ids) == 0 {
+		return nil
+	}
+
+	return ids
+}
+
+// return cache metrics of every db type, sorted by prefix
+func (o *GormDb[T]) CacheMetrics() map[T][]*CacheMetric {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	result := make(map[T][]*CacheMetric, len(o.caches))
+	for dbType, plugin := range o.caches {
+		result[dbType] = plugin.snapshot()
+	}
+
+	return result
+}
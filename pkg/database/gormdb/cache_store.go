@@ -0,0 +1,129 @@
+package gormdb
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// store of Cache, may be shared by processes like redis
+type CacheStore interface {
+	// return false if not exist or expired
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// ttl 0 means never expire
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+	DeletePrefix(ctx context.Context, prefix string) error
+}
+
+type lruEntry struct {
+	key      string
+	value    []byte
+	expireAt time.Time
+}
+
+// in process CacheStore, evict the least recently used key when full
+type LruCacheStore struct {
+	size  int
+	mutex sync.Mutex
+	list  *list.List
+	items map[string]*list.Element
+}
+
+var _ CacheStore = (*LruCacheStore)(nil)
+
+// size is the max number of keys, default is 10000
+func NewLruCacheStore(size int) *LruCacheStore {
+	if size <= 0 {
+		size = 10000
+	}
+
+	return &LruCacheStore{
+		size:  size,
+		list:  list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (o *LruCacheStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	element, ok := o.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		o.remove(element)
+		return nil, false, nil
+	}
+
+	o.list.MoveToFront(element)
+	return entry.value, true, nil
+}
+
+func (o *LruCacheStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	entry := &lruEntry{
+		key:   key,
+		value: value,
+	}
+	if ttl > 0 {
+		entry.expireAt = time.Now().Add(ttl)
+	}
+
+	if element, ok := o.items[key]; ok {
+		element.Value = entry
+		o.list.MoveToFront(element)
+		return nil
+	}
+
+	o.items[key] = o.list.PushFront(entry)
+	for o.list.Len() > o.size {
+		o.remove(o.list.Back())
+	}
+	return nil
+}
+
+func (o *LruCacheStore) Delete(ctx context.Context, keys ...string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for _, key := range keys {
+		if element, ok := o.items[key]; ok {
+			o.remove(element)
+		}
+	}
+	return nil
+}
+
+func (o *LruCacheStore) DeletePrefix(ctx context.Context, prefix string) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	for key, element := range o.items {
+		if strings.HasPrefix(key, prefix) {
+			o.remove(element)
+		}
+	}
+	return nil
+}
+
+// number of keys, include expired ones not evicted yet
+func (o *LruCacheStore) Len() int {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	return o.list.Len()
+}
+
+// caller must hold o.mutex
+func (o *LruCacheStore) remove(element *list.Element) {
+	o.list.Remove(element)
+	delete(o.items, element.Value.(*lruEntry).key)
+}
@@ -0,0 +1,126 @@
+package gormdb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+)
+
+func TestLruCacheStore(t *testing.T) {
+	ctx := context.Background()
+	set := func(store *gormdb.LruCacheStore, key string, ttl time.Duration) {
+		if err := store.Set(ctx, key, []byte(key+"_value"), ttl); err != nil {
+			t.Fatalf("set fail. err:%v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		size int
+		run  func(store *gormdb.LruCacheStore)
+		// keys expected to be found
+		want    []string
+		wantLen int
+	}{
+		{
+			name: "evict least recently set",
+			size: 2,
+			run: func(store *gormdb.LruCacheStore) {
+				set(store, "a", 0)
+				set(store, "b", 0)
+				set(store, "c", 0)
+			},
+			want:    []string{"b", "c"},
+			wantLen: 2,
+		},
+		{
+			name: "get refreshes",
+			size: 2,
+			run: func(store *gormdb.LruCacheStore) {
+				set(store, "a", 0)
+				set(store, "b", 0)
+				store.Get(ctx, "a")
+				set(store, "c", 0)
+			},
+			want:    []string{"a", "c"},
+			wantLen: 2,
+		},
+		{
+			name: "set again refreshes",
+			size: 2,
+			run: func(store *gormdb.LruCacheStore) {
+				set(store, "a", 0)
+				set(store, "b", 0)
+				set(store, "a", 0)
+				set(store, "c", 0)
+			},
+			want:    []string{"a", "c"},
+			wantLen: 2,
+		},
+		{
+			name: "expired",
+			size: 10,
+			run: func(store *gormdb.LruCacheStore) {
+				set(store, "a", time.Millisecond)
+				set(store, "b", time.Hour)
+				time.Sleep(5 * time.Millisecond)
+			},
+			want:    []string{"b"},
+			wantLen: 1,
+		},
+		{
+			name: "delete",
+			size: 10,
+			run: func(store *gormdb.LruCacheStore) {
+				set(store, "a", 0)
+				set(store, "b", 0)
+				set(store, "c", 0)
+				store.Delete(ctx, "a", "c", "missing")
+			},
+			want:    []string{"b"},
+			wantLen: 1,
+		},
+		{
+			name: "delete prefix",
+			size: 10,
+			run: func(store *gormdb.LruCacheStore) {
+				set(store, "user:1", 0)
+				set(store, "user:2", 0)
+				set(store, "item:1", 0)
+				store.DeletePrefix(ctx, "user:")
+			},
+			want:    []string{"item:1"},
+			wantLen: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := gormdb.NewLruCacheStore(tt.size)
+			tt.run(store)
+
+			got := []string{}
+			for _, key := range []string{"a", "b", "c", "user:1", "user:2", "item:1"} {
+				value, ok, err := store.Get(ctx, key)
+				if err != nil {
+					t.Fatalf("get fail. err:%v", err)
+				}
+				if !ok {
+					continue
+				}
+				if string(value) != key+"_value" {
+					t.Fatalf("value not match. key:%s value:%s", key, value)
+				}
+				got = append(got, key)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got:%v want:%v", got, tt.want)
+			}
+			if store.Len() != tt.wantLen {
+				t.Fatalf("len:%d want:%d", store.Len(), tt.wantLen)
+			}
+		})
+	}
+}
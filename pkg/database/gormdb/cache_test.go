@@ -0,0 +1,232 @@
+package gormdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type cacheItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+type cacheDbType int
+
+func (cacheDbType) GetTables() []interface{} {
+	return []interface{}{&cacheItem{}}
+}
+
+func getCacheItem(t *testing.T, ctx context.Context, cache *gormdb.Cache[cacheItem], id int64) string {
+	item, err := cache.Get(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "not found"
+	}
+	if err != nil {
+		t.Fatalf("get fail. err:%v", err)
+	}
+	return item.Name
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		name   string
+		config *gormdb.Config
+	}{
+		{
+			name: "default naming",
+		},
+		{
+			name:   "table prefix",
+			config: &gormdb.Config{NamingStrategy: schema.NamingStrategy{TablePrefix: "x_"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[cacheDbType](t, tt.config, 0)
+			cache := gormdb.NewCache[cacheItem](gormDb, 0, gormdb.NewLruCacheStore(100), &gormdb.CacheConfig{
+				NegativeTtl: time.Minute,
+			})
+			ctx := context.Background()
+			db := gormDb.GetDb(0)
+			if err := db.Create(&cacheItem{Id: 1, Name: "a"}).Error; err != nil {
+				t.Fatalf("create fail. err:%v", err)
+			}
+
+			steps := []struct {
+				name string
+				run  func() error
+				id   int64
+				want string
+				// hits, negative hits, misses and loads after the step
+				wantMetric [4]int64
+			}{
+				{
+					name:       "miss",
+					run:        func() error { return nil },
+					id:         1,
+					want:       "a",
+					wantMetric: [4]int64{0, 0, 1, 1},
+				},
+				{
+					name:       "hit",
+					run:        func() error { return nil },
+					id:         1,
+					want:       "a",
+					wantMetric: [4]int64{1, 0, 1, 1},
+				},
+				{
+					name: "invalidated by update",
+					run: func() error {
+						return db.Model(&cacheItem{Id: 1}).Update("name", "b").Error
+					},
+					id:         1,
+					want:       "b",
+					wantMetric: [4]int64{1, 0, 2, 2},
+				},
+				{
+					name:       "not found",
+					run:        func() error { return nil },
+					id:         2,
+					want:       "not found",
+					wantMetric: [4]int64{1, 0, 3, 3},
+				},
+				{
+					name:       "negative hit",
+					run:        func() error { return nil },
+					id:         2,
+					want:       "not found",
+					wantMetric: [4]int64{1, 1, 3, 3},
+				},
+				{
+					name: "invalidated by create",
+					run: func() error {
+						return db.Create(&cacheItem{Id: 2, Name: "c"}).Error
+					},
+					id:         2,
+					want:       "c",
+					wantMetric: [4]int64{1, 1, 4, 4},
+				},
+				{
+					name: "invalidated by delete",
+					run: func() error {
+						return db.Delete(&cacheItem{Id: 2}).Error
+					},
+					id:         2,
+					want:       "not found",
+					wantMetric: [4]int64{1, 1, 5, 5},
+				},
+				{
+					name: "raw sql not tracked",
+					run: func() error {
+						return db.Exec("UPDATE " + db.NamingStrategy.TableName("cacheItem") + " SET name = 'd'").Error
+					},
+					id:         1,
+					want:       "b",
+					wantMetric: [4]int64{2, 1, 5, 5},
+				},
+				{
+					name: "invalidate",
+					run: func() error {
+						return cache.Invalidate(ctx, 1)
+					},
+					id:         1,
+					want:       "d",
+					wantMetric: [4]int64{2, 1, 6, 6},
+				},
+			}
+			for _, step := range steps {
+				if err := step.run(); err != nil {
+					t.Fatalf("%s run fail. err:%v", step.name, err)
+				}
+				if got := getCacheItem(t, ctx, cache, step.id); got != step.want {
+					t.Fatalf("%s got:%s want:%s", step.name, got, step.want)
+				}
+				metric := cache.Metric()
+				if got := [4]int64{metric.Hits, metric.NegativeHits, metric.Misses, metric.Loads}; got != step.wantMetric {
+					t.Fatalf("%s metric:%v want:%v", step.name, got, step.wantMetric)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheInTx(t *testing.T) {
+	gormDb := gormdbtest.New[cacheDbType](t, nil, 0)
+	cache := gormdb.NewCache[cacheItem](gormDb, 0, gormdb.NewLruCacheStore(100), nil)
+
+	err := gormDb.WithTx(context.Background(), 0, func(ctx context.Context) error {
+		if err := gormDb.FromCtx(ctx, 0).Create(&cacheItem{Id: 1, Name: "a"}).Error; err != nil {
+			return err
+		}
+		// uncommitted row is read but not cached
+		if got := getCacheItem(t, ctx, cache, 1); got != "a" {
+			t.Fatalf("got:%s want:a", got)
+		}
+		return errTxTest
+	})
+	if !errors.Is(err, errTxTest) {
+		t.Fatalf("err:%v want:%v", err, errTxTest)
+	}
+
+	if got := getCacheItem(t, context.Background(), cache, 1); got != "not found" {
+		t.Fatalf("rolled back row cached. got:%s", got)
+	}
+}
+
+func TestCacheCanceledMiss(t *testing.T) {
+	gormDb := gormdbtest.New[cacheDbType](t, nil, 0)
+	cache := gormdb.NewCache[cacheItem](gormDb, 0, gormdb.NewLruCacheStore(100), nil)
+	db := gormDb.GetDb(0)
+	if err := db.Create(&cacheItem{Id: 1, Name: "a"}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+
+	// block the read of the database until released
+	loading := make(chan struct{}, 1)
+	release := make(chan struct{})
+	err := db.Callback().Query().Before("gorm:query").Register("test:block", func(db *gorm.DB) {
+		loading <- struct{}{}
+		<-release
+	})
+	if err != nil {
+		t.Fatalf("register fail. err:%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, err := cache.Get(ctx, 1)
+		firstErr <- err
+	}()
+	<-loading
+
+	secondName := make(chan string, 1)
+	go func() {
+		secondName <- getCacheItem(t, context.Background(), cache, 1)
+	}()
+
+	for cache.Metric().Misses != 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// the first caller gives up, the load it started still serves the second one
+	cancel()
+	if err := <-firstErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("first err:%v want:%v", err, context.Canceled)
+	}
+	close(release)
+	if got := <-secondName; got != "a" {
+		t.Fatalf("second got:%s want:a", got)
+	}
+	if metric := cache.Metric(); metric.Loads != 1 || metric.LoadErrors != 0 {
+		t.Fatalf("metric:%+v", metric)
+	}
+}
@@ -1,10 +1,84 @@
 package gormdb
 
+import (
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
 type Config struct {
+	// default is DialectMysql, T can override by DbTypeGetDialect
+	Dialect Dialect
+	// if set, replace DbTypeGetDialect and DbTypeGetDsn of every db type, and replicas are not used.
+	// dbType is a T, only set by gormdbtest through SetDsnOverride
+	dsnOverride func(dbType any, config *Config) (Dialect, string)
+
 	Host     string
 	Port     int
 	User     string
 	Password string
+	// if set, User and Password are replaced by the credential when building dsn
+	Credential CredentialProvider
+
+	// used by DbTypeGetReplicaDsns, see GetReplicaConfigs
+	ReplicaHosts []string
+	// default is ReplicaPolicyRoundRobin
+	ReplicaPolicy ReplicaPolicy
+	// a failed replica is out of rotation for this duration, default is 30 seconds
+	ReplicaRetryInterval time.Duration
+
+	// T can override by DbTypeGetPoolConfig
+	Pool PoolConfig
+
+	// retry times of WithTx on deadlock or lock wait timeout, default is 3, negative to disable
+	TxMaxRetry int
+	// backoff before the first retry of WithTx, doubled each retry, default is 50 milliseconds
+	TxRetryBackoff time.Duration
+
+	// dynamic db types are db types not passed to NewGormDb, except the default one.
+	// their pools may be closed, so get the gorm.DB by TryGetDb each time instead of keeping it
+	//
+	// close pools of dynamic db types unused for this duration, 0 means never
+	DynamicDbIdleTimeout time.Duration
+	// close the least recently used dynamic db type when open more than this, 0 means no limit
+	MaxDynamicDbs int
+	// pools of evicted dynamic db types are closed after this delay,
+	// so a gorm.DB got just before eviction can finish its work, default is 1 minute
+	DynamicDbCloseDelay time.Duration
+
+	// table and column names of models, default is schema.NamingStrategy{}
+	NamingStrategy schema.Namer
+
+	// default is slog.Default()
+	Log *slog.Logger
+	// default is logger.Warn
+	LogLevel logger.LogLevel
+	// default is 200 milliseconds, negative to disable
+	SlowQueryThreshold time.Duration
+
+	// retry times of connecting a db type, default is 0
+	ConnectMaxRetry int
+	// backoff before the first retry of connecting, doubled each retry, default is 1 second
+	ConnectRetryBackoff time.Duration
+	// default is 30 seconds
+	ConnectRetryMaxBackoff time.Duration
+
+	// ping connected db types every interval, 0 means not check. see GormDb.Health
+	HealthCheckInterval time.Duration
+	// default is 5 seconds
+	HealthCheckTimeout time.Duration
+
+	// timeout of each statement whose ctx has no earlier deadline, 0 means no timeout. see WithQueryTimeout
+	QueryTimeout time.Duration
+	// open the circuit breaker of a db type after this many timeouts or connection errors in a row,
+	// statements fail with CircuitOpenError while it is open. 0 means no breaker
+	BreakerThreshold int
+	// default is 10 seconds, then one statement is let through to try
+	BreakerOpenDuration time.Duration
 
 	AutoMigrate bool
+	// default is 1 minute
+	MigrateLockTimeout time.Duration
 }
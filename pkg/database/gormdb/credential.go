@@ -0,0 +1,228 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/MinamiKotoriCute/serr"
+	"github.com/go-sql-driver/mysql"
+)
+
+type Credential struct {
+	User     string
+	Password string
+}
+
+type CredentialProvider interface {
+	GetCredential(ctx context.Context) (*Credential, error)
+}
+
+type CredentialProviderFunc func(ctx context.Context) (*Credential, error)
+
+func (f CredentialProviderFunc) GetCredential(ctx context.Context) (*Credential, error) {
+	return f(ctx)
+}
+
+type StaticCredentialProvider Credential
+
+func (o *StaticCredentialProvider) GetCredential(ctx context.Context) (*Credential, error) {
+	return &Credential{
+		User:     o.User,
+		Password: o.Password,
+	}, nil
+}
+
+// the password is the content of PasswordPath without trailing newline,
+// read again each time new credential is needed, so a rotated file is picked up
+type FileCredentialProvider struct {
+	User         string
+	PasswordPath string
+}
+
+func (o *FileCredentialProvider) GetCredential(ctx context.Context) (*Credential, error) {
+	data, err := os.ReadFile(o.PasswordPath)
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return &Credential{
+		User:     o.User,
+		Password: strings.TrimRight(string(data), "\r\n"),
+	}, nil
+}
+
+// password=xxx or password='xxx'
+var keyValueDsnPasswordRegexp = regexp.MustCompile(`(?i)(password=)('[^']*'|\S*)`)
+
+// replace the password in dsn with ***, for error and log
+func RedactDsn(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			return u.Redacted()
+		}
+	}
+
+	dsn = keyValueDsnPasswordRegexp.ReplaceAllString(dsn, "${1}***")
+	return redactMysqlDsn(dsn)
+}
+
+// user:password@tcp(host:port)/database, split like mysql.ParseDSN:
+// the last '/' starts the database, the last '@' before it ends the password, so the password may have '@' or ':'
+func redactMysqlDsn(dsn string) string {
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return dsn
+	}
+	at := strings.LastIndex(dsn[:slash], "@")
+	if at < 0 {
+		return dsn
+	}
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 || strings.ContainsAny(dsn[:colon], "/@") {
+		return dsn
+	}
+
+	return dsn[:colon+1] + "***" + dsn[at:]
+}
+
+// the database rejects the user or password
+func isAuthError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// 1045: access denied for user
+		return mysqlErr.Number == 1045
+	}
+
+	// postgresql error
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		switch stateErr.SQLState() {
+		case "28000", // invalid_authorization_specification
+			"28P01": // invalid_password
+			return true
+		}
+	}
+
+	return false
+}
+
+// a driver.Connector build dsn with credential of provider for every new connection,
+// so credential rotation not need to rebuild the pool
+//
+// credential is cached, and refreshed once when the database rejects it
+type credentialConnector struct {
+	driver     driver.Driver
+	config     *Config
+	provider   CredentialProvider
+	getDsn     func(config *Config) string
+	mutex      sync.Mutex
+	credential *Credential
+}
+
+func (o *GormDb[T]) newCredentialConnector(dialect Dialect, getDsn func(config *Config) string) (*credentialConnector, error) {
+	// sql.Open not connect, only to get the driver registered by name
+	db, err := sql.Open(dialect.driverName(), "")
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+	drv := db.Driver()
+	db.Close()
+
+	provider := o.config.Credential
+	if provider == nil {
+		provider = &StaticCredentialProvider{
+			User:     o.config.User,
+			Password: o.config.Password,
+		}
+	}
+
+	return &credentialConnector{
+		driver:   drv,
+		config:   o.config,
+		provider: provider,
+		getDsn:   getDsn,
+	}, nil
+}
+
+func (o *credentialConnector) buildDsn(credential *Credential) string {
+	c := *o.config
+	c.User = credential.User
+	c.Password = credential.Password
+	return o.getDsn(&c)
+}
+
+// return dsn of the cached credential, get a new credential if refresh or not cached
+func (o *credentialConnector) dsn(ctx context.Context, refresh bool) (string, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.credential == nil || refresh {
+		credential, err := o.provider.GetCredential(ctx)
+		if err != nil {
+			return "", err
+		}
+		o.credential = credential
+	}
+
+	return o.buildDsn(o.credential), nil
+}
+
+// dsn without password, for error and log
+//
+// the user is of the credential of provider, Config.User before the first credential is got
+func (o *credentialConnector) redactedDsn() string {
+	o.mutex.Lock()
+	user := o.config.User
+	if o.credential != nil {
+		user = o.credential.User
+	}
+	o.mutex.Unlock()
+
+	return RedactDsn(o.buildDsn(&Credential{User: user, Password: "***"}))
+}
+
+func (o *credentialConnector) open(ctx context.Context, dsn string) (driver.Conn, error) {
+	if d, ok := o.driver.(driver.DriverContext); ok {
+		connector, err := d.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+
+	return o.driver.Open(dsn)
+}
+
+func (o *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn, err := o.dsn(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := o.open(ctx, dsn)
+	if err == nil || !isAuthError(err) {
+		return conn, err
+	}
+
+	// credential may be rotated
+	newDsn, refreshErr := o.dsn(ctx, true)
+	if refreshErr != nil {
+		return nil, errors.Join(err, refreshErr)
+	}
+	if newDsn == dsn {
+		return nil, err
+	}
+
+	return o.open(ctx, newDsn)
+}
+
+func (o *credentialConnector) Driver() driver.Driver {
+	return o.driver
+}
@@ -0,0 +1,57 @@
+package gormdb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRedactedDsnUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider CredentialProvider
+		connect  bool
+		want     string
+	}{
+		{
+			name: "config",
+			want: "root:***@tcp(db:3306)/game?charset=utf8mb4&parseTime=True&loc=UTC",
+		},
+		{
+			name:     "provider",
+			provider: &StaticCredentialProvider{User: "rotated", Password: "p@ss"},
+			connect:  true,
+			want:     "rotated:***@tcp(db:3306)/game?charset=utf8mb4&parseTime=True&loc=UTC",
+		},
+		{
+			name:     "provider not called yet",
+			provider: &StaticCredentialProvider{User: "rotated", Password: "p@ss"},
+			want:     "root:***@tcp(db:3306)/game?charset=utf8mb4&parseTime=True&loc=UTC",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := NewGormDb[int](&Config{
+				Host:       "db",
+				Port:       3306,
+				User:       "root",
+				Password:   "secret",
+				Credential: tt.provider,
+			})
+			connector, err := gormDb.newCredentialConnector(DialectMysql, func(config *Config) string {
+				return GetMysqlDsn("game", config)
+			})
+			if err != nil {
+				t.Fatalf("new connector fail. err:%v", err)
+			}
+			if tt.connect {
+				if _, err := connector.dsn(context.Background(), false); err != nil {
+					t.Fatalf("dsn fail. err:%v", err)
+				}
+			}
+
+			if got := connector.redactedDsn(); got != tt.want {
+				t.Fatalf("got:%s want:%s", got, tt.want)
+			}
+		})
+	}
+}
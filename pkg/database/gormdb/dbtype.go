@@ -1,6 +1,9 @@
 package gormdb
 
-import "fmt"
+import (
+	"fmt"
+	"path/filepath"
+)
 
 type DbTypeGetDatabase interface {
 	GetDatabase() string
@@ -10,13 +13,24 @@ type DbTypeGetTables interface {
 	GetTables() []interface{}
 }
 
+type DbTypeGetDialect interface {
+	GetDialect() Dialect
+}
+
 // refer: https://gorm.io/zh_CN/docs/connecting_to_the_database.html
 type DbTypeGetDsn interface {
 	GetDsn(config *Config) string
 }
 
+// dsn of the database server without the database of the db type selected, used to create the database.
+// default is built from config by the dialect of the db type, see GetMysqlDsn and GetPostgresqlDsn
+type DbTypeGetServerDsn interface {
+	GetServerDsn(config *Config) string
+}
+
+// times are scanned into time.Time in UTC, like AppliedAt of SchemaMigration
 func GetMysqlDsn(databaseName string, config *Config) string {
-	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4",
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=True&loc=UTC",
 		config.User,
 		config.Password,
 		config.Host,
@@ -24,6 +38,11 @@ func GetMysqlDsn(databaseName string, config *Config) string {
 		databaseName)
 }
 
+// config.Host is the directory of the database file
+func GetSqliteDsn(databaseName string, config *Config) string {
+	return filepath.Join(config.Host, databaseName+".db")
+}
+
 func GetPostgresqlDsn(databaseName string, config *Config) string {
 	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=Asia/Shanghai",
 		config.Host,
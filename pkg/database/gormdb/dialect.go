@@ -0,0 +1,134 @@
+package gormdb
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Dialect string
+
+const (
+	DialectMysql      Dialect = "mysql"
+	DialectPostgresql Dialect = "postgres"
+	DialectSqlite     Dialect = "sqlite"
+)
+
+func (d Dialect) Open(dsn string) (gorm.Dialector, error) {
+	switch d {
+	case "", DialectMysql:
+		return mysql.Open(dsn), nil
+	case DialectPostgresql:
+		return postgres.Open(dsn), nil
+	case DialectSqlite:
+		return sqlite.Open(dsn), nil
+	default:
+		return nil, serr.Errorf("unknown dialect:%s", d)
+	}
+}
+
+// open on an existing pool, dsn is only parsed for settings
+func (d Dialect) openConn(dsn string, conn gorm.ConnPool) (gorm.Dialector, error) {
+	switch d {
+	case "", DialectMysql:
+		return mysql.New(mysql.Config{DSN: dsn, Conn: conn}), nil
+	case DialectPostgresql:
+		return postgres.New(postgres.Config{DSN: dsn, Conn: conn}), nil
+	case DialectSqlite:
+		return &sqlite.Dialector{DSN: dsn, Conn: conn}, nil
+	default:
+		return nil, serr.Errorf("unknown dialect:%s", d)
+	}
+}
+
+// the database/sql driver name registered by the gorm driver
+func (d Dialect) driverName() string {
+	switch d {
+	case DialectPostgresql:
+		return "pgx"
+	case DialectSqlite:
+		return sqlite.DriverName
+	default:
+		return "mysql"
+	}
+}
+
+// dsn of the server without database selected, postgresql always connects to a database
+func (d Dialect) serverDsn(config *Config) string {
+	switch d {
+	case DialectPostgresql:
+		return GetPostgresqlDsn("postgres", config)
+	case DialectSqlite:
+		return ""
+	default:
+		return GetMysqlDsn("", config)
+	}
+}
+
+// db is a connection of dialect d without database selected
+//
+// sqlite creates the database file on open, so db is not used
+func (d Dialect) createDatabaseIfNotExist(db *gorm.DB, databaseName string) error {
+	// QuoteTo treats a dot as the separator of a qualified name
+	if strings.Contains(databaseName, ".") {
+		return serr.Errorf("database name contains dot. database_name:%s", databaseName)
+	}
+	quoted := strings.Builder{}
+	db.Dialector.QuoteTo(&quoted, databaseName)
+
+	switch d {
+	case "", DialectMysql:
+		sql := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", quoted.String())
+		if err := db.Exec(sql).Error; err != nil {
+			return serr.Wrapf(err, "sql: %s", sql)
+		}
+	case DialectPostgresql:
+		// postgresql not support CREATE DATABASE IF NOT EXISTS
+		var count int64
+		if err := db.Raw("SELECT COUNT(*) FROM pg_database WHERE datname = ?", databaseName).Scan(&count).Error; err != nil {
+			return serr.Wrap(err)
+		}
+		if count != 0 {
+			return nil
+		}
+
+		sql := fmt.Sprintf("CREATE DATABASE %s", quoted.String())
+		if err := db.Exec(sql).Error; err != nil {
+			return serr.Wrapf(err, "sql: %s", sql)
+		}
+	case DialectSqlite:
+	default:
+		return serr.Errorf("unknown dialect:%s", d)
+	}
+
+	return nil
+}
+
+// create table with the columns of source if not exist. indexes of source are not copied,
+// their names are unique in a database of postgresql and sqlite, except mysql whose index names belong to the table
+func (d Dialect) createTableLike(db *gorm.DB, table string, source string) error {
+	switch d {
+	case "", DialectMysql:
+		if err := db.Exec("CREATE TABLE IF NOT EXISTS ? LIKE ?", clause.Table{Name: table}, clause.Table{Name: source}).Error; err != nil {
+			return serr.Wrapf(err, "table:%s", table)
+		}
+	case DialectPostgresql:
+		if err := db.Exec("CREATE TABLE IF NOT EXISTS ? (LIKE ?)", clause.Table{Name: table}, clause.Table{Name: source}).Error; err != nil {
+			return serr.Wrapf(err, "table:%s", table)
+		}
+	case DialectSqlite:
+		if err := db.Exec("CREATE TABLE IF NOT EXISTS ? AS SELECT * FROM ? WHERE 0", clause.Table{Name: table}, clause.Table{Name: source}).Error; err != nil {
+			return serr.Wrapf(err, "table:%s", table)
+		}
+	default:
+		return serr.Errorf("unknown dialect:%s", d)
+	}
+
+	return nil
+}
@@ -0,0 +1,221 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// a database/sql driver records statements, every query returns one row of 0
+type recordDriver struct {
+	mutex   sync.Mutex
+	queries []string
+}
+
+func (o *recordDriver) Open(name string) (driver.Conn, error) {
+	return &recordConn{driver: o}, nil
+}
+
+func (o *recordDriver) record(query string, args []driver.Value) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(args) != 0 {
+		query = fmt.Sprintf("%s %v", query, args)
+	}
+	o.queries = append(o.queries, query)
+}
+
+type recordConn struct {
+	driver *recordDriver
+}
+
+func (o *recordConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordStmt{conn: o, query: query}, nil
+}
+
+func (o *recordConn) Close() error {
+	return nil
+}
+
+func (o *recordConn) Begin() (driver.Tx, error) {
+	return nil, driver.ErrSkip
+}
+
+type recordStmt struct {
+	conn  *recordConn
+	query string
+}
+
+func (o *recordStmt) Close() error {
+	return nil
+}
+
+func (o *recordStmt) NumInput() int {
+	return -1
+}
+
+func (o *recordStmt) Exec(args []driver.Value) (driver.Result, error) {
+	o.conn.driver.record(o.query, args)
+	return driver.RowsAffected(0), nil
+}
+
+func (o *recordStmt) Query(args []driver.Value) (driver.Rows, error) {
+	o.conn.driver.record(o.query, args)
+	return &recordRows{}, nil
+}
+
+type recordRows struct {
+	done bool
+}
+
+func (o *recordRows) Columns() []string {
+	return []string{"count"}
+}
+
+func (o *recordRows) Close() error {
+	return nil
+}
+
+func (o *recordRows) Next(dest []driver.Value) error {
+	if o.done {
+		return io.EOF
+	}
+	o.done = true
+	dest[0] = int64(0)
+	return nil
+}
+
+var recorder = &recordDriver{}
+
+func init() {
+	sql.Register("gormdb_record", recorder)
+}
+
+func TestCreateDatabaseIfNotExist(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		dialect      Dialect
+		databaseName string
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:         "mysql",
+			dialect:      DialectMysql,
+			databaseName: "game",
+			want:         "[CREATE DATABASE IF NOT EXISTS `game`]",
+		},
+		{
+			name:         "postgres",
+			dialect:      DialectPostgresql,
+			databaseName: "game",
+			want:         `[SELECT COUNT(*) FROM pg_database WHERE datname = $1 [game] CREATE DATABASE "game"]`,
+		},
+		{
+			name:         "dot",
+			dialect:      DialectMysql,
+			databaseName: "game.x",
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlDb, err := sql.Open("gormdb_record", "")
+			if err != nil {
+				t.Fatalf("open fail. err:%v", err)
+			}
+			defer sqlDb.Close()
+
+			var dialector gorm.Dialector
+			if tt.dialect == DialectPostgresql {
+				dialector = postgres.New(postgres.Config{Conn: sqlDb})
+			} else {
+				dialector = mysql.New(mysql.Config{Conn: sqlDb, SkipInitializeWithVersion: true})
+			}
+			db, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true})
+			if err != nil {
+				t.Fatalf("gorm open fail. err:%v", err)
+			}
+
+			recorder.mutex.Lock()
+			recorder.queries = nil
+			recorder.mutex.Unlock()
+			err = tt.dialect.createDatabaseIfNotExist(db.WithContext(context.Background()), tt.databaseName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got := fmt.Sprint(recorder.queries); got != tt.want {
+				t.Fatalf("got:%s want:%s", got, tt.want)
+			}
+		})
+	}
+}
+
+type mixedDialectDbType int
+
+const (
+	mixedDialectDefault mixedDialectDbType = iota
+	mixedDialectPostgres
+)
+
+func (o mixedDialectDbType) GetDialect() Dialect {
+	if o == mixedDialectPostgres {
+		return DialectPostgresql
+	}
+	return DialectMysql
+}
+
+func TestServerDsnMixedDialect(t *testing.T) {
+	gormDb := NewGormDb[mixedDialectDbType](&Config{
+		Dialect:  DialectMysql,
+		Host:     "db",
+		Port:     3306,
+		User:     "root",
+		Password: "secret",
+	})
+
+	tests := []struct {
+		name        string
+		dbType      mixedDialectDbType
+		wantDialect Dialect
+		wantDsn     string
+	}{
+		{
+			name:        "default mysql",
+			dbType:      mixedDialectDefault,
+			wantDialect: DialectMysql,
+			wantDsn:     "root:secret@tcp(db:3306)/?charset=utf8mb4&parseTime=True&loc=UTC",
+		},
+		{
+			name:        "postgres db type",
+			dbType:      mixedDialectPostgres,
+			wantDialect: DialectPostgresql,
+			wantDsn:     "host=db user=root password=secret dbname=postgres port=3306 sslmode=disable TimeZone=Asia/Shanghai",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialect, getDsn := gormDb.getServerDsnFunc(tt.dbType)
+			if dialect != tt.wantDialect {
+				t.Fatalf("dialect:%s want:%s", dialect, tt.wantDialect)
+			}
+			if dsn := getDsn(gormDb.config); dsn != tt.wantDsn {
+				t.Fatalf("dsn:%s want:%s", dsn, tt.wantDsn)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type DriftKind string
+
+const (
+	DriftMissingTable  DriftKind = "missing_table"
+	DriftMissingColumn DriftKind = "missing_column"
+	DriftExtraColumn   DriftKind = "extra_column"
+	DriftTypeMismatch  DriftKind = "type_mismatch"
+	DriftMissingIndex  DriftKind = "missing_index"
+	DriftExtraIndex    DriftKind = "extra_index"
+	DriftIndexMismatch DriftKind = "index_mismatch"
+)
+
+// a difference between a model of T.GetTables() and the live schema
+type Drift struct {
+	Kind   DriftKind `json:"kind"`
+	Table  string    `json:"table"`
+	Column string    `json:"column,omitempty"`
+	Index  string    `json:"index,omitempty"`
+	// from the model
+	Expected string `json:"expected,omitempty"`
+	// from the live schema
+	Actual string `json:"actual,omitempty"`
+}
+
+func (o *Drift) String() string {
+	switch o.Kind {
+	case DriftMissingTable:
+		return fmt.Sprintf("missing table %s", o.Table)
+	case DriftMissingColumn:
+		return fmt.Sprintf("missing column %s.%s %s", o.Table, o.Column, o.Expected)
+	case DriftExtraColumn:
+		return fmt.Sprintf("extra column %s.%s %s", o.Table, o.Column, o.Actual)
+	case DriftTypeMismatch:
+		return fmt.Sprintf("type mismatch %s.%s expected:%s actual:%s", o.Table, o.Column, o.Expected, o.Actual)
+	case DriftMissingIndex:
+		return fmt.Sprintf("missing index %s.%s %s", o.Table, o.Index, o.Expected)
+	case DriftExtraIndex:
+		return fmt.Sprintf("extra index %s.%s %s", o.Table, o.Index, o.Actual)
+	case DriftIndexMismatch:
+		return fmt.Sprintf("index mismatch %s.%s expected:%s actual:%s", o.Table, o.Index, o.Expected, o.Actual)
+	default:
+		return fmt.Sprintf("%s %s", o.Kind, o.Table)
+	}
+}
+
+type DriftReport struct {
+	DbType string   `json:"db_type"`
+	Drifts []*Drift `json:"drifts"`
+}
+
+func (o *DriftReport) HasDrift() bool {
+	return len(o.Drifts) != 0
+}
+
+// human-readable report, one drift per line
+func (o *DriftReport) String() string {
+	sb := strings.Builder{}
+	if !o.HasDrift() {
+		fmt.Fprintf(&sb, "db type %s: no drift\n", o.DbType)
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "db type %s: %d drifts\n", o.DbType, len(o.Drifts))
+	for _, drift := range o.Drifts {
+		fmt.Fprintf(&sb, "  %s\n", drift)
+	}
+	return sb.String()
+}
+
+// compare T.GetTables() with the live schema of dbType, nothing is changed
+//
+// dbType not connected yet is connected without AutoMigrate and closed after,
+// so the report shows what AutoMigrate would change
+func (o *GormDb[T]) CheckDrift(ctx context.Context, dbType T) (*DriftReport, error) {
+	report := &DriftReport{
+		DbType: fmt.Sprint(dbType),
+		Drifts: []*Drift{},
+	}
+
+	t, ok := any(dbType).(DbTypeGetTables)
+	if !ok {
+		return report, nil
+	}
+
+	o.mutex.RLock()
+	db, ok := o.db[dbType]
+	o.mutex.RUnlock()
+	if !ok {
+		// not published, so getDb not take the unmigrated db
+		conn, err := o.connect(ctx, dbType)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.close()
+		db = conn.db
+	}
+
+	// schema must be read from the primary
+	db = db.WithContext(ForcePrimary(ctx))
+	for _, table := range t.GetTables() {
+		drifts, err := diffTable(db, table)
+		if err != nil {
+			return nil, err
+		}
+		report.Drifts = append(report.Drifts, drifts...)
+	}
+
+	return report, nil
+}
+
+func diffTable(db *gorm.DB, model interface{}) ([]*Drift, error) {
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, serr.Wrap(err)
+	}
+	s := stmt.Schema
+
+	migrator := db.Migrator()
+	if !migrator.HasTable(model) {
+		return []*Drift{{Kind: DriftMissingTable, Table: s.Table}}, nil
+	}
+
+	columnTypes, err := migrator.ColumnTypes(model)
+	if err != nil {
+		return nil, serr.Wrapf(err, "table:%s", s.Table)
+	}
+	liveColumns := make(map[string]gorm.ColumnType, len(columnTypes))
+	for _, columnType := range columnTypes {
+		liveColumns[columnType.Name()] = columnType
+	}
+
+	drifts := []*Drift{}
+	for _, dbName := range s.DBNames {
+		field := s.FieldsByDBName[dbName]
+		if field.IgnoreMigration {
+			continue
+		}
+
+		expected := strings.ToLower(migrator.FullDataTypeOf(field).SQL)
+		columnType, ok := liveColumns[dbName]
+		if !ok {
+			drifts = append(drifts, &Drift{Kind: DriftMissingColumn, Table: s.Table, Column: dbName, Expected: expected})
+			continue
+		}
+
+		if !isSameColumnType(db, field, columnType) {
+			drifts = append(drifts, &Drift{Kind: DriftTypeMismatch, Table: s.Table, Column: dbName, Expected: expected, Actual: liveColumnType(columnType)})
+		}
+	}
+
+	for _, columnType := range columnTypes {
+		if _, ok := s.FieldsByDBName[columnType.Name()]; !ok {
+			drifts = append(drifts, &Drift{Kind: DriftExtraColumn, Table: s.Table, Column: columnType.Name(), Actual: liveColumnType(columnType)})
+		}
+	}
+
+	return append(drifts, diffIndexes(db, s, model)...), nil
+}
+
+// same check as gorm Migrator.MigrateColumn, only type and size
+func isSameColumnType(db *gorm.DB, field *schema.Field, columnType gorm.ColumnType) bool {
+	// primary key type is decided by the database, e.g. bigserial is int8 in postgresql
+	if field.PrimaryKey {
+		return true
+	}
+
+	expected := strings.ToLower(db.Dialector.DataTypeOf(field))
+	actual := strings.ToLower(columnType.DatabaseTypeName())
+	if !strings.HasPrefix(expected, actual) {
+		isAlias := false
+		for _, alias := range db.Migrator().GetTypeAliases(actual) {
+			if strings.HasPrefix(expected, alias) {
+				isAlias = true
+				break
+			}
+		}
+		if !isAlias {
+			return false
+		}
+	}
+
+	if length, ok := columnType.Length(); ok && length > 0 && field.Size > 0 && length != int64(field.Size) {
+		return false
+	}
+
+	return true
+}
+
+func liveColumnType(columnType gorm.ColumnType) string {
+	if t, ok := columnType.ColumnType(); ok && t != "" {
+		return strings.ToLower(t)
+	}
+
+	return strings.ToLower(columnType.DatabaseTypeName())
+}
+
+func formatIndex(columns []string, unique bool) string {
+	s := "(" + strings.Join(columns, ",") + ")"
+	if unique {
+		s += " unique"
+	}
+	return s
+}
+
+// sqlite not support reading indexes, only missing indexes are reported
+func diffIndexes(db *gorm.DB, s *schema.Schema, model interface{}) []*Drift {
+	migrator := db.Migrator()
+	indexes := s.ParseIndexes()
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	liveIndexes := map[string]gorm.Index{}
+	canReadIndexes := true
+	if list, err := migrator.GetIndexes(model); err == nil {
+		for _, index := range list {
+			liveIndexes[index.Name()] = index
+		}
+	} else {
+		canReadIndexes = false
+	}
+
+	drifts := []*Drift{}
+	for _, name := range names {
+		index := indexes[name]
+		columns := make([]string, 0, len(index.Fields))
+		for _, field := range index.Fields {
+			if field.Expression != "" {
+				columns = append(columns, field.Expression)
+			} else {
+				columns = append(columns, field.DBName)
+			}
+		}
+		expected := formatIndex(columns, index.Class == "UNIQUE")
+
+		if !canReadIndexes {
+			if !migrator.HasIndex(model, name) {
+				drifts = append(drifts, &Drift{Kind: DriftMissingIndex, Table: s.Table, Index: name, Expected: expected})
+			}
+			continue
+		}
+
+		liveIndex, ok := liveIndexes[name]
+		if !ok {
+			drifts = append(drifts, &Drift{Kind: DriftMissingIndex, Table: s.Table, Index: name, Expected: expected})
+			continue
+		}
+		delete(liveIndexes, name)
+
+		unique, _ := liveIndex.Unique()
+		if actual := formatIndex(liveIndex.Columns(), unique); actual != expected {
+			drifts = append(drifts, &Drift{Kind: DriftIndexMismatch, Table: s.Table, Index: name, Expected: expected, Actual: actual})
+		}
+	}
+
+	extraNames := make([]string, 0, len(liveIndexes))
+	for name, liveIndex := range liveIndexes {
+		if isPrimaryKey, _ := liveIndex.PrimaryKey(); isPrimaryKey {
+			continue
+		}
+		// created by the unique tag of a field, not an index of the model
+		if columns := liveIndex.Columns(); len(columns) == 1 {
+			if field, ok := s.FieldsByDBName[columns[0]]; ok && field.Unique {
+				continue
+			}
+		}
+		extraNames = append(extraNames, name)
+	}
+	sort.Strings(extraNames)
+	for _, name := range extraNames {
+		liveIndex := liveIndexes[name]
+		unique, _ := liveIndex.Unique()
+		drifts = append(drifts, &Drift{Kind: DriftExtraIndex, Table: s.Table, Index: name, Actual: formatIndex(liveIndex.Columns(), unique)})
+	}
+
+	return drifts
+}
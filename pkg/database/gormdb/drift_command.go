@@ -0,0 +1,79 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+var ErrSchemaDrift = errors.New("schema drift")
+
+// a small command to call from main of the application, e.g. `app schema-drift -format json`
+//
+//	-format text|json  default text
+//	-db-type name      only check the db type whose fmt.Sprint is name, default all db types passed to NewGormDb
+//	-exit-code         return ErrSchemaDrift if any drift is found, for CI
+func RunDriftCommand[T comparable](ctx context.Context, gormDb *GormDb[T], args []string, w io.Writer) error {
+	flagSet := flag.NewFlagSet("schema-drift", flag.ContinueOnError)
+	flagSet.SetOutput(w)
+	format := flagSet.String("format", "text", "text or json")
+	dbTypeName := flagSet.String("db-type", "", "only check this db type")
+	exitCode := flagSet.Bool("exit-code", false, "return error if any drift is found")
+	if err := flagSet.Parse(args); err != nil {
+		return serr.Wrap(err)
+	}
+	if *format != "text" && *format != "json" {
+		return serr.Errorf("unknown format:%s", *format)
+	}
+
+	dbTypes := gormDb.dbTypes
+	if len(dbTypes) == 0 {
+		var defaultDbType T
+		dbTypes = []T{defaultDbType}
+	}
+
+	reports := []*DriftReport{}
+	for _, dbType := range dbTypes {
+		if *dbTypeName != "" && fmt.Sprint(dbType) != *dbTypeName {
+			continue
+		}
+
+		report, err := gormDb.CheckDrift(ctx, dbType)
+		if err != nil {
+			return serr.Wrapf(err, "db type:%v", dbType)
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) == 0 {
+		return serr.Errorf("db type not found. db_type:%s", *dbTypeName)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			return serr.Wrap(err)
+		}
+	} else {
+		for _, report := range reports {
+			if _, err := io.WriteString(w, report.String()); err != nil {
+				return serr.Wrap(err)
+			}
+		}
+	}
+
+	if *exitCode {
+		for _, report := range reports {
+			if report.HasDrift() {
+				return ErrSchemaDrift
+			}
+		}
+	}
+
+	return nil
+}
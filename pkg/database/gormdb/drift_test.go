@@ -0,0 +1,209 @@
+package gormdb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+)
+
+type driftItem struct {
+	Id    int64  `gorm:"primaryKey"`
+	Name  string `gorm:"size:32"`
+	Level int    `gorm:"index"`
+}
+
+type driftDbType int
+
+// tables of the running test, empty while gormdbtest migrates
+var driftTestTables []interface{}
+
+func (driftDbType) GetTables() []interface{} {
+	return driftTestTables
+}
+
+// a GormDb of driftDbType whose live schema is built by statements, not by the model
+func newDriftTestDb(t *testing.T, statements ...string) *gormdb.GormDb[driftDbType] {
+	driftTestTables = nil
+	gormDb := gormdbtest.New[driftDbType](t, nil, 0)
+	for _, statement := range statements {
+		if err := gormDb.GetDb(0).Exec(statement).Error; err != nil {
+			t.Fatalf("exec fail. statement:%s err:%v", statement, err)
+		}
+	}
+
+	driftTestTables = []interface{}{&driftItem{}}
+	t.Cleanup(func() {
+		driftTestTables = nil
+	})
+	return gormDb
+}
+
+const (
+	driftCreateTable = "CREATE TABLE drift_items (id integer PRIMARY KEY, name text, level integer)"
+	driftCreateIndex = "CREATE INDEX idx_drift_items_level ON drift_items(level)"
+)
+
+func TestCheckDrift(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements []string
+		want       []string
+	}{
+		{
+			name:       "no drift",
+			statements: []string{driftCreateTable, driftCreateIndex},
+			want:       []string{},
+		},
+		{
+			name: "missing table",
+			want: []string{"missing table drift_items"},
+		},
+		{
+			name: "missing column",
+			statements: []string{
+				"CREATE TABLE drift_items (id integer PRIMARY KEY, name text)",
+			},
+			want: []string{"missing column drift_items.level integer", "missing index drift_items.idx_drift_items_level (level)"},
+		},
+		{
+			name:       "extra column",
+			statements: []string{"CREATE TABLE drift_items (id integer PRIMARY KEY, name text, level integer, old text)", driftCreateIndex},
+			want:       []string{"extra column drift_items.old text"},
+		},
+		{
+			name:       "type mismatch",
+			statements: []string{"CREATE TABLE drift_items (id integer PRIMARY KEY, name integer, level integer)", driftCreateIndex},
+			want:       []string{"type mismatch drift_items.name expected:text actual:integer"},
+		},
+		{
+			name:       "missing index",
+			statements: []string{driftCreateTable},
+			want:       []string{"missing index drift_items.idx_drift_items_level (level)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newDriftTestDb(t, tt.statements...)
+
+			report, err := gormDb.CheckDrift(context.Background(), 0)
+			if err != nil {
+				t.Fatalf("check drift fail. err:%v", err)
+			}
+			got := []string{}
+			for _, drift := range report.Drifts {
+				got = append(got, drift.String())
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Fatalf("got:%q want:%q", got, tt.want)
+			}
+			if report.HasDrift() != (len(tt.want) != 0) {
+				t.Fatalf("has drift:%v want:%v", report.HasDrift(), len(tt.want) != 0)
+			}
+		})
+	}
+}
+
+func TestRunDriftCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		statements []string
+		args       []string
+		wantErr    error
+		wantFail   bool
+		wantOutput string
+	}{
+		{
+			name:       "text",
+			statements: []string{driftCreateTable},
+			wantOutput: "db type 0: 1 drifts\n  missing index drift_items.idx_drift_items_level (level)\n",
+		},
+		{
+			name:       "no drift",
+			statements: []string{driftCreateTable, driftCreateIndex},
+			args:       []string{"-exit-code"},
+			wantOutput: "db type 0: no drift\n",
+		},
+		{
+			name:       "exit code",
+			statements: []string{driftCreateTable},
+			args:       []string{"-exit-code"},
+			wantErr:    gormdb.ErrSchemaDrift,
+			wantFail:   true,
+			wantOutput: "db type 0: 1 drifts\n  missing index drift_items.idx_drift_items_level (level)\n",
+		},
+		{
+			name:     "unknown db type",
+			args:     []string{"-db-type", "9"},
+			wantFail: true,
+		},
+		{
+			name:     "unknown format",
+			args:     []string{"-format", "xml"},
+			wantFail: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newDriftTestDb(t, tt.statements...)
+
+			output := &bytes.Buffer{}
+			err := gormdb.RunDriftCommand(context.Background(), gormDb, tt.args, output)
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("err:%v want fail:%v", err, tt.wantFail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err:%v want:%v", err, tt.wantErr)
+			}
+			if tt.wantOutput != "" && output.String() != tt.wantOutput {
+				t.Fatalf("output:%q want:%q", output.String(), tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestRunDriftCommandJson(t *testing.T) {
+	gormDb := newDriftTestDb(t, "CREATE TABLE drift_items (id integer PRIMARY KEY, name text)")
+
+	output := &bytes.Buffer{}
+	if err := gormdb.RunDriftCommand(context.Background(), gormDb, []string{"-format", "json", "-db-type", "0"}, output); err != nil {
+		t.Fatalf("run fail. err:%v", err)
+	}
+
+	reports := []*gormdb.DriftReport{}
+	if err := json.Unmarshal(output.Bytes(), &reports); err != nil {
+		t.Fatalf("unmarshal fail. err:%v output:%s", err, output.String())
+	}
+	if len(reports) != 1 || len(reports[0].Drifts) != 2 {
+		t.Fatalf("reports:%s", output.String())
+	}
+	drift := reports[0].Drifts[0]
+	if drift.Kind != gormdb.DriftMissingColumn || drift.Table != "drift_items" || drift.Column != "level" || drift.Expected != "integer" {
+		t.Fatalf("drift:%+v", drift)
+	}
+}
+
+func TestCheckDriftNotConnected(t *testing.T) {
+	gormDb := newDriftTestDb(t)
+
+	report, err := gormDb.CheckDrift(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("check drift fail. err:%v", err)
+	}
+	if len(report.Drifts) != 1 || report.Drifts[0].Kind != gormdb.DriftMissingTable {
+		t.Fatalf("drifts:%v", report.Drifts)
+	}
+	if _, ok := gormDb.Health()[1]; ok {
+		t.Fatalf("db type connected by check drift")
+	}
+
+	// still migrated on first use
+	if !gormDb.GetDb(1).Migrator().HasTable(&driftItem{}) {
+		t.Fatalf("db type not migrated after check drift")
+	}
+}
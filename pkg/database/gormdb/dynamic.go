@@ -0,0 +1,182 @@
+package gormdb
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+// db types not passed to NewGormDb, except the default db type, are dynamic,
+// like a tenant id built at runtime. their pools can be closed by
+// Config.DynamicDbIdleTimeout and Config.MaxDynamicDbs, and reconnect on next TryGetDb
+func (o *GormDb[T]) isDynamic(dbType T) bool {
+	var defaultDbType T
+	if dbType == defaultDbType {
+		return false
+	}
+
+	_, ok := o.staticDbTypes[dbType]
+	return !ok
+}
+
+// caller must hold o.mutex, read lock is enough
+func (o *GormDb[T]) touch(dbType T) {
+	if lastUsed, ok := o.lastUsed[dbType]; ok {
+		lastUsed.Store(time.Now().UnixNano())
+	}
+}
+
+// pools of a db type
+type connection struct {
+	db       *gorm.DB
+	replicas *replicaSet
+	// redacted
+	dsn string
+}
+
+func (o *connection) close() error {
+	errs := []error{}
+	sqlDb, err := o.db.DB()
+	if err == nil {
+		err = sqlDb.Close()
+	}
+	if err != nil {
+		errs = append(errs, serr.Wrap(err))
+	}
+	if o.replicas != nil {
+		if err := o.replicas.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// close pools of dbType, include replicas, and forget everything of dbType,
+// except the migrated state, so reconnecting an evicted db type not migrate again,
+// and caches made by NewCache which still need invalidation after reconnect
+//
+// if delay, pools are closed after Config.DynamicDbCloseDelay, so a gorm.DB got just before can finish its work
+//
+// caller must hold o.mutex
+func (o *GormDb[T]) closeDb(dbType T, delay bool) error {
+	var err error
+	if db, ok := o.db[dbType]; ok {
+		conn := &connection{
+			db:       db,
+			replicas: o.replicas[dbType],
+		}
+		if delay {
+			o.closeLater(dbType, conn)
+		} else if err = conn.close(); err != nil {
+			err = serr.Wrapf(err, "db type:%v", dbType)
+		}
+	}
+
+	delete(o.db, dbType)
+	delete(o.replicas, dbType)
+	delete(o.lastUsed, dbType)
+	delete(o.dsns, dbType)
+	delete(o.metrics, dbType)
+	delete(o.breakers, dbType)
+	delete(o.slowQueries, dbType)
+	if plugin, ok := o.caches[dbType]; ok && plugin.empty() {
+		delete(o.caches, dbType)
+	}
+
+	o.healthMutex.Lock()
+	delete(o.health, dbType)
+	o.healthMutex.Unlock()
+
+	return err
+}
+
+// caller must hold o.mutex
+func (o *GormDb[T]) closeLater(dbType T, conn *connection) {
+	delay := o.config.DynamicDbCloseDelay
+	if delay == 0 {
+		delay = time.Minute
+	}
+
+	o.closing[conn] = time.AfterFunc(delay, func() {
+		o.mutex.Lock()
+		_, ok := o.closing[conn]
+		delete(o.closing, conn)
+		o.mutex.Unlock()
+		// closed by Stop
+		if !ok {
+			return
+		}
+
+		if err := conn.close(); err != nil {
+			o.log().Warn("gormdb close evicted db type fail",
+				slog.String("db_type", fmt.Sprint(dbType)),
+				slog.Any("err", serr.ToJSON(err, true)))
+		}
+	})
+}
+
+// close least recently used dynamic db types until not more than Config.MaxDynamicDbs
+//
+// caller must hold o.mutex
+func (o *GormDb[T]) evictLeastRecentlyUsed(keep T) error {
+	if o.config.MaxDynamicDbs <= 0 {
+		return nil
+	}
+
+	errs := []error{}
+	for {
+		count := 0
+		var oldest T
+		oldestTime := int64(0)
+		for dbType, lastUsed := range o.lastUsed {
+			if !o.isDynamic(dbType) {
+				continue
+			}
+			count++
+
+			if dbType == keep {
+				continue
+			}
+			if t := lastUsed.Load(); oldestTime == 0 || t < oldestTime {
+				oldest = dbType
+				oldestTime = t
+			}
+		}
+
+		if count <= o.config.MaxDynamicDbs || oldestTime == 0 {
+			break
+		}
+
+		if err := o.closeDb(oldest, true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// close dynamic db types unused longer than Config.DynamicDbIdleTimeout
+func (o *GormDb[T]) evictIdle() error {
+	deadline := time.Now().Add(-o.config.DynamicDbIdleTimeout).UnixNano()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	errs := []error{}
+	for dbType, lastUsed := range o.lastUsed {
+		if !o.isDynamic(dbType) || lastUsed.Load() > deadline {
+			continue
+		}
+
+		if err := o.closeDb(dbType, true); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,218 @@
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+type dynamicItem struct {
+	Id int64 `gorm:"primaryKey"`
+}
+
+type dynamicDbType int
+
+// times of auto migrate of each db type
+var dynamicTestMigrations = struct {
+	mutex  sync.Mutex
+	counts map[dynamicDbType]int
+}{counts: map[dynamicDbType]int{}}
+
+func (o dynamicDbType) GetTables() []interface{} {
+	dynamicTestMigrations.mutex.Lock()
+	defer dynamicTestMigrations.mutex.Unlock()
+	dynamicTestMigrations.counts[o]++
+
+	return []interface{}{&dynamicItem{}}
+}
+
+// every db type is a sqlite file, so an evicted db type keeps its rows
+func newDynamicTestDb(t *testing.T, config Config, dbTypes ...dynamicDbType) *GormDb[dynamicDbType] {
+	dynamicTestMigrations.mutex.Lock()
+	dynamicTestMigrations.counts = map[dynamicDbType]int{}
+	dynamicTestMigrations.mutex.Unlock()
+
+	dir := t.TempDir()
+	config.AutoMigrate = true
+	config.LogLevel = logger.Silent
+	config.dsnOverride = func(dbType any, config *Config) (Dialect, string) {
+		return DialectSqlite, filepath.Join(dir, fmt.Sprintf("%v.sqlite", dbType))
+	}
+	gormDb := NewGormDb(&config, dbTypes...)
+	t.Cleanup(func() {
+		gormDb.Stop(context.Background())
+	})
+
+	return gormDb
+}
+
+func connectedDbTypes(gormDb *GormDb[dynamicDbType]) string {
+	gormDb.mutex.RLock()
+	defer gormDb.mutex.RUnlock()
+
+	connected := []dynamicDbType{}
+	for dbType := dynamicDbType(0); dbType < 10; dbType++ {
+		if _, ok := gormDb.db[dbType]; ok {
+			connected = append(connected, dbType)
+		}
+	}
+	return fmt.Sprint(connected)
+}
+
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	tests := []struct {
+		name    string
+		static  []dynamicDbType
+		max     int
+		connect []dynamicDbType
+		want    string
+	}{
+		{
+			name:    "least recently used",
+			max:     2,
+			connect: []dynamicDbType{1, 2, 3},
+			want:    "[2 3]",
+		},
+		{
+			name:    "used again",
+			max:     2,
+			connect: []dynamicDbType{1, 2, 1, 3},
+			want:    "[1 3]",
+		},
+		{
+			name:    "default and static not counted",
+			static:  []dynamicDbType{1},
+			max:     1,
+			connect: []dynamicDbType{0, 1, 2, 3},
+			want:    "[0 1 3]",
+		},
+		{
+			name:    "no limit",
+			connect: []dynamicDbType{1, 2, 3},
+			want:    "[1 2 3]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newDynamicTestDb(t, Config{MaxDynamicDbs: tt.max}, tt.static...)
+			for _, dbType := range tt.connect {
+				if _, err := gormDb.TryGetDb(dbType); err != nil {
+					t.Fatalf("get db fail. db type:%d err:%v", dbType, err)
+				}
+				// lastUsed is in nanoseconds, keep the order of uses
+				time.Sleep(time.Millisecond)
+			}
+
+			if got := connectedDbTypes(gormDb); got != tt.want {
+				t.Fatalf("connected:%s want:%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvictIdle(t *testing.T) {
+	gormDb := newDynamicTestDb(t, Config{DynamicDbIdleTimeout: time.Hour}, 1)
+	for _, dbType := range []dynamicDbType{0, 1, 2, 3} {
+		if _, err := gormDb.TryGetDb(dbType); err != nil {
+			t.Fatalf("get db fail. db type:%d err:%v", dbType, err)
+		}
+	}
+
+	gormDb.mutex.Lock()
+	for dbType, lastUsed := range gormDb.lastUsed {
+		if dbType != 3 {
+			lastUsed.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+		}
+	}
+	gormDb.mutex.Unlock()
+
+	if err := gormDb.evictIdle(); err != nil {
+		t.Fatalf("evict idle fail. err:%v", err)
+	}
+	if got := connectedDbTypes(gormDb); got != "[0 1 3]" {
+		t.Fatalf("connected:%s want:[0 1 3]", got)
+	}
+}
+
+func TestEvictedReconnect(t *testing.T) {
+	gormDb := newDynamicTestDb(t, Config{
+		MaxDynamicDbs:       1,
+		DynamicDbCloseDelay: time.Hour,
+	})
+
+	db, err := gormDb.TryGetDb(1)
+	if err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+	if err := db.Create(&dynamicItem{Id: 1}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+	if _, err := gormDb.TryGetDb(2); err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+	if got := connectedDbTypes(gormDb); got != "[2]" {
+		t.Fatalf("connected:%s want:[2]", got)
+	}
+
+	// the pool is closed after DynamicDbCloseDelay, a gorm.DB got before still works
+	if err := db.First(&dynamicItem{}, 1).Error; err != nil {
+		t.Fatalf("evicted db not work before close delay. err:%v", err)
+	}
+
+	reconnected, err := gormDb.TryGetDb(1)
+	if err != nil {
+		t.Fatalf("reconnect fail. err:%v", err)
+	}
+	if err := reconnected.First(&dynamicItem{}, 1).Error; err != nil {
+		t.Fatalf("find after reconnect fail. err:%v", err)
+	}
+
+	dynamicTestMigrations.mutex.Lock()
+	count := dynamicTestMigrations.counts[1]
+	dynamicTestMigrations.mutex.Unlock()
+	if count != 1 {
+		t.Fatalf("migrated again after eviction. count:%d", count)
+	}
+
+	// Stop not wait the close delay
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatalf("db fail. err:%v", err)
+	}
+	if err := gormDb.Stop(context.Background()); err != nil {
+		t.Fatalf("stop fail. err:%v", err)
+	}
+	if err := sqlDb.Ping(); err == nil {
+		t.Fatalf("evicted pool not closed by stop")
+	}
+}
+
+func TestEvictedCloseDelay(t *testing.T) {
+	gormDb := newDynamicTestDb(t, Config{
+		MaxDynamicDbs:       1,
+		DynamicDbCloseDelay: time.Millisecond,
+	})
+
+	db, err := gormDb.TryGetDb(1)
+	if err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		t.Fatalf("db fail. err:%v", err)
+	}
+	if _, err := gormDb.TryGetDb(2); err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+
+	for deadline := time.Now().Add(time.Second); sqlDb.Ping() == nil; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("evicted pool not closed after close delay")
+		}
+	}
+}
@@ -0,0 +1,606 @@
+package gormdb
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type ExportFormat string
+
+const (
+	// a json object of column to value each line
+	ExportFormatJsonl ExportFormat = "jsonl"
+	// a header line of columns, then values. non string values are in json.
+	// NULL is \N, a string starting with a backslash gets another backslash
+	ExportFormatCsv ExportFormat = "csv"
+)
+
+// csv value of NULL, same as mysqldump
+const csvNull = `\N`
+
+type ImportConflict string
+
+const (
+	ImportConflictError  ImportConflict = "error"
+	ImportConflictSkip   ImportConflict = "skip"
+	ImportConflictUpdate ImportConflict = "update"
+)
+
+type ExportOptions struct {
+	// default is ExportFormatJsonl
+	Format ExportFormat
+	Gzip   bool
+	// table names to export, default every table of T.GetTables()
+	Tables []string
+	// conditions by table name passed to gorm.DB.Where, like {"items": {"player_id = ?", 1001}}
+	Where map[string][]interface{}
+	// rows read each query, default is 1000
+	BatchSize int
+	// not export rows soft deleted by gorm.DeletedAt, default exports them so Import restores every row
+	SkipSoftDeleted bool
+}
+
+type ImportOptions struct {
+	// format of ImportTable, default is ExportFormatJsonl. Import uses the file extension.
+	// gzip is detected from the content
+	Format ExportFormat
+	// table names to import, default every table of T.GetTables()
+	Tables []string
+	// how to handle a row with an existing primary key or unique key, default is ImportConflictError
+	Conflict ImportConflict
+	// rows of one INSERT, default is 1000
+	BatchSize int
+}
+
+type ExportResult struct {
+	Table string
+	File  string
+	Rows  int64
+}
+
+func getExportOptions(options *ExportOptions) *ExportOptions {
+	o := ExportOptions{}
+	if options != nil {
+		o = *options
+	}
+	if o.Format == "" {
+		o.Format = ExportFormatJsonl
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	return &o
+}
+
+func getImportOptions(options *ImportOptions) *ImportOptions {
+	o := ImportOptions{}
+	if options != nil {
+		o = *options
+	}
+	if o.Format == "" {
+		o.Format = ExportFormatJsonl
+	}
+	if o.Conflict == "" {
+		o.Conflict = ImportConflictError
+	}
+	if o.BatchSize <= 0 {
+		o.BatchSize = 1000
+	}
+	return &o
+}
+
+func isTableSelected(tables []string, table string) bool {
+	if len(tables) == 0 {
+		return true
+	}
+	for _, t := range tables {
+		if t == table {
+			return true
+		}
+	}
+	return false
+}
+
+func exportFileName(table string, format ExportFormat, gz bool) string {
+	name := table + "." + string(format)
+	if gz {
+		name += ".gz"
+	}
+	return name
+}
+
+func (o *GormDb[T]) getExportTables(dbType T, tables []string) ([]interface{}, []*schema.Schema, error) {
+	t, ok := any(dbType).(DbTypeGetTables)
+	if !ok {
+		return nil, nil, serr.Errorf("no tables. db type:%v", dbType)
+	}
+
+	models := []interface{}{}
+	schemas := []*schema.Schema{}
+	for _, model := range t.GetTables() {
+		s, err := o.parseSchema(model)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isTableSelected(tables, s.Table) {
+			models = append(models, model)
+			schemas = append(schemas, s)
+		}
+	}
+
+	return models, schemas, nil
+}
+
+// columns in the order of the model
+func exportFields(s *schema.Schema) []*schema.Field {
+	fields := make([]*schema.Field, 0, len(s.DBNames))
+	for _, dbName := range s.DBNames {
+		if field := s.FieldsByDBName[dbName]; field.Readable && field.Creatable {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// write rows of every table of T.GetTables() to a file <table>.<format>[.gz] each in dir
+//
+// tables are read in batches ordered by primary key, so memory not grows with the table
+func (o *GormDb[T]) Export(ctx context.Context, dbType T, dir string, options *ExportOptions) ([]*ExportResult, error) {
+	options = getExportOptions(options)
+	models, schemas, err := o.getExportTables(dbType, options.Tables)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	results := []*ExportResult{}
+	for i, model := range models {
+		path := filepath.Join(dir, exportFileName(schemas[i].Table, options.Format, options.Gzip))
+		file, err := os.Create(path)
+		if err != nil {
+			return results, serr.Wrap(err)
+		}
+
+		rows, err := o.ExportTable(ctx, dbType, model, file, options)
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = serr.Wrap(closeErr)
+		}
+		if err != nil {
+			return results, serr.Wrapf(err, "table:%s", schemas[i].Table)
+		}
+
+		results = append(results, &ExportResult{
+			Table: schemas[i].Table,
+			File:  path,
+			Rows:  rows,
+		})
+	}
+
+	return results, nil
+}
+
+// write rows of the table of model to w, return the row count. options.Tables is ignored
+func (o *GormDb[T]) ExportTable(ctx context.Context, dbType T, model interface{}, w io.Writer, options *ExportOptions) (int64, error) {
+	options = getExportOptions(options)
+	db, err := o.TryFromCtx(ctx, dbType)
+	if err != nil {
+		return 0, err
+	}
+	s, err := o.parseSchema(model)
+	if err != nil {
+		return 0, err
+	}
+
+	var gz *gzip.Writer
+	if options.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+
+	writer, err := newRowWriter(options.Format, w, exportFields(s))
+	if err != nil {
+		return 0, err
+	}
+
+	if where := options.Where[s.Table]; len(where) != 0 {
+		db = db.Where(where[0], where[1:]...)
+	}
+	if !options.SkipSoftDeleted {
+		db = db.Unscoped()
+	}
+
+	var count int64
+	rows := reflect.New(reflect.SliceOf(reflect.PointerTo(s.ModelType)))
+	result := db.Table(s.Table).FindInBatches(rows.Interface(), options.BatchSize, func(tx *gorm.DB, batch int) error {
+		for i := 0; i < rows.Elem().Len(); i++ {
+			if err := writer.write(ctx, rows.Elem().Index(i).Elem()); err != nil {
+				return err
+			}
+		}
+		count += int64(rows.Elem().Len())
+		return nil
+	})
+	if result.Error != nil {
+		return count, serr.Wrap(result.Error)
+	}
+
+	if err := writer.flush(); err != nil {
+		return count, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return count, serr.Wrap(err)
+		}
+	}
+
+	return count, nil
+}
+
+// insert rows of files in dir written by Export, tables without a file are skipped.
+// tables are imported in the order of T.GetTables()
+func (o *GormDb[T]) Import(ctx context.Context, dbType T, dir string, options *ImportOptions) ([]*ExportResult, error) {
+	options = getImportOptions(options)
+	models, schemas, err := o.getExportTables(dbType, options.Tables)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*ExportResult{}
+	for i, model := range models {
+		path, format, ok := findImportFile(dir, schemas[i].Table)
+		if !ok {
+			continue
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return results, serr.Wrap(err)
+		}
+
+		tableOptions := *options
+		tableOptions.Format = format
+		rows, err := o.ImportTable(ctx, dbType, model, file, &tableOptions)
+		file.Close()
+		if err != nil {
+			return results, serr.Wrapf(err, "table:%s", schemas[i].Table)
+		}
+
+		results = append(results, &ExportResult{
+			Table: schemas[i].Table,
+			File:  path,
+			Rows:  rows,
+		})
+	}
+
+	return results, nil
+}
+
+func findImportFile(dir string, table string) (string, ExportFormat, bool) {
+	for _, format := range []ExportFormat{ExportFormatJsonl, ExportFormatCsv} {
+		for _, gz := range []bool{false, true} {
+			path := filepath.Join(dir, exportFileName(table, format, gz))
+			if _, err := os.Stat(path); err == nil {
+				return path, format, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// insert rows read from r into the table of model in batches, return the row count. options.Tables is ignored
+//
+// use the transaction of WithTx in ctx if any, so the import can be all or nothing
+func (o *GormDb[T]) ImportTable(ctx context.Context, dbType T, model interface{}, r io.Reader, options *ImportOptions) (int64, error) {
+	options = getImportOptions(options)
+	db, err := o.TryFromCtx(ctx, dbType)
+	if err != nil {
+		return 0, err
+	}
+	s, err := o.parseSchema(model)
+	if err != nil {
+		return 0, err
+	}
+
+	switch options.Conflict {
+	case ImportConflictError:
+	case ImportConflictSkip:
+		db = db.Clauses(clause.OnConflict{DoNothing: true})
+	case ImportConflictUpdate:
+		db = db.Clauses(clause.OnConflict{UpdateAll: true})
+	default:
+		return 0, serr.Errorf("unknown conflict:%s", options.Conflict)
+	}
+
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return 0, serr.Wrap(err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	reader, err := newRowReader(options.Format, r, s)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	rows := reflect.MakeSlice(reflect.SliceOf(reflect.PointerTo(s.ModelType)), 0, options.BatchSize)
+	insert := func() error {
+		if rows.Len() == 0 {
+			return nil
+		}
+		if err := db.Table(s.Table).Create(rows.Interface()).Error; err != nil {
+			return serr.Wrapf(err, "row:%d", count+1)
+		}
+		count += int64(rows.Len())
+		rows = rows.Slice(0, 0)
+		return nil
+	}
+
+	for {
+		row := reflect.New(s.ModelType)
+		if err := reader.read(ctx, row.Elem()); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return count, serr.Wrapf(err, "row:%d", count+int64(rows.Len())+1)
+		}
+
+		rows = reflect.Append(rows, row)
+		if rows.Len() >= options.BatchSize {
+			if err := insert(); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	if err := insert(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+type rowWriter interface {
+	write(ctx context.Context, rv reflect.Value) error
+	flush() error
+}
+
+type rowReader interface {
+	// return io.EOF if no more row
+	read(ctx context.Context, rv reflect.Value) error
+}
+
+func newRowWriter(format ExportFormat, w io.Writer, fields []*schema.Field) (rowWriter, error) {
+	switch format {
+	case ExportFormatJsonl:
+		return &jsonlRowWriter{
+			w:      bufio.NewWriter(w),
+			fields: fields,
+		}, nil
+	case ExportFormatCsv:
+		header := make([]string, 0, len(fields))
+		for _, field := range fields {
+			header = append(header, field.DBName)
+		}
+		writer := csv.NewWriter(w)
+		if err := writer.Write(header); err != nil {
+			return nil, serr.Wrap(err)
+		}
+		return &csvRowWriter{
+			w:      writer,
+			fields: fields,
+		}, nil
+	default:
+		return nil, serr.Errorf("unknown format:%s", format)
+	}
+}
+
+func newRowReader(format ExportFormat, r io.Reader, s *schema.Schema) (rowReader, error) {
+	switch format {
+	case ExportFormatJsonl:
+		return &jsonlRowReader{
+			decoder: json.NewDecoder(r),
+			schema:  s,
+		}, nil
+	case ExportFormatCsv:
+		reader := csv.NewReader(r)
+		header, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return &csvRowReader{r: reader}, nil
+		} else if err != nil {
+			return nil, serr.Wrap(err)
+		}
+
+		fields := make([]*schema.Field, 0, len(header))
+		for _, column := range header {
+			field, ok := s.FieldsByDBName[column]
+			if !ok {
+				return nil, serr.Errorf("unknown column. table:%s column:%s", s.Table, column)
+			}
+			fields = append(fields, field)
+		}
+		return &csvRowReader{
+			r:      reader,
+			fields: fields,
+		}, nil
+	default:
+		return nil, serr.Errorf("unknown format:%s", format)
+	}
+}
+
+type jsonlRowWriter struct {
+	w      *bufio.Writer
+	fields []*schema.Field
+}
+
+// keys in the order of columns, so files are diffable
+func (o *jsonlRowWriter) write(ctx context.Context, rv reflect.Value) error {
+	o.w.WriteByte('{')
+	for i, field := range o.fields {
+		if i > 0 {
+			o.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(field.DBName)
+		o.w.Write(key)
+		o.w.WriteByte(':')
+
+		v, _ := field.ValueOf(ctx, rv)
+		value, err := json.Marshal(v)
+		if err != nil {
+			return serr.Wrapf(err, "column:%s", field.DBName)
+		}
+		o.w.Write(value)
+	}
+	o.w.WriteString("}\n")
+	return nil
+}
+
+func (o *jsonlRowWriter) flush() error {
+	if err := o.w.Flush(); err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+type jsonlRowReader struct {
+	decoder *json.Decoder
+	schema  *schema.Schema
+}
+
+func (o *jsonlRowReader) read(ctx context.Context, rv reflect.Value) error {
+	values := map[string]json.RawMessage{}
+	if err := o.decoder.Decode(&values); errors.Is(err, io.EOF) {
+		return err
+	} else if err != nil {
+		return serr.Wrap(err)
+	}
+
+	for column, value := range values {
+		field, ok := o.schema.FieldsByDBName[column]
+		if !ok {
+			return serr.Errorf("unknown column. table:%s column:%s", o.schema.Table, column)
+		}
+		if err := setImportValue(ctx, field, rv, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type csvRowWriter struct {
+	w      *csv.Writer
+	fields []*schema.Field
+}
+
+// json strings are written unquoted, null is written as \N
+//
+// csv has no difference between quoted and unquoted values, so a string starting with a backslash
+// gets another one, otherwise the string \N is read as null
+func (o *csvRowWriter) write(ctx context.Context, rv reflect.Value) error {
+	record := make([]string, 0, len(o.fields))
+	for _, field := range o.fields {
+		v, _ := field.ValueOf(ctx, rv)
+		value, err := json.Marshal(v)
+		if err != nil {
+			return serr.Wrapf(err, "column:%s", field.DBName)
+		}
+
+		switch {
+		case bytes.Equal(value, []byte("null")):
+			record = append(record, csvNull)
+		case value[0] == '"':
+			s := ""
+			json.Unmarshal(value, &s)
+			if strings.HasPrefix(s, `\`) {
+				s = `\` + s
+			}
+			record = append(record, s)
+		default:
+			record = append(record, string(value))
+		}
+	}
+
+	if err := o.w.Write(record); err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+func (o *csvRowWriter) flush() error {
+	o.w.Flush()
+	if err := o.w.Error(); err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+type csvRowReader struct {
+	r      *csv.Reader
+	fields []*schema.Field
+}
+
+func (o *csvRowReader) read(ctx context.Context, rv reflect.Value) error {
+	record, err := o.r.Read()
+	if errors.Is(err, io.EOF) {
+		return err
+	} else if err != nil {
+		return serr.Wrap(err)
+	}
+
+	for i, field := range o.fields {
+		if record[i] == csvNull {
+			continue
+		}
+		record[i] = strings.TrimPrefix(record[i], `\`)
+
+		quoted, _ := json.Marshal(record[i])
+		fieldType := field.FieldType
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		// a string may look like json, others are json or unquoted json strings like time
+		if fieldType.Kind() == reflect.String || !json.Valid([]byte(record[i])) {
+			err = setImportValue(ctx, field, rv, quoted)
+		} else if err = setImportValue(ctx, field, rv, json.RawMessage(record[i])); err != nil {
+			err = setImportValue(ctx, field, rv, quoted)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setImportValue(ctx context.Context, field *schema.Field, rv reflect.Value, value json.RawMessage) error {
+	if bytes.Equal(value, []byte("null")) {
+		return nil
+	}
+
+	v := reflect.New(field.FieldType)
+	if err := json.Unmarshal(value, v.Interface()); err != nil {
+		return serr.Wrapf(err, "column:%s", field.DBName)
+	}
+	field.ReflectValueOf(ctx, rv).Set(v.Elem())
+	return nil
+}
@@ -0,0 +1,133 @@
+package gormdb
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+// a small command to call from main of the application, e.g. `app export -dir backup -where items:player_id=1001`
+//
+//	-dir path           directory of the files, required
+//	-db-type name       the db type whose fmt.Sprint is name, default the only db type passed to NewGormDb
+//	-format jsonl|csv   default jsonl
+//	-gzip               compress the files
+//	-tables a,b         only these tables, default all tables of T.GetTables()
+//	-where table:cond   a sql condition of a table, can be repeated
+//	-batch-size n       rows read each query, default 1000
+//	-skip-soft-deleted  not export rows soft deleted by gorm.DeletedAt
+func RunExportCommand[T comparable](ctx context.Context, gormDb *GormDb[T], args []string, w io.Writer) error {
+	flagSet := flag.NewFlagSet("export", flag.ContinueOnError)
+	flagSet.SetOutput(w)
+	dir := flagSet.String("dir", "", "directory of the files")
+	dbTypeName := flagSet.String("db-type", "", "export this db type")
+	format := flagSet.String("format", string(ExportFormatJsonl), "jsonl or csv")
+	gz := flagSet.Bool("gzip", false, "compress the files")
+	tables := flagSet.String("tables", "", "comma separated table names")
+	batchSize := flagSet.Int("batch-size", 0, "rows read each query")
+	skipSoftDeleted := flagSet.Bool("skip-soft-deleted", false, "not export soft deleted rows")
+	where := map[string][]interface{}{}
+	flagSet.Func("where", "table:condition, can be repeated", func(s string) error {
+		table, condition, ok := strings.Cut(s, ":")
+		if !ok || table == "" || condition == "" {
+			return serr.Errorf("where must be table:condition. where:%s", s)
+		}
+		where[table] = []interface{}{condition}
+		return nil
+	})
+	if err := flagSet.Parse(args); err != nil {
+		return serr.Wrap(err)
+	}
+	if *dir == "" {
+		return serr.New("dir is empty")
+	}
+
+	dbType, err := findCommandDbType(gormDb, *dbTypeName)
+	if err != nil {
+		return err
+	}
+
+	results, err := gormDb.Export(ctx, dbType, *dir, &ExportOptions{
+		Format:          ExportFormat(*format),
+		Gzip:            *gz,
+		Tables:          splitTables(*tables),
+		Where:           where,
+		BatchSize:       *batchSize,
+		SkipSoftDeleted: *skipSoftDeleted,
+	})
+	printExportResults(w, results)
+	return err
+}
+
+// a small command to call from main of the application, e.g. `app import -dir backup -conflict skip`
+//
+//	-dir path                   directory of the files written by export, required
+//	-db-type name               the db type whose fmt.Sprint is name, default the only db type passed to NewGormDb
+//	-tables a,b                 only these tables, default all tables of T.GetTables()
+//	-conflict error|skip|update default error
+//	-batch-size n               rows of one INSERT, default 1000
+func RunImportCommand[T comparable](ctx context.Context, gormDb *GormDb[T], args []string, w io.Writer) error {
+	flagSet := flag.NewFlagSet("import", flag.ContinueOnError)
+	flagSet.SetOutput(w)
+	dir := flagSet.String("dir", "", "directory of the files")
+	dbTypeName := flagSet.String("db-type", "", "import to this db type")
+	tables := flagSet.String("tables", "", "comma separated table names")
+	conflict := flagSet.String("conflict", string(ImportConflictError), "error, skip or update")
+	batchSize := flagSet.Int("batch-size", 0, "rows of one INSERT")
+	if err := flagSet.Parse(args); err != nil {
+		return serr.Wrap(err)
+	}
+	if *dir == "" {
+		return serr.New("dir is empty")
+	}
+
+	dbType, err := findCommandDbType(gormDb, *dbTypeName)
+	if err != nil {
+		return err
+	}
+
+	results, err := gormDb.Import(ctx, dbType, *dir, &ImportOptions{
+		Tables:    splitTables(*tables),
+		Conflict:  ImportConflict(*conflict),
+		BatchSize: *batchSize,
+	})
+	printExportResults(w, results)
+	return err
+}
+
+func findCommandDbType[T comparable](gormDb *GormDb[T], name string) (T, error) {
+	var dbType T
+	if name == "" {
+		if len(gormDb.dbTypes) > 1 {
+			return dbType, serr.New("db-type is required for more than one db type")
+		}
+		if len(gormDb.dbTypes) == 1 {
+			dbType = gormDb.dbTypes[0]
+		}
+		return dbType, nil
+	}
+
+	for _, t := range gormDb.dbTypes {
+		if fmt.Sprint(t) == name {
+			return t, nil
+		}
+	}
+	return dbType, serr.Errorf("db type not found. db_type:%s", name)
+}
+
+func splitTables(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func printExportResults(w io.Writer, results []*ExportResult) {
+	for _, result := range results {
+		fmt.Fprintf(w, "%s\t%d rows\t%s\n", result.Table, result.Rows, result.File)
+	}
+}
@@ -0,0 +1,8 @@
+package gormdb
+
+import "github.com/MinamiKotoriCute/jf/pkg/database/gormdb/internal/testhook"
+
+// set Config.dsnOverride, only gormdbtest can call it
+func SetDsnOverride(_ testhook.Token, config *Config, override func(dbType any, config *Config) (Dialect, string)) {
+	config.dsnOverride = override
+}
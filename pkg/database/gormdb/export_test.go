@@ -0,0 +1,279 @@
+package gormdb_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+type exportItem struct {
+	Id        int64 `gorm:"primaryKey"`
+	Name      string
+	Note      *string
+	Level     int
+	CreatedAt time.Time
+	DeletedAt gorm.DeletedAt
+}
+
+type exportOther struct {
+	Id int64 `gorm:"primaryKey"`
+}
+
+type exportDbType int
+
+func (exportDbType) GetTables() []interface{} {
+	return []interface{}{&exportItem{}, &exportOther{}}
+}
+
+var exportTestTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func newExportTestDb(t *testing.T, config *gormdb.Config) *gormdb.GormDb[exportDbType] {
+	c := gormdb.Config{}
+	if config != nil {
+		c = *config
+	}
+	c.LogLevel = logger.Silent
+	return gormdbtest.New[exportDbType](t, &c, 0)
+}
+
+// names are the values csv may confuse with null or json
+func createExportItems(t *testing.T, gormDb *gormdb.GormDb[exportDbType]) {
+	note := `\N`
+	items := []*exportItem{
+		{Id: 1, Name: `\N`, Level: 1, CreatedAt: exportTestTime},
+		{Id: 2, Name: `\\x`, Note: &note, Level: 2, CreatedAt: exportTestTime},
+		{Id: 3, Name: `{"a":1}`, Level: 3, CreatedAt: exportTestTime},
+		{Id: 4, Name: "", Level: 4, CreatedAt: exportTestTime},
+		{Id: 5, Name: "deleted", Level: 5, CreatedAt: exportTestTime},
+	}
+	db := gormDb.GetDb(0)
+	if err := db.Create(items).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+	if err := db.Delete(&exportItem{Id: 5}).Error; err != nil {
+		t.Fatalf("delete fail. err:%v", err)
+	}
+	if err := db.Create(&exportOther{Id: 1}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+}
+
+func formatExportItems(t *testing.T, db *gorm.DB) []string {
+	items := []*exportItem{}
+	if err := db.Unscoped().Order("id").Find(&items).Error; err != nil {
+		t.Fatalf("find fail. err:%v", err)
+	}
+	result := []string{}
+	for _, item := range items {
+		note := "<nil>"
+		if item.Note != nil {
+			note = *item.Note
+		}
+		result = append(result, fmt.Sprintf("%d|%s|%s|%d|%s|%v", item.Id, item.Name, note, item.Level,
+			item.CreatedAt.UTC().Format(time.RFC3339), item.DeletedAt.Valid))
+	}
+	return result
+}
+
+func TestExportImport(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   *gormdb.Config
+		options  *gormdb.ExportOptions
+		wantFile string
+		want     []string
+	}{
+		{
+			name:     "jsonl",
+			wantFile: "export_items.jsonl",
+			want: []string{
+				`1|\N|<nil>|1|2024-01-02T03:04:05Z|false`,
+				`2|\\x|\N|2|2024-01-02T03:04:05Z|false`,
+				`3|{"a":1}|<nil>|3|2024-01-02T03:04:05Z|false`,
+				`4||<nil>|4|2024-01-02T03:04:05Z|false`,
+				`5|deleted|<nil>|5|2024-01-02T03:04:05Z|true`,
+			},
+		},
+		{
+			name:     "csv gzip",
+			options:  &gormdb.ExportOptions{Format: gormdb.ExportFormatCsv, Gzip: true, BatchSize: 2},
+			wantFile: "export_items.csv.gz",
+			want: []string{
+				`1|\N|<nil>|1|2024-01-02T03:04:05Z|false`,
+				`2|\\x|\N|2|2024-01-02T03:04:05Z|false`,
+				`3|{"a":1}|<nil>|3|2024-01-02T03:04:05Z|false`,
+				`4||<nil>|4|2024-01-02T03:04:05Z|false`,
+				`5|deleted|<nil>|5|2024-01-02T03:04:05Z|true`,
+			},
+		},
+		{
+			name: "where and skip soft deleted",
+			options: &gormdb.ExportOptions{
+				Format:          gormdb.ExportFormatCsv,
+				Where:           map[string][]interface{}{"export_items": {"level >= ?", 3}},
+				SkipSoftDeleted: true,
+			},
+			wantFile: "export_items.csv",
+			want: []string{
+				`3|{"a":1}|<nil>|3|2024-01-02T03:04:05Z|false`,
+				`4||<nil>|4|2024-01-02T03:04:05Z|false`,
+			},
+		},
+		{
+			name:     "table prefix",
+			config:   &gormdb.Config{NamingStrategy: schema.NamingStrategy{TablePrefix: "x_"}},
+			options:  &gormdb.ExportOptions{Tables: []string{"x_export_items"}},
+			wantFile: "x_export_items.jsonl",
+			want: []string{
+				`1|\N|<nil>|1|2024-01-02T03:04:05Z|false`,
+				`2|\\x|\N|2|2024-01-02T03:04:05Z|false`,
+				`3|{"a":1}|<nil>|3|2024-01-02T03:04:05Z|false`,
+				`4||<nil>|4|2024-01-02T03:04:05Z|false`,
+				`5|deleted|<nil>|5|2024-01-02T03:04:05Z|true`,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := newExportTestDb(t, tt.config)
+			createExportItems(t, source)
+			dir := t.TempDir()
+
+			results, err := source.Export(context.Background(), 0, dir, tt.options)
+			if err != nil {
+				t.Fatalf("export fail. err:%v", err)
+			}
+			if results[0].File != filepath.Join(dir, tt.wantFile) || results[0].Rows != int64(len(tt.want)) {
+				t.Fatalf("result:%+v", results[0])
+			}
+
+			target := newExportTestDb(t, tt.config)
+			results, err = target.Import(context.Background(), 0, dir, nil)
+			if err != nil {
+				t.Fatalf("import fail. err:%v", err)
+			}
+			if results[0].Rows != int64(len(tt.want)) {
+				t.Fatalf("import result:%+v", results[0])
+			}
+			if got := formatExportItems(t, target.GetDb(0)); fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+				t.Fatalf("got:%q want:%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportCsvEscape(t *testing.T) {
+	gormDb := newExportTestDb(t, nil)
+	createExportItems(t, gormDb)
+
+	output := &bytes.Buffer{}
+	_, err := gormDb.ExportTable(context.Background(), 0, &exportItem{}, output, &gormdb.ExportOptions{
+		Format: gormdb.ExportFormatCsv,
+		Where:  map[string][]interface{}{"export_items": {"id <= ?", 2}},
+	})
+	if err != nil {
+		t.Fatalf("export fail. err:%v", err)
+	}
+	want := "id,name,note,level,created_at,deleted_at\n" +
+		`1,\\N,\N,1,2024-01-02T03:04:05Z,\N` + "\n" +
+		`2,\\\x,\\N,2,2024-01-02T03:04:05Z,\N` + "\n"
+	if output.String() != want {
+		t.Fatalf("got:%q want:%q", output.String(), want)
+	}
+}
+
+func TestImportConflict(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict gormdb.ImportConflict
+		wantErr  bool
+		want     string
+	}{
+		{
+			name:     "error",
+			conflict: gormdb.ImportConflictError,
+			wantErr:  true,
+			want:     "old",
+		},
+		{
+			name:     "skip",
+			conflict: gormdb.ImportConflictSkip,
+			want:     "old",
+		},
+		{
+			name:     "update",
+			conflict: gormdb.ImportConflictUpdate,
+			want:     "new",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newExportTestDb(t, nil)
+			db := gormDb.GetDb(0)
+			if err := db.Create(&exportItem{Id: 1, Name: "old"}).Error; err != nil {
+				t.Fatalf("create fail. err:%v", err)
+			}
+
+			input := bytes.NewBufferString(`{"id":1,"name":"new"}` + "\n" + `{"id":2,"name":"other"}` + "\n")
+			_, err := gormDb.ImportTable(context.Background(), 0, &exportItem{}, input, &gormdb.ImportOptions{Conflict: tt.conflict})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+
+			item := &exportItem{}
+			if err := db.Take(item, 1).Error; err != nil {
+				t.Fatalf("take fail. err:%v", err)
+			}
+			if item.Name != tt.want {
+				t.Fatalf("name:%s want:%s", item.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  gormdb.ExportFormat
+		content string
+	}{
+		{
+			name:    "unknown jsonl column",
+			format:  gormdb.ExportFormatJsonl,
+			content: `{"id":1,"missing":1}` + "\n",
+		},
+		{
+			name:    "unknown csv column",
+			format:  gormdb.ExportFormatCsv,
+			content: "id,missing\n1,1\n",
+		},
+		{
+			name:    "invalid value",
+			format:  gormdb.ExportFormatCsv,
+			content: "id,level\n1,x\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newExportTestDb(t, nil)
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "export_items."+string(tt.format)), []byte(tt.content), 0o644); err != nil {
+				t.Fatalf("write fail. err:%v", err)
+			}
+
+			if _, err := gormDb.Import(context.Background(), 0, dir, nil); err == nil {
+				t.Fatalf("want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package gormdb
+
+import (
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type filterOp int
+
+const (
+	filterOpEq filterOp = iota
+	filterOpNe
+	filterOpGt
+	filterOpGte
+	filterOpLt
+	filterOpLte
+	filterOpIn
+	filterOpLike
+)
+
+type filterCondition struct {
+	column string
+	op     filterOp
+	value  interface{}
+}
+
+type filterOrder struct {
+	column string
+	desc   bool
+}
+
+// conditions on columns of M, joined by AND
+//
+// column is the column name or field name of M, unknown columns fail the query,
+// so column from user input can not inject sql
+type Filter[M any] struct {
+	conditions []filterCondition
+	orders     []filterOrder
+	limit      int
+}
+
+func NewFilter[M any]() *Filter[M] {
+	return &Filter[M]{}
+}
+
+func (o *Filter[M]) add(column string, op filterOp, value interface{}) *Filter[M] {
+	o.conditions = append(o.conditions, filterCondition{
+		column: column,
+		op:     op,
+		value:  value,
+	})
+	return o
+}
+
+// value nil means IS NULL
+func (o *Filter[M]) Eq(column string, value interface{}) *Filter[M] {
+	return o.add(column, filterOpEq, value)
+}
+
+// value nil means IS NOT NULL
+func (o *Filter[M]) Ne(column string, value interface{}) *Filter[M] {
+	return o.add(column, filterOpNe, value)
+}
+
+func (o *Filter[M]) Gt(column string, value interface{}) *Filter[M] {
+	return o.add(column, filterOpGt, value)
+}
+
+func (o *Filter[M]) Gte(column string, value interface{}) *Filter[M] {
+	return o.add(column, filterOpGte, value)
+}
+
+func (o *Filter[M]) Lt(column string, value interface{}) *Filter[M] {
+	return o.add(column, filterOpLt, value)
+}
+
+func (o *Filter[M]) Lte(column string, value interface{}) *Filter[M] {
+	return o.add(column, filterOpLte, value)
+}
+
+func (o *Filter[M]) In(column string, values ...interface{}) *Filter[M] {
+	return o.add(column, filterOpIn, values)
+}
+
+// pattern is used as is, escape % and _ by caller
+func (o *Filter[M]) Like(column string, pattern string) *Filter[M] {
+	return o.add(column, filterOpLike, pattern)
+}
+
+func (o *Filter[M]) Asc(column string) *Filter[M] {
+	o.orders = append(o.orders, filterOrder{column: column})
+	return o
+}
+
+func (o *Filter[M]) Desc(column string) *Filter[M] {
+	o.orders = append(o.orders, filterOrder{column: column, desc: true})
+	return o
+}
+
+// 0 means no limit, ignored by Repository.Page
+func (o *Filter[M]) Limit(limit int) *Filter[M] {
+	o.limit = limit
+	return o
+}
+
+func lookUpColumn(s *schema.Schema, column string) (string, error) {
+	field := s.LookUpField(column)
+	if field == nil || field.DBName == "" {
+		return "", serr.Errorf("unknown column. table:%s column:%s", s.Table, column)
+	}
+
+	return field.DBName, nil
+}
+
+func (o *filterCondition) expression(s *schema.Schema) (clause.Expression, error) {
+	dbName, err := lookUpColumn(s, o.column)
+	if err != nil {
+		return nil, err
+	}
+	column := clause.Column{Table: clause.CurrentTable, Name: dbName}
+
+	switch o.op {
+	case filterOpEq:
+		return clause.Eq{Column: column, Value: o.value}, nil
+	case filterOpNe:
+		return clause.Neq{Column: column, Value: o.value}, nil
+	case filterOpGt:
+		return clause.Gt{Column: column, Value: o.value}, nil
+	case filterOpGte:
+		return clause.Gte{Column: column, Value: o.value}, nil
+	case filterOpLt:
+		return clause.Lt{Column: column, Value: o.value}, nil
+	case filterOpLte:
+		return clause.Lte{Column: column, Value: o.value}, nil
+	case filterOpIn:
+		return clause.IN{Column: column, Values: o.value.([]interface{})}, nil
+	case filterOpLike:
+		return clause.Like{Column: column, Value: o.value}, nil
+	default:
+		return nil, serr.Errorf("unknown filter op:%d", o.op)
+	}
+}
+
+// apply conditions only, orders and limit are applied by caller
+func (o *Filter[M]) where(db *gorm.DB, s *schema.Schema) (*gorm.DB, error) {
+	if o == nil || len(o.conditions) == 0 {
+		return db, nil
+	}
+
+	expressions := make([]clause.Expression, 0, len(o.conditions))
+	for i := range o.conditions {
+		expression, err := o.conditions[i].expression(s)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expression)
+	}
+
+	return db.Clauses(clause.Where{Exprs: expressions}), nil
+}
+
+func (o *Filter[M]) getOrders() []filterOrder {
+	if o == nil {
+		return nil
+	}
+	return o.orders
+}
+
+func (o *Filter[M]) getLimit() int {
+	if o == nil {
+		return 0
+	}
+	return o.limit
+}
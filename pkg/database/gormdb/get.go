@@ -1,94 +1,404 @@
 package gormdb
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
 
 	"github.com/MinamiKotoriCute/serr"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 )
 
-func (o *GormDb[T]) connect(dbType T) error {
-	dsn := ""
-	if t, ok := any(dbType).(DbTypeGetDsn); ok {
-		dsn = t.GetDsn(o.config)
-	} else {
-		return serr.New("dsn is empty")
+// retry Config.ConnectMaxRetry times with exponential backoff,
+// so database starting at the same time is not a failure
+//
+// the connection is not published to o.db, caller must not hold o.mutex
+func (o *GormDb[T]) connect(ctx context.Context, dbType T) (*connection, error) {
+	backoff := o.config.ConnectRetryBackoff
+	if backoff == 0 {
+		backoff = time.Second
+	}
+	maxBackoff := o.config.ConnectRetryMaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = 30 * time.Second
+	}
+
+	for retry := 0; ; retry++ {
+		conn, err := o.connectOnce(ctx, dbType)
+		if err == nil || retry >= o.config.ConnectMaxRetry {
+			return conn, err
+		}
+
+		wait := backoffWithJitter(backoff, retry, maxBackoff)
+		o.log().WarnContext(ctx, "gormdb connect fail, retry later",
+			slog.Any("err", serr.ToJSON(err, true)),
+			slog.String("db_type", fmt.Sprint(dbType)),
+			slog.Int("retry", retry+1),
+			slog.Duration("wait", wait))
+		if sleepErr := sleepContext(ctx, wait); sleepErr != nil {
+			return nil, errors.Join(err, sleepErr)
+		}
 	}
+}
 
-	db, err := gorm.Open(mysql.Open(dsn), &gorm.Config{})
+func (o *GormDb[T]) connectOnce(ctx context.Context, dbType T) (*connection, error) {
+	getDsn, err := o.getDsnFunc(dbType)
 	if err != nil {
-		return serr.Wrapf(err, "dsn:%s", dsn)
+		return nil, err
 	}
 
-	o.db[dbType] = db
-	return nil
+	dialect := o.getDialect(dbType)
+	connector, err := o.newCredentialConnector(dialect, getDsn)
+	if err != nil {
+		return nil, err
+	}
+	dsn, err := connector.dsn(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// plugins are kept across reconnects, so metrics and caches are not lost
+	o.mutex.Lock()
+	log := o.newLogger(dbType)
+	metrics := o.getQueryMetrics(dbType)
+	slowQueries := o.getSlowQueryLog(dbType)
+	cachePlugin := o.getCachePlugin(dbType)
+	breaker := o.getCircuitBreaker(dbType)
+	o.mutex.Unlock()
+
+	sqlDb := sql.OpenDB(connector)
+	dialector, err := dialect.openConn(dsn, sqlDb)
+	if err != nil {
+		sqlDb.Close()
+		return nil, err
+	}
+
+	// ping by ourselves, so connect can be canceled by ctx
+	db, err := gorm.Open(dialector, &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               log,
+		NamingStrategy:       o.namingStrategy(),
+	})
+	if err != nil {
+		sqlDb.Close()
+		return nil, serr.Wrapf(err, "dsn:%s", connector.redactedDsn())
+	}
+
+	if err := db.Use(metrics); err != nil {
+		sqlDb.Close()
+		return nil, serr.Wrap(err)
+	}
+	if err := db.Use(slowQueries); err != nil {
+		sqlDb.Close()
+		return nil, serr.Wrap(err)
+	}
+	if err := db.Use(cachePlugin); err != nil {
+		sqlDb.Close()
+		return nil, serr.Wrap(err)
+	}
+	if err := db.Use(&queryTimeout{timeout: o.config.QueryTimeout}); err != nil {
+		sqlDb.Close()
+		return nil, serr.Wrap(err)
+	}
+	if breaker != nil {
+		if err := db.Use(breaker); err != nil {
+			sqlDb.Close()
+			return nil, serr.Wrap(err)
+		}
+	}
+	if t, ok := any(dbType).(DbTypeGetAuditTables); ok {
+		if tables := t.GetAuditTables(); len(tables) != 0 {
+			if err := db.Use(NewAuditPlugin(tables...)); err != nil {
+				sqlDb.Close()
+				return nil, serr.Wrap(err)
+			}
+		}
+	}
+
+	poolConfig := o.getPoolConfig(dbType)
+	poolConfig.apply(sqlDb)
+	if err := sqlDb.PingContext(ctx); err != nil {
+		sqlDb.Close()
+		return nil, serr.Wrapf(err, "dsn:%s", connector.redactedDsn())
+	}
+
+	conn := &connection{
+		db:  db,
+		dsn: connector.redactedDsn(),
+	}
+	if t, ok := any(dbType).(DbTypeGetReplicaDsns); ok && o.config.dsnOverride == nil {
+		if len(t.GetReplicaDsns(o.config)) != 0 {
+			rs, err := o.newReplicaSet(ctx, dialect, t.GetReplicaDsns, poolConfig)
+			if err != nil {
+				sqlDb.Close()
+				return nil, err
+			}
+			if err := db.Use(rs); err != nil {
+				rs.close()
+				sqlDb.Close()
+				return nil, serr.Wrap(err)
+			}
+			conn.replicas = rs
+		}
+	}
+
+	return conn, nil
 }
 
-func (o *GormDb[T]) createDatabaseIfNotExist(databaseName string) error {
-	var defaultDbType T
-	defaultDb := o.db[defaultDbType]
-	sql := fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s", databaseName)
-	result := defaultDb.Exec(sql)
-	if result.Error != nil {
-		serr.Wrapf(result.Error, "sql: %s", sql)
+func (o *GormDb[T]) getDsnFunc(dbType T) (func(config *Config) string, error) {
+	if o.config.dsnOverride != nil {
+		return func(config *Config) string {
+			_, dsn := o.config.dsnOverride(dbType, config)
+			return dsn
+		}, nil
 	}
 
-	return nil
+	t, ok := any(dbType).(DbTypeGetDsn)
+	if !ok {
+		return nil, serr.New("dsn is empty")
+	}
+
+	return t.GetDsn, nil
 }
 
-func (o *GormDb[T]) Connect() error {
-	var defaultDbType T
-	return o.connect(defaultDbType)
+func (o *GormDb[T]) getDialect(dbType T) Dialect {
+	if o.config.dsnOverride != nil {
+		dialect, _ := o.config.dsnOverride(dbType, o.config)
+		return dialect
+	}
+
+	if t, ok := any(dbType).(DbTypeGetDialect); ok {
+		return t.GetDialect()
+	}
+
+	return o.config.Dialect
 }
 
-// return a gorm.DB or panic
-//
-// if o.config.AutoMigrate is true:
-//
-//	will create database if not exist. database name = T.GetDatabase()
-//	will auto migrate tables if T.GetTables() is not empty
-func (o *GormDb[T]) GetDb(dbType T) *gorm.DB {
-	if db, ok := o.db[dbType]; ok {
-		return db
+// return the dialect and dsn of the server of dbType, see DbTypeGetServerDsn
+func (o *GormDb[T]) getServerDsnFunc(dbType T) (Dialect, func(config *Config) string) {
+	dialect := o.getDialect(dbType)
+	if t, ok := any(dbType).(DbTypeGetServerDsn); ok {
+		return dialect, t.GetServerDsn
 	}
 
-	o.mutex.Lock()
-	defer o.mutex.Unlock()
+	return dialect, dialect.serverDsn
+}
 
-	if db, ok := o.db[dbType]; ok {
-		return db
+// create the database on a connection to the server of dbType closed after,
+// so the database is created by the dialect of dbType and nothing is published to o.db
+func (o *GormDb[T]) createDatabaseIfNotExist(ctx context.Context, dbType T, databaseName string) error {
+	dialect, getDsn := o.getServerDsnFunc(dbType)
+	connector, err := o.newCredentialConnector(dialect, getDsn)
+	if err != nil {
+		return err
+	}
+	dsn, err := connector.dsn(ctx, false)
+	if err != nil {
+		return err
 	}
 
-	// init db
-	if o.config.AutoMigrate {
+	sqlDb := sql.OpenDB(connector)
+	defer sqlDb.Close()
+	dialector, err := dialect.openConn(dsn, sqlDb)
+	if err != nil {
+		return err
+	}
+	db, err := gorm.Open(dialector, &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               o.newLogger(dbType),
+		NamingStrategy:       o.namingStrategy(),
+	})
+	if err != nil {
+		return serr.Wrapf(err, "dsn:%s", connector.redactedDsn())
+	}
+
+	return dialect.createDatabaseIfNotExist(db.WithContext(ctx), databaseName)
+}
+
+// connect and migrate dbType, then publish it to o.db
+//
+// run once at the same time for each db type by getDb, caller must not hold o.mutex
+func (o *GormDb[T]) initDb(ctx context.Context, dbType T) (*gorm.DB, error) {
+	var defaultDbType T
+	// sqlite creates the database file on open
+	if o.config.AutoMigrate && dbType != defaultDbType && o.getDialect(dbType) != DialectSqlite {
 		databaseName := ""
 		if t, ok := any(dbType).(DbTypeGetDatabase); ok {
 			databaseName = t.GetDatabase()
 		} else {
-			panic("databaseName is empty")
+			return nil, serr.New("databaseName is empty")
 		}
 
-		if err := o.createDatabaseIfNotExist(databaseName); err != nil {
-			panic(err)
+		if err := o.createDatabaseIfNotExist(ctx, dbType, databaseName); err != nil {
+			return nil, err
 		}
 	}
 
-	if err := o.connect(dbType); err != nil {
-		panic(err)
+	conn, err := o.connect(ctx, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	o.mutex.RLock()
+	_, migrated := o.migrated[dbType]
+	o.mutex.RUnlock()
+	if !migrated && o.config.AutoMigrate {
+		if err := o.autoMigrate(ctx, conn.db, dbType); err != nil {
+			// connect again next time, so migration can be retried
+			conn.close()
+			return nil, err
+		}
 	}
-	db := o.db[dbType]
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
 
 	if o.config.AutoMigrate {
-		if t, ok := any(dbType).(DbTypeGetTables); ok {
-			if tables := t.GetTables(); len(tables) != 0 {
-				if err := db.AutoMigrate(tables...); err != nil {
-					panic(err)
-				}
+		o.migrated[dbType] = struct{}{}
+	}
+	db := o.publish(dbType, conn)
+
+	if o.isDynamic(dbType) {
+		if err := o.evictLeastRecentlyUsed(dbType); err != nil {
+			o.log().WarnContext(ctx, "gormdb evict least recently used fail", slog.Any("err", serr.ToJSON(err, true)))
+		}
+	}
+
+	return db, nil
+}
+
+// add conn to o.db and return its gorm.DB, if dbType is connected meanwhile, close conn and return the connected one
+//
+// caller must hold o.mutex
+func (o *GormDb[T]) publish(dbType T, conn *connection) *gorm.DB {
+	if db, ok := o.db[dbType]; ok {
+		conn.close()
+		return db
+	}
+
+	lastUsed := &atomic.Int64{}
+	lastUsed.Store(time.Now().UnixNano())
+	o.lastUsed[dbType] = lastUsed
+	o.dsns[dbType] = conn.dsn
+	if conn.replicas != nil {
+		o.replicas[dbType] = conn.replicas
+	}
+	o.db[dbType] = conn.db
+	return conn.db
+}
+
+// apply pending migrations, then auto migrate tables, then apply pending seeds
+func (o *GormDb[T]) autoMigrate(ctx context.Context, db *gorm.DB, dbType T) error {
+	// schema must be read from the primary
+	ctx = ForcePrimary(ctx)
+	if err := o.migrate(ctx, db, dbType); err != nil {
+		return err
+	}
+
+	if t, ok := any(dbType).(DbTypeGetTables); ok {
+		if tables := t.GetTables(); len(tables) != 0 {
+			if err := db.WithContext(ctx).AutoMigrate(tables...); err != nil {
+				return serr.Wrap(err)
 			}
 		}
 	}
 
+	if t, ok := any(dbType).(DbTypeGetAuditTables); ok && len(t.GetAuditTables()) != 0 {
+		if err := db.WithContext(ctx).AutoMigrate(&AuditLog{}); err != nil {
+			return serr.Wrap(err)
+		}
+	}
+
+	if _, err := o.applySeeds(ctx, db, dbType, false); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// a running initDb, other callers of the same db type wait for it
+type initCall struct {
+	done chan struct{}
+	db   *gorm.DB
+	err  error
+}
+
+// o.mutex is only held to read and publish, so connecting a slow db type not block others
+func (o *GormDb[T]) getDb(ctx context.Context, dbType T) (*gorm.DB, error) {
+	o.mutex.RLock()
+	db, ok := o.db[dbType]
+	if ok {
+		o.touch(dbType)
+	}
+	o.mutex.RUnlock()
+	if ok {
+		return db, nil
+	}
+
+	o.mutex.Lock()
+	if db, ok := o.db[dbType]; ok {
+		o.mutex.Unlock()
+		return db, nil
+	}
+	call, ok := o.inits[dbType]
+	if ok {
+		o.mutex.Unlock()
+
+		select {
+		case <-call.done:
+			return call.db, call.err
+		case <-ctx.Done():
+			return nil, serr.Wrap(ctx.Err())
+		}
+	}
+	call = &initCall{
+		done: make(chan struct{}),
+	}
+	o.inits[dbType] = call
+	o.mutex.Unlock()
+
+	call.db, call.err = o.initDb(ctx, dbType)
+
+	o.mutex.Lock()
+	delete(o.inits, dbType)
+	o.mutex.Unlock()
+	close(call.done)
+
+	return call.db, call.err
+}
+
+// connect the default db type, same as TryGetDb of it
+func (o *GormDb[T]) Connect() error {
+	var defaultDbType T
+	_, err := o.getDb(context.Background(), defaultDbType)
+	return err
+}
+
+// return a gorm.DB, connect on first call
+//
+// if o.config.AutoMigrate is true:
+//
+//	will create database if not exist. database name = T.GetDatabase()
+//	will apply pending migrations if T.GetMigrations() is not empty
+//	will auto migrate tables if T.GetTables() is not empty
+//	will apply pending seeds if T.GetSeeds() is not empty
+//
+// migrations run before auto migrate tables, so a migration can rename a column before auto migrate add it
+func (o *GormDb[T]) TryGetDb(dbType T) (*gorm.DB, error) {
+	return o.getDb(context.Background(), dbType)
+}
+
+// return a gorm.DB or panic, see TryGetDb
+func (o *GormDb[T]) GetDb(dbType T) *gorm.DB {
+	db, err := o.TryGetDb(dbType)
+	if err != nil {
+		panic(err)
+	}
+
 	return db
 }
 
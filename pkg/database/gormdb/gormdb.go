@@ -2,28 +2,167 @@ package gormdb
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/MinamiKotoriCute/jf/pkg/helper"
+	"github.com/MinamiKotoriCute/jf/pkg/trigger"
+	"github.com/MinamiKotoriCute/serr"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
 // T is a type representing the database of mappings
 type GormDb[T comparable] struct {
-	config *Config
-	db     map[T]*gorm.DB
-	mutex  sync.Mutex
+	config        *Config
+	dbTypes       []T
+	staticDbTypes map[T]struct{}
+	db            map[T]*gorm.DB
+	replicas      map[T]*replicaSet
+	lastUsed      map[T]*atomic.Int64
+	migrated      map[T]struct{}
+	metrics       map[T]*queryMetrics
+	caches        map[T]*cachePlugin
+	breakers      map[T]*circuitBreaker
+	slowQueries   map[T]*slowQueryLog
+	// redacted dsn of connected db types
+	dsns map[T]string
+	// running initDb of each db type
+	inits map[T]*initCall
+	// evicted pools waiting Config.DynamicDbCloseDelay
+	closing       map[*connection]*time.Timer
+	mutex         sync.RWMutex
+	idleTrigger   *trigger.Trigger
+	health        map[T]*HealthStatus
+	healthMutex   sync.Mutex
+	healthTrigger *trigger.Trigger
+	// schemas parsed by parseSchema
+	schemas sync.Map
 }
 
-func NewGormDb[T comparable](config *Config) *GormDb[T] {
+var _ helper.Service = (*GormDb[int])(nil)
+
+// dbTypes are connected and migrated at Start, other db types are connected on first TryGetDb
+func NewGormDb[T comparable](config *Config, dbTypes ...T) *GormDb[T] {
+	staticDbTypes := make(map[T]struct{}, len(dbTypes))
+	for _, dbType := range dbTypes {
+		staticDbTypes[dbType] = struct{}{}
+	}
+
 	return &GormDb[T]{
-		config: config,
+		config:        config,
+		dbTypes:       dbTypes,
+		staticDbTypes: staticDbTypes,
+		db:            make(map[T]*gorm.DB),
+		replicas:      make(map[T]*replicaSet),
+		lastUsed:      make(map[T]*atomic.Int64),
+		migrated:      make(map[T]struct{}),
+		metrics:       make(map[T]*queryMetrics),
+		caches:        make(map[T]*cachePlugin),
+		breakers:      make(map[T]*circuitBreaker),
+		slowQueries:   make(map[T]*slowQueryLog),
+		dsns:          make(map[T]string),
+		inits:         make(map[T]*initCall),
+		closing:       make(map[*connection]*time.Timer),
+		health:        make(map[T]*HealthStatus),
 	}
 }
 
+// connect and migrate all db types passed to NewGormDb, return every failure
+//
+// start closing idle dynamic db types if Config.DynamicDbIdleTimeout is set,
+// and health check if Config.HealthCheckInterval is set
 func (o *GormDb[T]) Start(ctx context.Context) error {
+	if o.config.DynamicDbIdleTimeout > 0 && o.idleTrigger == nil {
+		o.idleTrigger = trigger.NewTrigger(o.config.DynamicDbIdleTimeout/2, "gormdb evict idle", o.evictIdle)
+		if err := o.idleTrigger.Start(); err != nil {
+			o.stopTriggers()
+			return err
+		}
+	}
+
+	if o.config.HealthCheckInterval > 0 && o.healthTrigger == nil {
+		o.healthTrigger = trigger.NewTrigger(o.config.HealthCheckInterval, "gormdb health check", o.checkHealth)
+		if err := o.healthTrigger.Start(); err != nil {
+			o.stopTriggers()
+			return err
+		}
+	}
+
+	errs := []error{}
+	for _, dbType := range o.dbTypes {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, serr.Wrap(err))
+			break
+		}
+
+		if _, err := o.getDb(ctx, dbType); err != nil {
+			errs = append(errs, serr.Wrapf(err, "db type:%v", dbType))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		o.stopTriggers()
+		return err
+	}
 	return nil
 }
 
+func (o *GormDb[T]) stopTriggers() {
+	if o.idleTrigger != nil {
+		o.idleTrigger.Stop()
+		o.idleTrigger = nil
+	}
+	if o.healthTrigger != nil {
+		o.healthTrigger.Stop()
+		o.healthTrigger = nil
+	}
+}
+
+// close all connection pools, include replicas
 func (o *GormDb[T]) Stop(ctx context.Context) error {
-	return nil
+	o.stopTriggers()
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	errs := []error{}
+	for dbType := range o.db {
+		if err := o.closeDb(dbType, false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	// not wait the delay of evicted pools
+	for conn, timer := range o.closing {
+		if timer.Stop() {
+			if err := conn.close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		delete(o.closing, conn)
+	}
+	// migrate again after Start
+	clear(o.migrated)
+
+	return errors.Join(errs...)
+}
+
+func (o *GormDb[T]) namingStrategy() schema.Namer {
+	if o.config.NamingStrategy == nil {
+		return schema.NamingStrategy{}
+	}
+
+	return o.config.NamingStrategy
+}
+
+// parse model by the naming strategy of gorm.DB of every db type, before any db type is connected
+func (o *GormDb[T]) parseSchema(model interface{}) (*schema.Schema, error) {
+	s, err := schema.Parse(model, &o.schemas, o.namingStrategy())
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return s, nil
 }
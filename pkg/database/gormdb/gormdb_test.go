@@ -0,0 +1,80 @@
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+type lifecycleItem struct {
+	Id int64 `gorm:"primaryKey"`
+}
+
+type lifecycleDbType int
+
+func (lifecycleDbType) GetTables() []interface{} {
+	return []interface{}{&lifecycleItem{}}
+}
+
+func TestConnectMigratesDefault(t *testing.T) {
+	config := &Config{
+		AutoMigrate: true,
+	}
+	config.dsnOverride = func(dbType any, config *Config) (Dialect, string) {
+		return DialectSqlite, fmt.Sprintf("file:%s_%v?mode=memory&cache=shared", t.Name(), dbType)
+	}
+	gormDb := NewGormDb[lifecycleDbType](config)
+	t.Cleanup(func() {
+		gormDb.Stop(context.Background())
+	})
+
+	if err := gormDb.Connect(); err != nil {
+		t.Fatalf("connect fail. err:%v", err)
+	}
+	if !gormDb.Get().Migrator().HasTable(&lifecycleItem{}) {
+		t.Fatalf("default db type connected without migration")
+	}
+}
+
+func TestStartFailureStopsTriggers(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "health check",
+			config: Config{HealthCheckInterval: time.Hour},
+		},
+		{
+			name:   "idle eviction",
+			config: Config{DynamicDbIdleTimeout: time.Hour},
+		},
+		{
+			name:   "both",
+			config: Config{HealthCheckInterval: time.Hour, DynamicDbIdleTimeout: time.Hour},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			config.LogLevel = logger.Silent
+			// the directory not exist, so connect fails
+			dsn := filepath.Join(t.TempDir(), "missing", "db.sqlite")
+			config.dsnOverride = func(dbType any, config *Config) (Dialect, string) {
+				return DialectSqlite, dsn
+			}
+
+			gormDb := NewGormDb[lifecycleDbType](&config, 0)
+			if err := gormDb.Start(context.Background()); err == nil {
+				t.Fatalf("want error")
+			}
+			if gormDb.idleTrigger != nil || gormDb.healthTrigger != nil {
+				t.Fatalf("triggers not stopped")
+			}
+		})
+	}
+}
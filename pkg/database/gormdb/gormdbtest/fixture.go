@@ -0,0 +1,104 @@
+package gormdbtest
+
+import (
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// insert rows of fixture files, a fixture file is a JSON or YAML object like:
+//
+//	User:
+//	  - id: 1
+//	    name: alice
+//	orders:
+//	  - user_id: 1
+//
+// keys are the struct name or table name of one of tables, row keys are the column name or field name.
+// files and keys are inserted in order, so rows referenced by foreign keys must come first.
+func TryLoadFixtures(db *gorm.DB, tables []interface{}, paths ...string) error {
+	schemas := map[string]*schema.Schema{}
+	cache := &sync.Map{}
+	for _, table := range tables {
+		s, err := schema.Parse(table, cache, db.NamingStrategy)
+		if err != nil {
+			return serr.Wrap(err)
+		}
+		schemas[s.Name] = s
+		schemas[s.Table] = s
+	}
+
+	for _, path := range paths {
+		if err := loadFixture(db, schemas, path); err != nil {
+			return serr.Wrapf(err, "path:%s", path)
+		}
+	}
+
+	return nil
+}
+
+// insert rows of fixture files or fail the test, see TryLoadFixtures
+func LoadFixtures(t testing.TB, db *gorm.DB, tables []interface{}, paths ...string) {
+	t.Helper()
+
+	if err := TryLoadFixtures(db, tables, paths...); err != nil {
+		t.Fatalf("gormdbtest load fixtures fail. err:%v", err)
+	}
+}
+
+func loadFixture(db *gorm.DB, schemas map[string]*schema.Schema, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return serr.Wrap(err)
+	}
+
+	// JSON is YAML, and yaml.Node keeps the order of keys
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return serr.Wrap(err)
+	}
+	if len(document.Content) == 0 {
+		return nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return serr.Errorf("fixture must be an object. line:%d", root.Line)
+	}
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		s, ok := schemas[key.Value]
+		if !ok {
+			return serr.Errorf("unknown table:%s line:%d", key.Value, key.Line)
+		}
+
+		rows := []map[string]interface{}{}
+		if err := value.Decode(&rows); err != nil {
+			return serr.Wrapf(err, "table:%s", key.Value)
+		}
+		for _, row := range rows {
+			model := reflect.New(s.ModelType)
+			for column, v := range row {
+				field := s.LookUpField(column)
+				if field == nil {
+					return serr.Errorf("unknown column:%s table:%s", column, key.Value)
+				}
+				if err := field.Set(db.Statement.Context, model.Elem(), v); err != nil {
+					return serr.Wrapf(err, "column:%s table:%s", column, key.Value)
+				}
+			}
+
+			if err := db.Create(model.Interface()).Error; err != nil {
+				return serr.Wrapf(err, "table:%s", key.Value)
+			}
+		}
+	}
+
+	return nil
+}
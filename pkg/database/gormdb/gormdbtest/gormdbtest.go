@@ -0,0 +1,85 @@
+// gormdbtest builds GormDb backed by in-memory sqlite databases for tests,
+// so tests not need a database service
+package gormdbtest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/internal/testhook"
+)
+
+var databaseId atomic.Int64
+
+// return a started GormDb, every db type has its own in-memory sqlite database
+// only visible to this test, migrations and DbTypeGetTables are applied on first use.
+// the GormDb is stopped and the databases are dropped when the test ends
+//
+// dbTypes are connected at start, config may be nil
+func New[T comparable](t testing.TB, config *gormdb.Config, dbTypes ...T) *gormdb.GormDb[T] {
+	t.Helper()
+
+	c := gormdb.Config{}
+	if config != nil {
+		c = *config
+	}
+	c.AutoMigrate = true
+	// closing the pool drops an in-memory database
+	c.DynamicDbIdleTimeout = 0
+	c.MaxDynamicDbs = 0
+	c.Pool.ConnMaxLifetime = 0
+	c.Pool.ConnMaxIdleTime = 0
+
+	name := fmt.Sprintf("%s_%d", t.Name(), databaseId.Add(1))
+	gormdb.SetDsnOverride(testhook.Token{}, &c, func(dbType any, config *gormdb.Config) (gormdb.Dialect, string) {
+		return gormdb.DialectSqlite, fmt.Sprintf("file:%s?mode=memory&cache=shared", sanitize(fmt.Sprintf("%s_%v", name, dbType)))
+	})
+
+	gormDb := gormdb.NewGormDb(&c, dbTypes...)
+	if err := gormDb.Start(context.Background()); err != nil {
+		gormDb.Stop(context.Background())
+		t.Fatalf("gormdbtest start fail. err:%v", err)
+	}
+	t.Cleanup(func() {
+		if err := gormDb.Stop(context.Background()); err != nil {
+			t.Errorf("gormdbtest stop fail. err:%v", err)
+		}
+	})
+
+	return gormDb
+}
+
+// the database name is part of a sqlite uri
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '-' || r == '.' ||
+			(r >= '0' && r <= '9') || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// begin a transaction of dbType rolled back when the test ends,
+// FromCtx(ctx, dbType) and WithTx with the returned ctx use it
+//
+// tables written by the transaction are locked to other connections, so use the returned ctx everywhere
+func Tx[T comparable](t testing.TB, gormDb *gormdb.GormDb[T], dbType T) context.Context {
+	t.Helper()
+
+	ctx, tx, err := gormDb.Begin(context.Background(), dbType)
+	if err != nil {
+		t.Fatalf("gormdbtest begin fail. err:%v", err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback().Error; err != nil {
+			t.Errorf("gormdbtest rollback fail. err:%v", err)
+		}
+	})
+
+	return ctx
+}
@@ -0,0 +1,238 @@
+package gormdbtest_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type fixtureUser struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+type fixtureOrder struct {
+	Id            int64 `gorm:"primaryKey"`
+	FixtureUserId int64
+}
+
+type fixtureDbType int
+
+func (fixtureDbType) GetTables() []interface{} {
+	return []interface{}{&fixtureUser{}, &fixtureOrder{}}
+}
+
+func writeFixture(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write fixture fail. err:%v", err)
+	}
+	return path
+}
+
+func TestTryLoadFixtures(t *testing.T) {
+	tests := []struct {
+		name       string
+		files      map[string]string
+		wantUsers  string
+		wantOrders string
+		wantErr    bool
+	}{
+		{
+			name: "yaml of struct and field names",
+			files: map[string]string{
+				"a.yaml": "fixtureUser:\n  - Id: 1\n    Name: alice\n  - Id: 2\n    Name: bob\n",
+			},
+			wantUsers:  "[1:alice 2:bob]",
+			wantOrders: "[]",
+		},
+		{
+			name: "json of table and column names",
+			files: map[string]string{
+				"a.json": `{"fixture_users": [{"id": 1, "name": "alice"}], "fixture_orders": [{"id": 7, "fixture_user_id": 1}]}`,
+			},
+			wantUsers:  "[1:alice]",
+			wantOrders: "[7:1]",
+		},
+		{
+			name: "files in order",
+			files: map[string]string{
+				"a.yaml": "fixtureUser:\n  - id: 1\n",
+				"b.yaml": "fixtureOrder:\n  - id: 1\n    fixture_user_id: 1\n",
+			},
+			wantUsers:  "[1:]",
+			wantOrders: "[1:1]",
+		},
+		{
+			name: "empty file",
+			files: map[string]string{
+				"a.yaml": "",
+			},
+			wantUsers:  "[]",
+			wantOrders: "[]",
+		},
+		{
+			name: "unknown table",
+			files: map[string]string{
+				"a.yaml": "missing:\n  - id: 1\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown column",
+			files: map[string]string{
+				"a.yaml": "fixtureUser:\n  - id: 1\n    missing: 1\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "not an object",
+			files: map[string]string{
+				"a.yaml": "- id: 1\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate row",
+			files: map[string]string{
+				"a.yaml": "fixtureUser:\n  - id: 1\n  - id: 1\n",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[fixtureDbType](t, &gormdb.Config{LogLevel: logger.Silent}, 0)
+			db := gormDb.GetDb(0)
+
+			// map order is random, files are named to be loaded in name order
+			paths := []string{}
+			for _, name := range []string{"a.yaml", "a.json", "b.yaml"} {
+				if content, ok := tt.files[name]; ok {
+					paths = append(paths, writeFixture(t, name, content))
+				}
+			}
+			err := gormdbtest.TryLoadFixtures(db, fixtureDbType(0).GetTables(), paths...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			users := []*fixtureUser{}
+			if err := db.Order("id").Find(&users).Error; err != nil {
+				t.Fatalf("find users fail. err:%v", err)
+			}
+			got := []string{}
+			for _, user := range users {
+				got = append(got, fmt.Sprintf("%d:%s", user.Id, user.Name))
+			}
+			if fmt.Sprint(got) != tt.wantUsers {
+				t.Fatalf("users:%v want:%s", got, tt.wantUsers)
+			}
+
+			orders := []*fixtureOrder{}
+			if err := db.Order("id").Find(&orders).Error; err != nil {
+				t.Fatalf("find orders fail. err:%v", err)
+			}
+			got = []string{}
+			for _, order := range orders {
+				got = append(got, fmt.Sprintf("%d:%d", order.Id, order.FixtureUserId))
+			}
+			if fmt.Sprint(got) != tt.wantOrders {
+				t.Fatalf("orders:%v want:%s", got, tt.wantOrders)
+			}
+		})
+	}
+}
+
+func TestLoadFixturesMissingFile(t *testing.T) {
+	gormDb := gormdbtest.New[fixtureDbType](t, nil, 0)
+	err := gormdbtest.TryLoadFixtures(gormDb.GetDb(0), fixtureDbType(0).GetTables(), filepath.Join(t.TempDir(), "missing.yaml"))
+	if err == nil {
+		t.Fatalf("want error")
+	}
+}
+
+func TestTx(t *testing.T) {
+	gormDb := gormdbtest.New[fixtureDbType](t, nil, 0)
+
+	t.Run("in tx", func(t *testing.T) {
+		ctx := gormdbtest.Tx(t, gormDb, 0)
+		if err := gormDb.FromCtx(ctx, 0).Create(&fixtureUser{Id: 1, Name: "alice"}).Error; err != nil {
+			t.Fatalf("create fail. err:%v", err)
+		}
+		err := gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+			return gormDb.FromCtx(ctx, 0).Create(&fixtureUser{Id: 2, Name: "bob"}).Error
+		})
+		if err != nil {
+			t.Fatalf("with tx fail. err:%v", err)
+		}
+
+		var count int64
+		if err := gormDb.FromCtx(ctx, 0).Model(&fixtureUser{}).Count(&count).Error; err != nil {
+			t.Fatalf("count fail. err:%v", err)
+		}
+		if count != 2 {
+			t.Fatalf("count:%d want:2", count)
+		}
+	})
+
+	// rolled back when the subtest ended
+	var count int64
+	if err := gormDb.GetDb(0).Model(&fixtureUser{}).Count(&count).Error; err != nil {
+		t.Fatalf("count fail. err:%v", err)
+	}
+	if count != 0 {
+		t.Fatalf("rows of tx not rolled back. count:%d", count)
+	}
+}
+
+func TestNewIsolated(t *testing.T) {
+	first := gormdbtest.New[fixtureDbType](t, nil, 0)
+	second := gormdbtest.New[fixtureDbType](t, nil, 0)
+	if err := first.GetDb(0).Create(&fixtureUser{Id: 1}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+
+	tests := []struct {
+		name string
+		db   *gorm.DB
+		want int64
+	}{
+		{
+			name: "first",
+			db:   first.GetDb(0),
+			want: 1,
+		},
+		{
+			name: "second",
+			db:   second.GetDb(0),
+			want: 0,
+		},
+		{
+			name: "dynamic db type of first",
+			db:   first.GetDb(1),
+			want: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var count int64
+			if err := tt.db.Model(&fixtureUser{}).Count(&count).Error; err != nil {
+				t.Fatalf("count fail. err:%v", err)
+			}
+			if count != tt.want {
+				t.Fatalf("count:%d want:%d", count, tt.want)
+			}
+		})
+	}
+}
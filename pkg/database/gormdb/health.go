@@ -0,0 +1,187 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+type HealthStatus struct {
+	Connected bool
+	Healthy   bool
+	// zero if never checked
+	LastCheck time.Time
+	// latency of the last ping
+	Latency time.Duration
+	Err     error
+	// consecutive failed checks
+	Failures int
+	// replicas of DbTypeGetReplicaDsns
+	Replicas []*ReplicaHealth
+}
+
+type ReplicaHealth struct {
+	// password is replaced
+	Dsn string
+	// false while out of rotation after a connection error, until DownUntil
+	Healthy   bool
+	DownUntil time.Time
+	// moving average of reads
+	Latency time.Duration
+}
+
+// ping every connected db type and connect db types passed to NewGormDb which are not connected yet
+//
+// run by trigger every Config.HealthCheckInterval
+func (o *GormDb[T]) checkHealth() error {
+	timeout := o.config.HealthCheckTimeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	o.mutex.RLock()
+	missing := []T{}
+	for _, dbType := range o.dbTypes {
+		if _, ok := o.db[dbType]; !ok {
+			missing = append(missing, dbType)
+		}
+	}
+	o.mutex.RUnlock()
+
+	for _, dbType := range missing {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		_, err := o.getDb(ctx, dbType)
+		cancel()
+		if err != nil {
+			o.setHealth(dbType, false, 0, err)
+		}
+	}
+
+	// include db types just connected, so their failures are cleared
+	o.mutex.RLock()
+	sqlDbs := make(map[T]*sql.DB, len(o.db))
+	for dbType, db := range o.db {
+		if sqlDb, err := db.DB(); err == nil {
+			sqlDbs[dbType] = sqlDb
+		}
+	}
+	o.mutex.RUnlock()
+
+	for dbType, sqlDb := range sqlDbs {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		start := time.Now()
+		err := sqlDb.PingContext(ctx)
+		cancel()
+		o.setHealth(dbType, true, time.Since(start), err)
+	}
+
+	// forget db types closed by Stop or eviction during the ping
+	o.mutex.RLock()
+	connected := make(map[T]struct{}, len(o.db))
+	for dbType := range o.db {
+		connected[dbType] = struct{}{}
+	}
+	o.mutex.RUnlock()
+
+	o.healthMutex.Lock()
+	for dbType, status := range o.health {
+		if _, ok := connected[dbType]; !ok && status.Connected {
+			delete(o.health, dbType)
+		}
+	}
+	o.healthMutex.Unlock()
+
+	return nil
+}
+
+func (o *GormDb[T]) setHealth(dbType T, connected bool, latency time.Duration, err error) {
+	o.healthMutex.Lock()
+	defer o.healthMutex.Unlock()
+
+	status, ok := o.health[dbType]
+	if !ok {
+		status = &HealthStatus{Healthy: true}
+		o.health[dbType] = status
+	}
+
+	healthy := connected && err == nil
+	if status.Healthy && !healthy {
+		o.log().Warn("gormdb db type unhealthy",
+			slog.String("db_type", fmt.Sprint(dbType)),
+			slog.Any("err", serr.ToJSON(err, true)))
+	} else if !status.Healthy && healthy {
+		o.log().Info("gormdb db type healthy again",
+			slog.String("db_type", fmt.Sprint(dbType)),
+			slog.Int("failures", status.Failures))
+	}
+
+	status.Connected = connected
+	status.Healthy = healthy
+	status.LastCheck = time.Now()
+	status.Latency = latency
+	status.Err = err
+	if healthy {
+		status.Failures = 0
+	} else {
+		status.Failures++
+	}
+}
+
+// return health of db types passed to NewGormDb and every connected db type
+//
+// without Config.HealthCheckInterval, a connected db type is always healthy
+func (o *GormDb[T]) Health() map[T]*HealthStatus {
+	o.mutex.RLock()
+	result := make(map[T]*HealthStatus, len(o.db))
+	for _, dbType := range o.dbTypes {
+		result[dbType] = &HealthStatus{}
+	}
+	for dbType := range o.db {
+		result[dbType] = &HealthStatus{
+			Connected: true,
+			Healthy:   true,
+		}
+	}
+	replicas := make(map[T][]*ReplicaHealth, len(o.replicas))
+	for dbType, rs := range o.replicas {
+		replicas[dbType] = rs.health()
+	}
+	o.mutex.RUnlock()
+
+	o.healthMutex.Lock()
+	defer o.healthMutex.Unlock()
+	for dbType, status := range o.health {
+		if _, ok := result[dbType]; ok {
+			s := *status
+			result[dbType] = &s
+		}
+	}
+	for dbType, status := range result {
+		status.Replicas = replicas[dbType]
+	}
+
+	return result
+}
+
+// return nil if all db types passed to NewGormDb are connected and healthy, for readiness probe
+func (o *GormDb[T]) Ready() error {
+	errs := []error{}
+	for dbType, status := range o.Health() {
+		if _, ok := o.staticDbTypes[dbType]; !ok || status.Healthy {
+			continue
+		}
+
+		if status.Err != nil {
+			errs = append(errs, serr.Wrapf(status.Err, "db type:%v", dbType))
+		} else {
+			errs = append(errs, serr.Errorf("db type not ready. db_type:%v", dbType))
+		}
+	}
+
+	return errors.Join(errs...)
+}
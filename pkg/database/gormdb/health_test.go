@@ -0,0 +1,245 @@
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+type healthDbType int
+
+// every db type is the sqlite file db.sqlite in dir, dir not exist until created
+func newHealthTestDb(t *testing.T, config Config, dir string, dbTypes ...healthDbType) *GormDb[healthDbType] {
+	config.LogLevel = logger.Silent
+	if config.Log == nil {
+		config.Log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	config.dsnOverride = func(dbType any, config *Config) (Dialect, string) {
+		return DialectSqlite, filepath.Join(dir, "db.sqlite")
+	}
+	gormDb := NewGormDb(&config, dbTypes...)
+	t.Cleanup(func() {
+		gormDb.Stop(context.Background())
+	})
+
+	return gormDb
+}
+
+func TestCheckHealth(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	gormDb := newHealthTestDb(t, Config{}, dir, 1)
+
+	steps := []struct {
+		name          string
+		run           func()
+		wantConnected bool
+		wantHealthy   bool
+		wantFailures  int
+	}{
+		{
+			name:          "not connected before check",
+			run:           func() {},
+			wantConnected: false,
+			wantHealthy:   false,
+		},
+		{
+			name:         "connect fail",
+			run:          func() {},
+			wantFailures: 1,
+		},
+		{
+			name:         "connect fail again",
+			run:          func() {},
+			wantFailures: 2,
+		},
+		{
+			name: "connected by check",
+			run: func() {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatalf("mkdir fail. err:%v", err)
+				}
+			},
+			wantConnected: true,
+			wantHealthy:   true,
+		},
+		{
+			name: "ping fail",
+			run: func() {
+				sqlDb, err := gormDb.GetDb(1).DB()
+				if err != nil {
+					t.Fatalf("db fail. err:%v", err)
+				}
+				sqlDb.Close()
+			},
+			wantConnected: true,
+			wantFailures:  1,
+		},
+	}
+	for i, step := range steps {
+		step.run()
+		if i != 0 {
+			if err := gormDb.checkHealth(); err != nil {
+				t.Fatalf("%s check fail. err:%v", step.name, err)
+			}
+		}
+
+		status := gormDb.Health()[1]
+		if status == nil {
+			t.Fatalf("%s no status", step.name)
+		}
+		if status.Connected != step.wantConnected || status.Healthy != step.wantHealthy || status.Failures != step.wantFailures {
+			t.Fatalf("%s status:%+v want connected:%v healthy:%v failures:%d",
+				step.name, status, step.wantConnected, step.wantHealthy, step.wantFailures)
+		}
+		if (status.Err == nil) != step.wantHealthy && i != 0 {
+			t.Fatalf("%s err:%v want healthy:%v", step.name, status.Err, step.wantHealthy)
+		}
+		if err := gormDb.Ready(); (err == nil) != step.wantHealthy {
+			t.Fatalf("%s ready:%v want healthy:%v", step.name, err, step.wantHealthy)
+		}
+	}
+}
+
+func TestReadyIgnoresDynamic(t *testing.T) {
+	dir := t.TempDir()
+	gormDb := newHealthTestDb(t, Config{}, dir, 1)
+	if _, err := gormDb.TryGetDb(1); err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+	if _, err := gormDb.TryGetDb(2); err != nil {
+		t.Fatalf("get db fail. err:%v", err)
+	}
+
+	sqlDb, err := gormDb.GetDb(2).DB()
+	if err != nil {
+		t.Fatalf("db fail. err:%v", err)
+	}
+	sqlDb.Close()
+	if err := gormDb.checkHealth(); err != nil {
+		t.Fatalf("check fail. err:%v", err)
+	}
+
+	if status := gormDb.Health()[2]; status.Healthy {
+		t.Fatalf("closed dynamic db type is healthy")
+	}
+	if err := gormDb.Ready(); err != nil {
+		t.Fatalf("dynamic db type affects ready. err:%v", err)
+	}
+}
+
+// a slog.Handler calls onRetry with the retry count of each connect retry log
+type retryLogHandler struct {
+	mutex   sync.Mutex
+	retries int
+	onRetry func(retry int)
+}
+
+func (o *retryLogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (o *retryLogHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Message != "gormdb connect fail, retry later" {
+		return nil
+	}
+
+	o.mutex.Lock()
+	o.retries++
+	retries := o.retries
+	o.mutex.Unlock()
+	o.onRetry(retries)
+	return nil
+}
+
+func (o *retryLogHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return o
+}
+
+func (o *retryLogHandler) WithGroup(string) slog.Handler {
+	return o
+}
+
+func TestConnectRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		maxRetry int
+		backoff  time.Duration
+		// create the directory of db at this retry, 0 means never
+		fixAt       int
+		cancelAt    int
+		wantRetries int
+		wantErr     error
+		wantFail    bool
+	}{
+		{
+			name:        "no retry",
+			wantRetries: 0,
+			wantFail:    true,
+		},
+		{
+			name:        "retry until connected",
+			maxRetry:    5,
+			backoff:     time.Millisecond,
+			fixAt:       2,
+			wantRetries: 2,
+		},
+		{
+			name:        "exceed max retry",
+			maxRetry:    2,
+			backoff:     time.Millisecond,
+			wantRetries: 2,
+			wantFail:    true,
+		},
+		{
+			name:        "canceled in backoff",
+			maxRetry:    5,
+			backoff:     time.Hour,
+			cancelAt:    1,
+			wantRetries: 1,
+			wantErr:     context.Canceled,
+			wantFail:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "missing")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			handler := &retryLogHandler{
+				onRetry: func(retry int) {
+					if retry == tt.fixAt {
+						os.MkdirAll(dir, 0o755)
+					}
+					if retry == tt.cancelAt {
+						cancel()
+					}
+				},
+			}
+			gormDb := newHealthTestDb(t, Config{
+				Log:                 slog.New(handler),
+				ConnectMaxRetry:     tt.maxRetry,
+				ConnectRetryBackoff: tt.backoff,
+			}, dir)
+
+			_, err := gormDb.getDb(ctx, 1)
+			if (err != nil) != tt.wantFail {
+				t.Fatalf("err:%v want fail:%v", err, tt.wantFail)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err:%v want:%v", err, tt.wantErr)
+			}
+			if handler.retries != tt.wantRetries {
+				t.Fatalf("retries:%d want:%d", handler.retries, tt.wantRetries)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// testhook seals accessors of gormdb meant only for gormdbtest,
+// packages outside gormdb can not import it
+package testhook
+
+// only packages under gormdb can make a Token, so only they can call accessors taking it
+type Token struct{}
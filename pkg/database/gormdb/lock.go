@@ -0,0 +1,103 @@
+package gormdb
+
+import (
+	"database/sql"
+	"hash/fnv"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+const lockPollInterval = 100 * time.Millisecond
+
+// db must hold a single connection (see gorm.DB.Connection), the lock belongs to that session
+//
+// sqlite is a local file, lock is always acquired
+func (d Dialect) tryLock(db *gorm.DB, name string, timeout time.Duration) (bool, error) {
+	switch d {
+	case "", DialectMysql:
+		var result sql.NullInt64
+		if err := db.Raw("SELECT GET_LOCK(?, ?)", name, int64(timeout/time.Second)).Scan(&result).Error; err != nil {
+			return false, serr.Wrap(err)
+		}
+		return result.Valid && result.Int64 == 1, nil
+	case DialectPostgresql:
+		// pg_advisory_lock has no timeout, poll pg_try_advisory_lock instead
+		deadline := time.Now().Add(timeout)
+		for {
+			var locked bool
+			if err := db.Raw("SELECT pg_try_advisory_lock(?)", advisoryLockKey(name)).Scan(&locked).Error; err != nil {
+				return false, serr.Wrap(err)
+			}
+			if locked {
+				return true, nil
+			}
+			if time.Now().After(deadline) {
+				return false, nil
+			}
+
+			select {
+			case <-db.Statement.Context.Done():
+				return false, serr.Wrap(db.Statement.Context.Err())
+			case <-time.After(lockPollInterval):
+			}
+		}
+	case DialectSqlite:
+		return true, nil
+	default:
+		return false, serr.Errorf("unknown dialect:%s", d)
+	}
+}
+
+func (d Dialect) unlock(db *gorm.DB, name string) error {
+	switch d {
+	case "", DialectMysql:
+		if err := db.Exec("SELECT RELEASE_LOCK(?)", name).Error; err != nil {
+			return serr.Wrap(err)
+		}
+	case DialectPostgresql:
+		if err := db.Exec("SELECT pg_advisory_unlock(?)", advisoryLockKey(name)).Error; err != nil {
+			return serr.Wrap(err)
+		}
+	case DialectSqlite:
+	default:
+		return serr.Errorf("unknown dialect:%s", d)
+	}
+
+	return nil
+}
+
+// whether the session of db still holds the lock
+func (d Dialect) isLocked(db *gorm.DB, name string) (bool, error) {
+	switch d {
+	case "", DialectMysql:
+		var locked sql.NullBool
+		if err := db.Raw("SELECT IS_USED_LOCK(?) = CONNECTION_ID()", name).Scan(&locked).Error; err != nil {
+			return false, serr.Wrap(err)
+		}
+		return locked.Valid && locked.Bool, nil
+	case DialectPostgresql:
+		// a bigint key is split to classid and objid in pg_locks
+		key := uint64(advisoryLockKey(name))
+		var locked bool
+		if err := db.Raw("SELECT EXISTS(SELECT 1 FROM pg_locks WHERE locktype = 'advisory' AND pid = pg_backend_pid() AND classid = ? AND objid = ? AND objsubid = 1)",
+			int64(key>>32), int64(key&0xffffffff)).Scan(&locked).Error; err != nil {
+			return false, serr.Wrap(err)
+		}
+		return locked, nil
+	case DialectSqlite:
+		if err := db.Exec("SELECT 1").Error; err != nil {
+			return false, serr.Wrap(err)
+		}
+		return true, nil
+	default:
+		return false, serr.Errorf("unknown dialect:%s", d)
+	}
+}
+
+func advisoryLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,290 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// keep this many recent slow queries of each db type
+const slowQueryLogSize = 50
+
+const slowQueryStartSettingKey = "jf:slow_query_start"
+
+// prefix of sql returned by SlogLogger.ParamsFilter
+const filteredSqlPrefix = "jf:filtered:"
+
+type SlowQuery struct {
+	Time time.Time
+	// with placeholders, bound values like tokens and emails are not kept
+	Sql     string
+	Rows    int64
+	Elapsed time.Duration
+	File    string
+}
+
+// a gorm plugin keep a ring buffer of recent slow queries
+type slowQueryLog struct {
+	threshold time.Duration
+	mutex     sync.Mutex
+	queries   []*SlowQuery
+	next      int
+}
+
+func (o *slowQueryLog) Name() string {
+	return "jf:slow_queries"
+}
+
+func (o *slowQueryLog) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("*").Register("jf:slow_queries", o.before),
+		callback.Create().After("*").Register("jf:slow_queries_after", o.after),
+		callback.Query().Before("*").Register("jf:slow_queries", o.before),
+		callback.Query().After("*").Register("jf:slow_queries_after", o.after),
+		callback.Update().Before("*").Register("jf:slow_queries", o.before),
+		callback.Update().After("*").Register("jf:slow_queries_after", o.after),
+		callback.Delete().Before("*").Register("jf:slow_queries", o.before),
+		callback.Delete().After("*").Register("jf:slow_queries_after", o.after),
+		callback.Row().Before("*").Register("jf:slow_queries", o.before),
+		callback.Row().After("*").Register("jf:slow_queries_after", o.after),
+		callback.Raw().Before("*").Register("jf:slow_queries", o.before),
+		callback.Raw().After("*").Register("jf:slow_queries_after", o.after),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *slowQueryLog) before(db *gorm.DB) {
+	if o.threshold > 0 {
+		db.Statement.Settings.Store(slowQueryStartSettingKey, time.Now())
+	}
+}
+
+// the statement still has placeholders here, the logger only sees sql with values
+func (o *slowQueryLog) after(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(slowQueryStartSettingKey)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(v.(time.Time))
+	if elapsed <= o.threshold || db.Statement.SQL.Len() == 0 ||
+		(db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound)) {
+		return
+	}
+
+	o.add(&SlowQuery{
+		Time:    time.Now(),
+		Sql:     db.Statement.SQL.String(),
+		Rows:    db.RowsAffected,
+		Elapsed: elapsed,
+		File:    utils.FileWithLineNum(),
+	})
+}
+
+func (o *slowQueryLog) add(query *SlowQuery) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if len(o.queries) < slowQueryLogSize {
+		o.queries = append(o.queries, query)
+		return
+	}
+	o.queries[o.next] = query
+	o.next = (o.next + 1) % slowQueryLogSize
+}
+
+// newest first
+func (o *slowQueryLog) snapshot() []*SlowQuery {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	result := make([]*SlowQuery, 0, len(o.queries))
+	for i := 0; i < len(o.queries); i++ {
+		q := *o.queries[(o.next-1-i+2*len(o.queries))%len(o.queries)]
+		result = append(result, &q)
+	}
+	return result
+}
+
+// a gorm logger.Interface write to slog
+type SlogLogger struct {
+	log           *slog.Logger
+	level         logger.LogLevel
+	slowThreshold time.Duration
+}
+
+var _ logger.Interface = (*SlogLogger)(nil)
+var _ gorm.ParamsFilter = (*SlogLogger)(nil)
+
+// slowThreshold 0 means not log slow query
+func NewSlogLogger(log *slog.Logger, level logger.LogLevel, slowThreshold time.Duration) *SlogLogger {
+	if log == nil {
+		log = slog.Default()
+	}
+
+	return &SlogLogger{
+		log:           log,
+		level:         level,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (o *SlogLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l := *o
+	l.level = level
+	return &l
+}
+
+func (o *SlogLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if o.level >= logger.Info {
+		o.log.InfoContext(ctx, fmt.Sprintf(msg, args...), slog.String("file", utils.FileWithLineNum()))
+	}
+}
+
+func (o *SlogLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if o.level >= logger.Warn {
+		o.log.WarnContext(ctx, fmt.Sprintf(msg, args...), slog.String("file", utils.FileWithLineNum()))
+	}
+}
+
+func (o *SlogLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if o.level >= logger.Error {
+		o.log.ErrorContext(ctx, fmt.Sprintf(msg, args...), slog.String("file", utils.FileWithLineNum()))
+	}
+}
+
+// gorm calls it in fc of Trace, then fills values into the returned sql by Dialector.Explain.
+// the sql and the count of values are encoded, so Explain has nothing to fill and Trace logs
+// placeholders, logs never have bound values like tokens and emails
+func (o *SlogLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return fmt.Sprintf("%s%d:%s", filteredSqlPrefix, len(params), base64.StdEncoding.EncodeToString([]byte(sql))), nil
+}
+
+// return the sql with placeholders and the count of values encoded by ParamsFilter,
+// false if s is not from ParamsFilter, like the sql of Scan which gorm records with values
+func decodeFilteredSql(s string) (string, int, bool) {
+	rest, ok := strings.CutPrefix(s, filteredSqlPrefix)
+	if !ok {
+		return "", 0, false
+	}
+	count, encoded, ok := strings.Cut(rest, ":")
+	if !ok {
+		return "", 0, false
+	}
+	vars, err := strconv.Atoi(count)
+	if err != nil {
+		return "", 0, false
+	}
+	sql, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return string(sql), vars, true
+}
+
+// sql with values is not logged
+func queryAttrs(fc func() (string, int64), elapsed time.Duration) []any {
+	s, rows := fc()
+	attrs := []any{}
+	if sql, vars, ok := decodeFilteredSql(s); ok {
+		attrs = append(attrs, slog.String("sql", sql), slog.Int("vars", vars))
+	}
+
+	return append(attrs,
+		slog.Int64("rows", rows),
+		slog.Duration("elapsed", elapsed),
+		slog.String("file", utils.FileWithLineNum()))
+}
+
+func (o *SlogLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if o.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	isError := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+	isSlow := o.slowThreshold > 0 && elapsed > o.slowThreshold
+
+	switch {
+	case isError && o.level >= logger.Error:
+		o.log.ErrorContext(ctx, "gorm query error",
+			append([]any{slog.Any("err", serr.ToJSON(serr.Wrap(err), true))}, queryAttrs(fc, elapsed)...)...)
+	case isSlow && o.level >= logger.Warn:
+		o.log.WarnContext(ctx, "gorm slow query",
+			append(queryAttrs(fc, elapsed), slog.Duration("slow_threshold", o.slowThreshold))...)
+	case o.level >= logger.Info:
+		o.log.InfoContext(ctx, "gorm query", queryAttrs(fc, elapsed)...)
+	}
+}
+
+func (o *GormDb[T]) log() *slog.Logger {
+	if o.config.Log == nil {
+		return slog.Default()
+	}
+
+	return o.config.Log
+}
+
+func (o *GormDb[T]) newLogger(dbType T) logger.Interface {
+	log := o.log()
+
+	level := o.config.LogLevel
+	if level == 0 {
+		level = logger.Warn
+	}
+
+	return NewSlogLogger(log.With(slog.String("db_type", fmt.Sprint(dbType))), level, o.slowQueryThreshold())
+}
+
+// 0 means not log nor record slow query
+func (o *GormDb[T]) slowQueryThreshold() time.Duration {
+	if o.config.SlowQueryThreshold == 0 {
+		return 200 * time.Millisecond
+	} else if o.config.SlowQueryThreshold < 0 {
+		return 0
+	}
+
+	return o.config.SlowQueryThreshold
+}
+
+// caller must hold o.mutex
+func (o *GormDb[T]) getSlowQueryLog(dbType T) *slowQueryLog {
+	slowQueries, ok := o.slowQueries[dbType]
+	if !ok {
+		slowQueries = &slowQueryLog{
+			threshold: o.slowQueryThreshold(),
+		}
+		o.slowQueries[dbType] = slowQueries
+	}
+
+	return slowQueries
+}
+
+// return recent slow queries of every connected db type, newest first
+func (o *GormDb[T]) SlowQueries() map[T][]*SlowQuery {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	result := make(map[T][]*SlowQuery, len(o.slowQueries))
+	for dbType, slowQueries := range o.slowQueries {
+		result[dbType] = slowQueries.snapshot()
+	}
+
+	return result
+}
@@ -0,0 +1,79 @@
+package gormdb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSlowQueryLog(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+		// Rows of the snapshot, newest first
+		wantFirst int64
+		wantLast  int64
+		wantLen   int
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:      "not full",
+			count:     3,
+			wantFirst: 2,
+			wantLast:  0,
+			wantLen:   3,
+		},
+		{
+			name:      "full",
+			count:     slowQueryLogSize,
+			wantFirst: slowQueryLogSize - 1,
+			wantLast:  0,
+			wantLen:   slowQueryLogSize,
+		},
+		{
+			name:      "wrap once",
+			count:     slowQueryLogSize + 1,
+			wantFirst: slowQueryLogSize,
+			wantLast:  1,
+			wantLen:   slowQueryLogSize,
+		},
+		{
+			name:      "wrap many",
+			count:     slowQueryLogSize*3 + 7,
+			wantFirst: slowQueryLogSize*3 + 6,
+			wantLast:  slowQueryLogSize*2 + 7,
+			wantLen:   slowQueryLogSize,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &slowQueryLog{}
+			for i := 0; i < tt.count; i++ {
+				log.add(&SlowQuery{Rows: int64(i), Sql: fmt.Sprint(i)})
+			}
+
+			snapshot := log.snapshot()
+			if len(snapshot) != tt.wantLen {
+				t.Fatalf("len:%d want:%d", len(snapshot), tt.wantLen)
+			}
+			for i := 1; i < len(snapshot); i++ {
+				if snapshot[i].Rows != snapshot[i-1].Rows-1 {
+					t.Fatalf("not newest first. index:%d rows:%d previous:%d", i, snapshot[i].Rows, snapshot[i-1].Rows)
+				}
+			}
+			if len(snapshot) == 0 {
+				return
+			}
+			if snapshot[0].Rows != tt.wantFirst || snapshot[len(snapshot)-1].Rows != tt.wantLast {
+				t.Fatalf("first:%d last:%d want first:%d last:%d", snapshot[0].Rows, snapshot[len(snapshot)-1].Rows, tt.wantFirst, tt.wantLast)
+			}
+
+			// a copy, so callers can not change the log
+			snapshot[0].Sql = "changed"
+			if log.snapshot()[0].Sql == "changed" {
+				t.Fatalf("snapshot shares queries with the log")
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package gormdb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm/logger"
+)
+
+func TestSlowQueriesWithoutValues(t *testing.T) {
+	gormDb := gormdbtest.New[repositoryDbType](t, &gormdb.Config{
+		LogLevel:           logger.Silent,
+		SlowQueryThreshold: time.Nanosecond,
+	}, 0)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		query func() error
+	}{
+		{
+			name: "query",
+			query: func() error {
+				return gormDb.FromCtx(ctx, 0).Where("owner_id = ?", 424242).Find(&[]*repositoryItem{}).Error
+			},
+		},
+		{
+			name: "create",
+			query: func() error {
+				return gormDb.FromCtx(ctx, 0).Create(&repositoryItem{OwnerId: 424242}).Error
+			},
+		},
+		{
+			name: "raw",
+			query: func() error {
+				return gormDb.FromCtx(ctx, 0).Exec("UPDATE repository_items SET level = ? WHERE owner_id = ?", 7, 424242).Error
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.query(); err != nil {
+				t.Fatalf("query fail. err:%v", err)
+			}
+
+			slowQueries := gormDb.SlowQueries()[0]
+			if len(slowQueries) == 0 {
+				t.Fatalf("slow query not recorded")
+			}
+			sql := slowQueries[0].Sql
+			if strings.Contains(sql, "424242") || !strings.Contains(sql, "?") {
+				t.Fatalf("sql must keep placeholders. sql:%s", sql)
+			}
+		})
+	}
+}
+
+func TestLogWithoutValues(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	gormDb := gormdbtest.New[repositoryDbType](t, &gormdb.Config{
+		Log:      slog.New(slog.NewJSONHandler(buffer, nil)),
+		LogLevel: logger.Info,
+	}, 0)
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		query    func() error
+		wantSql  string
+		wantVars int
+	}{
+		{
+			name: "query",
+			query: func() error {
+				return gormDb.FromCtx(ctx, 0).Where("owner_id = ?", 424242).Find(&[]*repositoryItem{}).Error
+			},
+			wantSql:  "SELECT * FROM `repository_items` WHERE owner_id = ?",
+			wantVars: 1,
+		},
+		{
+			name: "create",
+			query: func() error {
+				return gormDb.FromCtx(ctx, 0).Create(&repositoryItem{Id: 424242, OwnerId: 424242}).Error
+			},
+			wantSql:  "INSERT INTO `repository_items` (`owner_id`,`level`,`id`) VALUES (?,?,?) RETURNING `id`",
+			wantVars: 3,
+		},
+		{
+			name: "error",
+			query: func() error {
+				if err := gormDb.FromCtx(ctx, 0).Create(&repositoryItem{Id: 424242}).Error; err == nil {
+					t.Fatalf("want duplicate key error")
+				}
+				return nil
+			},
+			wantSql:  "INSERT INTO `repository_items` (`owner_id`,`level`,`id`) VALUES (?,?,?) RETURNING `id`",
+			wantVars: 3,
+		},
+		{
+			name: "raw scan",
+			query: func() error {
+				return gormDb.FromCtx(ctx, 0).Raw("SELECT * FROM repository_items WHERE owner_id = ?", 424242).Scan(&[]*repositoryItem{}).Error
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buffer.Reset()
+			if err := tt.query(); err != nil {
+				t.Fatalf("query fail. err:%v", err)
+			}
+
+			output := buffer.String()
+			if strings.Contains(output, "424242") {
+				t.Fatalf("log has bound values. log:%s", output)
+			}
+			lines := strings.Split(strings.TrimSpace(output), "\n")
+			record := struct {
+				Sql  string
+				Vars int
+			}{}
+			if err := json.Unmarshal([]byte(lines[len(lines)-1]), &record); err != nil {
+				t.Fatalf("unmarshal fail. err:%v log:%s", err, output)
+			}
+			if record.Sql != tt.wantSql || record.Vars != tt.wantVars {
+				t.Fatalf("sql:%s vars:%d want sql:%s vars:%d", record.Sql, record.Vars, tt.wantSql, tt.wantVars)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package gormdb
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+const metricsStartSettingKey = "jf:metrics_start"
+
+// upper bounds of latency histogram buckets, the last bucket is +Inf
+var MetricsLatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type QueryMetric struct {
+	Table string
+	// create, query, update, delete, row or raw
+	Operation     string
+	Count         int64
+	Errors        int64
+	TotalDuration time.Duration
+	// BucketCounts[i] is the count of latency <= MetricsLatencyBuckets[i],
+	// the last one is the count of latency > the last bucket
+	BucketCounts []int64
+}
+
+type queryMetricKey struct {
+	table     string
+	operation string
+}
+
+// a gorm plugin record count, errors and latency of statements
+type queryMetrics struct {
+	mutex   sync.Mutex
+	metrics map[queryMetricKey]*QueryMetric
+}
+
+func newQueryMetrics() *queryMetrics {
+	return &queryMetrics{
+		metrics: make(map[queryMetricKey]*QueryMetric),
+	}
+}
+
+// caller must hold o.mutex
+func (o *GormDb[T]) getQueryMetrics(dbType T) *queryMetrics {
+	metrics, ok := o.metrics[dbType]
+	if !ok {
+		metrics = newQueryMetrics()
+		o.metrics[dbType] = metrics
+	}
+
+	return metrics
+}
+
+func (o *queryMetrics) Name() string {
+	return "jf:metrics"
+}
+
+func (o *queryMetrics) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("*").Register("jf:metrics", o.before),
+		callback.Create().After("*").Register("jf:metrics_after", o.after("create")),
+		callback.Query().Before("*").Register("jf:metrics", o.before),
+		callback.Query().After("*").Register("jf:metrics_after", o.after("query")),
+		callback.Update().Before("*").Register("jf:metrics", o.before),
+		callback.Update().After("*").Register("jf:metrics_after", o.after("update")),
+		callback.Delete().Before("*").Register("jf:metrics", o.before),
+		callback.Delete().After("*").Register("jf:metrics_after", o.after("delete")),
+		callback.Row().Before("*").Register("jf:metrics", o.before),
+		callback.Row().After("*").Register("jf:metrics_after", o.after("row")),
+		callback.Raw().Before("*").Register("jf:metrics", o.before),
+		callback.Raw().After("*").Register("jf:metrics_after", o.after("raw")),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *queryMetrics) before(db *gorm.DB) {
+	db.Statement.Settings.Store(metricsStartSettingKey, time.Now())
+}
+
+func (o *queryMetrics) after(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.Statement.Settings.LoadAndDelete(metricsStartSettingKey)
+		if !ok {
+			return
+		}
+		o.record(db.Statement.Table, operation, time.Since(v.(time.Time)), db.Error)
+	}
+}
+
+func (o *queryMetrics) record(table string, operation string, elapsed time.Duration, err error) {
+	key := queryMetricKey{
+		table:     table,
+		operation: operation,
+	}
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	metric, ok := o.metrics[key]
+	if !ok {
+		metric = &QueryMetric{
+			Table:        key.table,
+			Operation:    key.operation,
+			BucketCounts: make([]int64, len(MetricsLatencyBuckets)+1),
+		}
+		o.metrics[key] = metric
+	}
+
+	metric.Count++
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		metric.Errors++
+	}
+	metric.TotalDuration += elapsed
+	metric.BucketCounts[sort.Search(len(MetricsLatencyBuckets), func(i int) bool {
+		return elapsed <= MetricsLatencyBuckets[i]
+	})]++
+}
+
+func (o *queryMetrics) snapshot() []*QueryMetric {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	result := make([]*QueryMetric, 0, len(o.metrics))
+	for _, metric := range o.metrics {
+		m := *metric
+		m.BucketCounts = append([]int64{}, metric.BucketCounts...)
+		result = append(result, &m)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Table != result[j].Table {
+			return result[i].Table < result[j].Table
+		}
+		return result[i].Operation < result[j].Operation
+	})
+
+	return result
+}
+
+// return query metrics of every connected db type, sorted by table and operation
+func (o *GormDb[T]) Metrics() map[T][]*QueryMetric {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	result := make(map[T][]*QueryMetric, len(o.metrics))
+	for dbType, metrics := range o.metrics {
+		result[dbType] = metrics.snapshot()
+	}
+
+	return result
+}
@@ -0,0 +1,282 @@
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+type MigrateFunc func(tx *gorm.DB) error
+
+// Version must be unique and greater than 0, migrations are applied in ascending Version order
+type Migration struct {
+	Version int64
+	Name    string
+	Up      MigrateFunc
+	Down    MigrateFunc
+}
+
+type DbTypeGetMigrations interface {
+	GetMigrations() []*Migration
+}
+
+// bookkeeping table of applied migrations
+type SchemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "jf_schema_migrations"
+}
+
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+	// applied in database but not found in T.GetMigrations()
+	Unknown bool
+}
+
+// return a MigrateFunc exec sqls in order
+func SqlMigrateFunc(sqls ...string) MigrateFunc {
+	return func(tx *gorm.DB) error {
+		for _, sql := range sqls {
+			if err := tx.Exec(sql).Error; err != nil {
+				return serr.Wrapf(err, "sql: %s", sql)
+			}
+		}
+		return nil
+	}
+}
+
+func getMigrations[T comparable](dbType T) ([]*Migration, error) {
+	t, ok := any(dbType).(DbTypeGetMigrations)
+	if !ok {
+		return nil, nil
+	}
+
+	migrations := append([]*Migration{}, t.GetMigrations()...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return nil, serr.Errorf("migration version must be greater than 0. name:%s", migration.Name)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return nil, serr.Errorf("migration version duplicate. version:%d", migration.Version)
+		}
+		if migration.Up == nil {
+			return nil, serr.Errorf("migration up is nil. version:%d", migration.Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// run fc holding the migrate lock of dbType, so only one process migrates at the same time
+func (o *GormDb[T]) withMigrateLock(ctx context.Context, db *gorm.DB, dbType T, fc func(conn *gorm.DB) error) error {
+	dialect := o.getDialect(dbType)
+	lockName := "jf_migrate"
+	if t, ok := any(dbType).(DbTypeGetDatabase); ok {
+		lockName = fmt.Sprintf("jf_migrate_%s", t.GetDatabase())
+	}
+
+	timeout := o.config.MigrateLockTimeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+
+	return db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		// a new session, so statements on conn not share conditions
+		conn = conn.Session(&gorm.Session{})
+		locked, err := dialect.tryLock(conn, lockName, timeout)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			return serr.Errorf("acquire migrate lock timeout. lock:%s", lockName)
+		}
+		defer dialect.unlock(conn, lockName)
+
+		if err := conn.AutoMigrate(&SchemaMigration{}); err != nil {
+			return serr.Wrap(err)
+		}
+
+		return fc(conn)
+	})
+}
+
+func getAppliedMigrations(db *gorm.DB) ([]*SchemaMigration, error) {
+	applied := []*SchemaMigration{}
+	if err := db.Order("version").Find(&applied).Error; err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return applied, nil
+}
+
+func (o *GormDb[T]) migrate(ctx context.Context, db *gorm.DB, dbType T) error {
+	migrations, err := getMigrations(dbType)
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+
+	return o.withMigrateLock(ctx, db, dbType, func(conn *gorm.DB) error {
+		applied, err := getAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+		appliedVersions := make(map[int64]struct{})
+		for _, v := range applied {
+			appliedVersions[v.Version] = struct{}{}
+		}
+
+		for _, migration := range migrations {
+			if _, ok := appliedVersions[migration.Version]; ok {
+				continue
+			}
+
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Up(tx); err != nil {
+					return err
+				}
+
+				return tx.Create(&SchemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			}); err != nil {
+				return serr.Wrapf(err, "migrate up fail. version:%d name:%s", migration.Version, migration.Name)
+			}
+		}
+
+		return nil
+	})
+}
+
+// apply all pending migrations of T.GetMigrations()
+func (o *GormDb[T]) Migrate(ctx context.Context, dbType T) error {
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return err
+	}
+
+	return o.migrate(ctx, db, dbType)
+}
+
+// rollback the last steps applied migrations in descending Version order
+func (o *GormDb[T]) Rollback(ctx context.Context, dbType T, steps int) error {
+	migrations, err := getMigrations(dbType)
+	if err != nil {
+		return err
+	}
+	migrationMap := make(map[int64]*Migration)
+	for _, migration := range migrations {
+		migrationMap[migration.Version] = migration
+	}
+
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return err
+	}
+
+	return o.withMigrateLock(ctx, db, dbType, func(conn *gorm.DB) error {
+		applied, err := getAppliedMigrations(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(applied) - 1; i >= 0 && steps > 0; i-- {
+			version := applied[i].Version
+			migration, ok := migrationMap[version]
+			if !ok {
+				return serr.Errorf("migration not found. version:%d", version)
+			}
+			if migration.Down == nil {
+				return serr.Errorf("migration down is nil. version:%d", version)
+			}
+
+			if err := conn.Transaction(func(tx *gorm.DB) error {
+				if err := migration.Down(tx); err != nil {
+					return err
+				}
+
+				return tx.Delete(&SchemaMigration{}, version).Error
+			}); err != nil {
+				return serr.Wrapf(err, "migrate down fail. version:%d name:%s", version, migration.Name)
+			}
+			steps--
+		}
+
+		return nil
+	})
+}
+
+// return all migrations of T.GetMigrations() and applied migrations in ascending Version order
+func (o *GormDb[T]) Status(ctx context.Context, dbType T) ([]*MigrationStatus, error) {
+	migrations, err := getMigrations(dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	return getMigrationStatus(db.WithContext(ctx), migrations)
+}
+
+func getMigrationStatus(db *gorm.DB, migrations []*Migration) ([]*MigrationStatus, error) {
+	var err error
+	applied := []*SchemaMigration{}
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		if applied, err = getAppliedMigrations(db); err != nil {
+			return nil, err
+		}
+	}
+
+	statusMap := make(map[int64]*MigrationStatus)
+	for _, migration := range migrations {
+		statusMap[migration.Version] = &MigrationStatus{
+			Version: migration.Version,
+			Name:    migration.Name,
+		}
+	}
+	for _, v := range applied {
+		status, ok := statusMap[v.Version]
+		if !ok {
+			status = &MigrationStatus{
+				Version: v.Version,
+				Name:    v.Name,
+				Unknown: true,
+			}
+			statusMap[v.Version] = status
+		}
+		status.Applied = true
+		status.AppliedAt = v.AppliedAt
+	}
+
+	result := make([]*MigrationStatus, 0, len(statusMap))
+	for _, status := range statusMap {
+		result = append(result, status)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+
+	return result, nil
+}
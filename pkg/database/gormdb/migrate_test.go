@@ -0,0 +1,162 @@
+package gormdb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+)
+
+type migrationDbType int
+
+// migrations of the running test
+var migrationTestMigrations []*gormdb.Migration
+
+func (migrationDbType) GetMigrations() []*gormdb.Migration {
+	return migrationTestMigrations
+}
+
+func newTestMigration(version int64, calls *[]string) *gormdb.Migration {
+	return &gormdb.Migration{
+		Version: version,
+		Name:    fmt.Sprintf("m%d", version),
+		Up: func(tx *gorm.DB) error {
+			*calls = append(*calls, fmt.Sprintf("up%d", version))
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			*calls = append(*calls, fmt.Sprintf("down%d", version))
+			return nil
+		},
+	}
+}
+
+func formatMigrationStatus(statuses []*gormdb.MigrationStatus) string {
+	s := ""
+	for _, status := range statuses {
+		s += fmt.Sprintf("%d:%v", status.Version, status.Applied)
+		if status.Unknown {
+			s += ":unknown"
+		}
+		s += " "
+	}
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	calls := []string{}
+	// not sorted on purpose
+	migrationTestMigrations = []*gormdb.Migration{
+		newTestMigration(3, &calls),
+		newTestMigration(1, &calls),
+		newTestMigration(2, &calls),
+	}
+	t.Cleanup(func() {
+		migrationTestMigrations = nil
+	})
+
+	gormDb := gormdbtest.New[migrationDbType](t, nil, 0)
+	ctx := context.Background()
+	if fmt.Sprint(calls) != "[up1 up2 up3]" {
+		t.Fatalf("migrations not applied in version order. calls:%v", calls)
+	}
+
+	steps := []struct {
+		name  string
+		run   func() error
+		calls string
+		want  string
+	}{
+		{
+			name:  "applied",
+			run:   func() error { return nil },
+			calls: "[]",
+			want:  "1:true 2:true 3:true ",
+		},
+		{
+			name:  "migrate again",
+			run:   func() error { return gormDb.Migrate(ctx, 0) },
+			calls: "[]",
+			want:  "1:true 2:true 3:true ",
+		},
+		{
+			name:  "rollback",
+			run:   func() error { return gormDb.Rollback(ctx, 0, 2) },
+			calls: "[down3 down2]",
+			want:  "1:true 2:false 3:false ",
+		},
+		{
+			name: "unknown applied migration",
+			run: func() error {
+				return gormDb.FromCtx(ctx, 0).Create(&gormdb.SchemaMigration{Version: 9, Name: "m9", AppliedAt: time.Now()}).Error
+			},
+			calls: "[]",
+			want:  "1:true 2:false 3:false 9:true:unknown ",
+		},
+		{
+			name:  "pending",
+			run:   func() error { return gormDb.Migrate(ctx, 0) },
+			calls: "[up2 up3]",
+			want:  "1:true 2:true 3:true 9:true:unknown ",
+		},
+	}
+	for _, step := range steps {
+		calls = calls[:0]
+		if err := step.run(); err != nil {
+			t.Fatalf("%s fail. err:%v", step.name, err)
+		}
+		if fmt.Sprint(calls) != step.calls {
+			t.Fatalf("%s calls:%v want:%s", step.name, calls, step.calls)
+		}
+
+		statuses, err := gormDb.Status(ctx, 0)
+		if err != nil {
+			t.Fatalf("%s status fail. err:%v", step.name, err)
+		}
+		if got := formatMigrationStatus(statuses); got != step.want {
+			t.Fatalf("%s status got:%s want:%s", step.name, got, step.want)
+		}
+	}
+}
+
+func TestMigrateInvalid(t *testing.T) {
+	calls := []string{}
+	tests := []struct {
+		name       string
+		migrations []*gormdb.Migration
+	}{
+		{
+			name:       "zero version",
+			migrations: []*gormdb.Migration{newTestMigration(0, &calls)},
+		},
+		{
+			name:       "duplicate version",
+			migrations: []*gormdb.Migration{newTestMigration(1, &calls), newTestMigration(2, &calls), newTestMigration(1, &calls)},
+		},
+		{
+			name:       "no up",
+			migrations: []*gormdb.Migration{{Version: 1, Name: "m1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrationTestMigrations = nil
+			gormDb := gormdbtest.New[migrationDbType](t, nil, 0)
+
+			migrationTestMigrations = tt.migrations
+			t.Cleanup(func() {
+				migrationTestMigrations = nil
+			})
+			if err := gormDb.Migrate(context.Background(), 0); err == nil {
+				t.Fatalf("want error")
+			}
+			if len(calls) != 0 {
+				t.Fatalf("migration applied. calls:%v", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLockTimeout = errors.New("lock timeout")
+	ErrLockLost    = errors.New("lock lost")
+)
+
+type MutexConfig struct {
+	// check the lock is still held every interval, default is 5 seconds
+	RenewInterval time.Duration
+}
+
+// a cluster-wide mutex by advisory lock of the database, GET_LOCK of mysql or pg_advisory_lock of postgresql.
+// the lock belongs to a connection, so it is released when the process or the connection dies
+//
+// name of mysql is at most 64 characters. sqlite always acquires the lock, only for tests
+type Mutex[T comparable] struct {
+	gormDb *GormDb[T]
+	dbType T
+	name   string
+	config MutexConfig
+}
+
+// config may be nil
+func (o *GormDb[T]) NewMutex(dbType T, name string, config *MutexConfig) *Mutex[T] {
+	c := MutexConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.RenewInterval <= 0 {
+		c.RenewInterval = 5 * time.Second
+	}
+
+	return &Mutex[T]{
+		gormDb: o,
+		dbType: dbType,
+		name:   name,
+		config: c,
+	}
+}
+
+// a held lock, Unlock it when done
+type Lease struct {
+	dialect  Dialect
+	name     string
+	conn     *gorm.DB
+	log      *slog.Logger
+	mutex    sync.Mutex
+	lost     chan struct{}
+	done     chan struct{}
+	closed   bool
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+	close    func() error
+}
+
+// acquire the lock, wait at most timeout or until ctx done. return ErrLockTimeout if not acquired
+func (o *Mutex[T]) Lock(ctx context.Context, timeout time.Duration) (*Lease, error) {
+	db, err := o.gormDb.getDb(ctx, o.dbType)
+	if err != nil {
+		return nil, err
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	// the lock belongs to this connection until Unlock
+	sqlConn, err := sqlDb.Conn(ctx)
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+	conn := db.Session(&gorm.Session{NewDB: true, Context: context.Background()})
+	conn.Statement.ConnPool = sqlConn
+
+	dialect := o.gormDb.getDialect(o.dbType)
+	locked, err := dialect.tryLock(conn.WithContext(ctx), o.name, timeout)
+	if err != nil {
+		sqlConn.Close()
+		return nil, err
+	}
+	if !locked {
+		sqlConn.Close()
+		return nil, ErrLockTimeout
+	}
+
+	lease := &Lease{
+		dialect: dialect,
+		name:    o.name,
+		conn:    conn,
+		log:     o.gormDb.log(),
+		lost:    make(chan struct{}),
+		done:    make(chan struct{}),
+		close:   sqlConn.Close,
+	}
+	lease.wg.Add(1)
+	go lease.renew(o.config.RenewInterval)
+
+	return lease, nil
+}
+
+// run fc holding the lock, ctx of fc is canceled if the lock is lost
+func (o *Mutex[T]) Do(ctx context.Context, timeout time.Duration, fc func(ctx context.Context) error) error {
+	lease, err := o.Lock(ctx, timeout)
+	if err != nil {
+		return err
+	}
+
+	leaseCtx, cancel := lease.Context(ctx)
+	defer cancel()
+
+	fcErr := fc(leaseCtx)
+	unlockErr := lease.Unlock(context.Background())
+	if fcErr == nil && unlockErr == nil {
+		select {
+		case <-lease.Lost():
+			// fc may finish after the lock is lost
+			return ErrLockLost
+		default:
+		}
+	}
+
+	return errors.Join(fcErr, unlockErr)
+}
+
+func (o *Lease) renew(interval time.Duration) {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			locked, err := o.dialect.isLocked(o.conn.WithContext(ctx), o.name)
+			cancel()
+			if err == nil && locked {
+				continue
+			}
+
+			attrs := []interface{}{slog.String("name", o.name)}
+			if err != nil {
+				attrs = append(attrs, slog.Any("err", serr.ToJSON(err, true)))
+			}
+			o.log.Warn("gormdb lock lost", attrs...)
+			o.release(context.Background(), false)
+			return
+		}
+	}
+}
+
+// close lost if not unlock, then close the connection, the database releases the lock with it
+func (o *Lease) release(ctx context.Context, unlock bool) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	errs := []error{}
+	if unlock {
+		errs = append(errs, o.dialect.unlock(o.conn.WithContext(ctx), o.name))
+	} else {
+		close(o.lost)
+	}
+	if err := o.close(); err != nil {
+		errs = append(errs, serr.Wrap(err))
+	}
+
+	return errors.Join(errs...)
+}
+
+// closed when the lock is lost, e.g. the connection dropped. not closed by Unlock
+func (o *Lease) Lost() <-chan struct{} {
+	return o.lost
+}
+
+// a ctx of parent canceled when the lock is lost
+func (o *Lease) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-o.lost:
+			cancel(ErrLockLost)
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		cancel(context.Canceled)
+	}
+}
+
+// release the lock, the connection is closed even if ctx done
+func (o *Lease) Unlock(ctx context.Context) error {
+	o.stopOnce.Do(func() {
+		close(o.done)
+	})
+	o.wg.Wait()
+
+	return o.release(ctx, true)
+}
@@ -0,0 +1,194 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestTryLock(t *testing.T) {
+	tests := []struct {
+		name     string
+		dialect  Dialect
+		timeout  time.Duration
+		canceled bool
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:    "mysql",
+			dialect: DialectMysql,
+			timeout: 2 * time.Second,
+			want:    "[SELECT GET_LOCK(?, ?) [jf 2]]",
+		},
+		{
+			name:    "postgres",
+			dialect: DialectPostgresql,
+			want:    fmt.Sprintf("[SELECT pg_try_advisory_lock($1) [%d]]", advisoryLockKey("jf")),
+		},
+		{
+			name:     "postgres canceled while polling",
+			dialect:  DialectPostgresql,
+			timeout:  time.Hour,
+			canceled: true,
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sqlDb, err := sql.Open("gormdb_record", "")
+			if err != nil {
+				t.Fatalf("open fail. err:%v", err)
+			}
+			defer sqlDb.Close()
+
+			var dialector gorm.Dialector
+			if tt.dialect == DialectPostgresql {
+				dialector = postgres.New(postgres.Config{Conn: sqlDb})
+			} else {
+				dialector = mysql.New(mysql.Config{Conn: sqlDb, SkipInitializeWithVersion: true})
+			}
+			db, err := gorm.Open(dialector, &gorm.Config{DisableAutomaticPing: true})
+			if err != nil {
+				t.Fatalf("gorm open fail. err:%v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.canceled {
+				// canceled after the first poll
+				time.AfterFunc(10*time.Millisecond, cancel)
+			}
+			defer cancel()
+
+			recorder.mutex.Lock()
+			recorder.queries = nil
+			recorder.mutex.Unlock()
+			// every query of recordDriver returns 0, the lock is held by others
+			locked, err := tt.dialect.tryLock(db.WithContext(ctx), "jf", tt.timeout)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+			if locked {
+				t.Fatalf("locked")
+			}
+			if err != nil {
+				return
+			}
+
+			recorder.mutex.Lock()
+			defer recorder.mutex.Unlock()
+			if got := fmt.Sprint(recorder.queries); got != tt.want {
+				t.Fatalf("got:%s want:%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMutexDo(t *testing.T) {
+	errFc := errors.New("fc fail")
+	tests := []struct {
+		name    string
+		fcErr   error
+		wantErr error
+	}{
+		{
+			name: "success",
+		},
+		{
+			name:    "fc fail",
+			fcErr:   errFc,
+			wantErr: errFc,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newHealthTestDb(t, Config{}, t.TempDir())
+			mutex := gormDb.NewMutex(1, "jf", nil)
+
+			called := false
+			err := mutex.Do(context.Background(), time.Second, func(ctx context.Context) error {
+				called = true
+				return tt.fcErr
+			})
+			if !called {
+				t.Fatalf("fc not called")
+			}
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("err:%v want:%v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLeaseRenew(t *testing.T) {
+	gormDb := newHealthTestDb(t, Config{}, t.TempDir())
+	mutex := gormDb.NewMutex(1, "jf", &MutexConfig{RenewInterval: time.Millisecond})
+
+	lease, err := mutex.Lock(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("lock fail. err:%v", err)
+	}
+	ctx, cancel := lease.Context(context.Background())
+	defer cancel()
+
+	// renewed while the connection is alive
+	time.Sleep(20 * time.Millisecond)
+	select {
+	case <-lease.Lost():
+		t.Fatalf("lock lost while the connection is alive")
+	default:
+	}
+
+	// the connection dropped
+	if err := lease.conn.Statement.ConnPool.(*sql.Conn).Close(); err != nil {
+		t.Fatalf("close fail. err:%v", err)
+	}
+	select {
+	case <-lease.Lost():
+	case <-time.After(time.Second):
+		t.Fatalf("lost not closed after the connection dropped")
+	}
+	<-ctx.Done()
+	if cause := context.Cause(ctx); !errors.Is(cause, ErrLockLost) {
+		t.Fatalf("cause:%v want:%v", cause, ErrLockLost)
+	}
+
+	if err := lease.Unlock(context.Background()); err != nil {
+		t.Fatalf("unlock a lost lease fail. err:%v", err)
+	}
+}
+
+func TestLeaseUnlock(t *testing.T) {
+	gormDb := newHealthTestDb(t, Config{}, t.TempDir())
+	mutex := gormDb.NewMutex(1, "jf", &MutexConfig{RenewInterval: time.Millisecond})
+
+	lease, err := mutex.Lock(context.Background(), time.Second)
+	if err != nil {
+		t.Fatalf("lock fail. err:%v", err)
+	}
+	if err := lease.Unlock(context.Background()); err != nil {
+		t.Fatalf("unlock fail. err:%v", err)
+	}
+	if err := lease.Unlock(context.Background()); err != nil {
+		t.Fatalf("unlock twice fail. err:%v", err)
+	}
+
+	// Unlock does not close lost
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case <-lease.Lost():
+		t.Fatalf("lost closed by unlock")
+	default:
+	}
+	// the connection is returned
+	if _, err := lease.conn.Statement.ConnPool.(*sql.Conn).ExecContext(context.Background(), "SELECT 1"); !errors.Is(err, sql.ErrConnDone) {
+		t.Fatalf("connection of lease not closed. err:%v", err)
+	}
+}
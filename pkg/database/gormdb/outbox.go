@@ -0,0 +1,268 @@
+package gormdb
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/helper"
+	"github.com/MinamiKotoriCute/jf/pkg/trigger"
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// a message appended in a transaction, delivered by the relay after the transaction committed
+type OutboxMessage struct {
+	Id      int64  `gorm:"primaryKey"`
+	Topic   string `gorm:"size:191;index"`
+	Payload []byte
+	// attempts of publishing, include the successful one
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	CreatedAt     time.Time
+	SentAt        *time.Time `gorm:"index"`
+	// not published after OutboxConfig.MaxAttempts
+	FailedAt *time.Time
+}
+
+func (OutboxMessage) TableName() string {
+	return "jf_outbox_messages"
+}
+
+type OutboxConfig struct {
+	// default is 1 second
+	PollInterval time.Duration
+	// messages read each poll, default is 100
+	BatchSize int
+	// backoff before the first retry of a message, doubled each retry, default is 1 second
+	RetryBackoff time.Duration
+	// default is 5 minutes
+	RetryMaxBackoff time.Duration
+	// a message failed this many times is marked failed and skipped, 0 means retry forever
+	MaxAttempts int
+	// default is 10 seconds
+	PublishTimeout time.Duration
+}
+
+// messages appended to the outbox table of a db type, and the relay publishing them
+//
+// messages of the same topic are published in append order, a failed message blocks later
+// messages of its topic until it is published or marked failed
+type Outbox[T comparable] struct {
+	gormDb    *GormDb[T]
+	dbType    T
+	publisher OutboxPublisher
+	config    OutboxConfig
+	trigger   *trigger.Trigger
+}
+
+var _ helper.Service = (*Outbox[int])(nil)
+
+// config may be nil
+func NewOutbox[T comparable](gormDb *GormDb[T], dbType T, publisher OutboxPublisher, config *OutboxConfig) *Outbox[T] {
+	c := OutboxConfig{}
+	if config != nil {
+		c = *config
+	}
+	if c.PollInterval <= 0 {
+		c.PollInterval = time.Second
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = time.Second
+	}
+	if c.RetryMaxBackoff <= 0 {
+		c.RetryMaxBackoff = 5 * time.Minute
+	}
+	if c.PublishTimeout <= 0 {
+		c.PublishTimeout = 10 * time.Second
+	}
+
+	return &Outbox[T]{
+		gormDb:    gormDb,
+		dbType:    dbType,
+		publisher: publisher,
+		config:    c,
+	}
+}
+
+// append a message in the transaction of WithTx in ctx,
+// so the message is only published if the transaction is committed
+func (o *Outbox[T]) Append(ctx context.Context, topic string, payload []byte) error {
+	tx, ok := o.gormDb.getTx(ctx, o.dbType)
+	if !ok {
+		return serr.Errorf("outbox append must be in a transaction. db type:%v", o.dbType)
+	}
+
+	message := &OutboxMessage{
+		Topic:         topic,
+		Payload:       payload,
+		NextAttemptAt: time.Now(),
+	}
+	if err := tx.WithContext(ctx).Create(message).Error; err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+// create the outbox table if not exist, and start the relay
+func (o *Outbox[T]) Start(ctx context.Context) error {
+	db, err := o.gormDb.getDb(ctx, o.dbType)
+	if err != nil {
+		return err
+	}
+	if err := db.WithContext(ForcePrimary(ctx)).AutoMigrate(&OutboxMessage{}); err != nil {
+		return serr.Wrap(err)
+	}
+
+	if o.trigger == nil {
+		// stopping the trigger cancels a running publish
+		o.trigger = trigger.NewContextTrigger(o.config.PollInterval, fmt.Sprintf("gormdb outbox %v", o.dbType), func(ctx context.Context) error {
+			return o.Relay(ctx)
+		})
+		if err := o.trigger.Start(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stop the relay, cancel and wait for the running poll
+func (o *Outbox[T]) Stop(ctx context.Context) error {
+	if o.trigger != nil {
+		o.trigger.Stop()
+		o.trigger = nil
+	}
+	return nil
+}
+
+// publish due messages once, the relay calls it every OutboxConfig.PollInterval
+//
+// only one process relays at the same time, others skip this poll
+func (o *Outbox[T]) Relay(ctx context.Context) error {
+	db, err := o.gormDb.getDb(ctx, o.dbType)
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ForcePrimary(ctx))
+	dialect := o.gormDb.getDialect(o.dbType)
+	name := fmt.Sprintf("jf_outbox:%s", OutboxMessage{}.TableName())
+
+	return db.Connection(func(conn *gorm.DB) error {
+		// a new session, so statements on conn not share conditions
+		conn = conn.Session(&gorm.Session{})
+		locked, err := dialect.tryLock(conn, name, 0)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			return nil
+		}
+		// still unlock after ctx is canceled, conn goes back to the pool with the lock otherwise
+		defer dialect.unlock(conn.WithContext(context.WithoutCancel(ctx)), name)
+
+		return o.relay(ctx, conn)
+	})
+}
+
+func (o *Outbox[T]) relay(ctx context.Context, db *gorm.DB) error {
+	for {
+		count, blocked, err := o.relayBatch(ctx, db)
+		if err != nil {
+			return err
+		}
+		// rows of topics blocked in this batch may fill it, read again without them
+		if count < o.config.BatchSize || !blocked {
+			return nil
+		}
+	}
+}
+
+// publish pending messages of topics whose first pending message is due,
+// return the count of messages read and whether a topic is blocked by a failure
+func (o *Outbox[T]) relayBatch(ctx context.Context, db *gorm.DB) (int, bool, error) {
+	table := OutboxMessage{}.TableName()
+	pending := "sent_at IS NULL AND failed_at IS NULL"
+	// a topic blocked by a message in backoff is skipped by the database,
+	// so it can not fill the batch and starve other topics
+	dueTopics := db.Table(table).Select("topic").Where("id IN (?) AND next_attempt_at <= ?",
+		db.Table(table).Select("MIN(id)").Where(pending).Group("topic"),
+		time.Now())
+
+	messages := []*OutboxMessage{}
+	if err := db.Where(pending).
+		Where("topic IN (?)", dueTopics).
+		Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}}).
+		Limit(o.config.BatchSize).
+		Find(&messages).Error; err != nil {
+		return 0, false, serr.Wrap(err)
+	}
+
+	blocked := false
+	blockedTopics := map[string]struct{}{}
+	for _, message := range messages {
+		if _, ok := blockedTopics[message.Topic]; ok {
+			continue
+		}
+
+		if err := o.publish(ctx, db, message); err != nil {
+			return 0, false, err
+		}
+		if message.SentAt == nil && message.FailedAt == nil {
+			blockedTopics[message.Topic] = struct{}{}
+			blocked = true
+		}
+	}
+
+	return len(messages), blocked, nil
+}
+
+// return error only if the result can not be saved or ctx is canceled
+func (o *Outbox[T]) publish(ctx context.Context, db *gorm.DB, message *OutboxMessage) error {
+	publishCtx, cancel := context.WithTimeout(ctx, o.config.PublishTimeout)
+	publishErr := o.publisher.Publish(publishCtx, message)
+	cancel()
+	// canceled by the caller is not an attempt of the message
+	if publishErr != nil && ctx.Err() != nil {
+		return serr.Wrap(ctx.Err())
+	}
+
+	now := time.Now()
+	message.Attempts++
+	updates := map[string]interface{}{
+		"attempts": message.Attempts,
+	}
+	if publishErr == nil {
+		message.SentAt = &now
+		updates["sent_at"] = now
+		updates["last_error"] = ""
+	} else {
+		message.LastError = publishErr.Error()
+		updates["last_error"] = message.LastError
+		if o.config.MaxAttempts > 0 && message.Attempts >= o.config.MaxAttempts {
+			message.FailedAt = &now
+			updates["failed_at"] = now
+		} else {
+			message.NextAttemptAt = now.Add(backoffWithJitter(o.config.RetryBackoff, message.Attempts-1, o.config.RetryMaxBackoff))
+			updates["next_attempt_at"] = message.NextAttemptAt
+		}
+
+		o.gormDb.log().WarnContext(ctx, "gormdb outbox publish fail",
+			slog.Any("err", serr.ToJSON(publishErr, true)),
+			slog.Int64("id", message.Id),
+			slog.String("topic", message.Topic),
+			slog.Int("attempts", message.Attempts),
+			slog.Bool("failed", message.FailedAt != nil))
+	}
+
+	if err := db.Model(message).Updates(updates).Error; err != nil {
+		return serr.Wrapf(err, "id:%d", message.Id)
+	}
+	return nil
+}
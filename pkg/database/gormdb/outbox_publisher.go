@@ -0,0 +1,148 @@
+package gormdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+// return nil only if the message is delivered, a message may be published more than once,
+// so the receiver should dedup by OutboxMessage.Id
+type OutboxPublisher interface {
+	Publish(ctx context.Context, message *OutboxMessage) error
+}
+
+type OutboxPublisherFunc func(ctx context.Context, message *OutboxMessage) error
+
+func (f OutboxPublisherFunc) Publish(ctx context.Context, message *OutboxMessage) error {
+	return f(ctx, message)
+}
+
+// POST the payload to Url, with the message id and topic in headers. 2xx means delivered
+type HttpOutboxPublisher struct {
+	Url string
+	// default is application/octet-stream
+	ContentType string
+	// default is http.DefaultClient
+	Client *http.Client
+}
+
+func (o *HttpOutboxPublisher) Publish(ctx context.Context, message *OutboxMessage) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.Url, bytes.NewReader(message.Payload))
+	if err != nil {
+		return serr.Wrap(err)
+	}
+
+	contentType := o.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Outbox-Id", strconv.FormatInt(message.Id, 10))
+	req.Header.Set("X-Outbox-Topic", message.Topic)
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return serr.Wrap(err)
+	}
+	defer rsp.Body.Close()
+	io.Copy(io.Discard, rsp.Body)
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return serr.Errorf("http status:%d url:%s", rsp.StatusCode, o.Url)
+	}
+	return nil
+}
+
+// send the payload as a packet of tcpserver to Address, the peer must reply a packet as the ack
+//
+// the connection is kept and reconnected on error
+type TcpOutboxPublisher struct {
+	Address string
+	// default is 1MB, same as tcpserver
+	PacketSizeLimit uint64
+	mutex           sync.Mutex
+	conn            net.Conn
+}
+
+func (o *TcpOutboxPublisher) Publish(ctx context.Context, message *OutboxMessage) error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		dialer := net.Dialer{}
+		conn, err := dialer.DialContext(ctx, "tcp", o.Address)
+		if err != nil {
+			return serr.Wrap(err)
+		}
+		o.conn = conn
+	}
+
+	if err := o.send(ctx, message.Payload); err != nil {
+		o.conn.Close()
+		o.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (o *TcpOutboxPublisher) send(ctx context.Context, payload []byte) error {
+	// zero deadline if ctx has no deadline
+	deadline, _ := ctx.Deadline()
+	if err := o.conn.SetDeadline(deadline); err != nil {
+		return serr.Wrap(err)
+	}
+
+	// packet format of tcpserver: 8 bytes big endian size, then data
+	packet := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint64(packet, uint64(len(payload)))
+	packet = append(packet, payload...)
+	if _, err := o.conn.Write(packet); err != nil {
+		return serr.Wrap(err)
+	}
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(o.conn, header); err != nil {
+		return serr.Wrap(err)
+	}
+	size := binary.BigEndian.Uint64(header)
+	limit := o.PacketSizeLimit
+	if limit == 0 {
+		limit = 1024 * 1024
+	}
+	if size > limit {
+		return serr.Errorf("packet size too large. size=%d", size)
+	}
+	if _, err := io.CopyN(io.Discard, o.conn, int64(size)); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+// close the kept connection
+func (o *TcpOutboxPublisher) Close() error {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	if err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
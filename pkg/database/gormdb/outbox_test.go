@@ -0,0 +1,301 @@
+package gormdb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm/logger"
+)
+
+type outboxDbType int
+
+func TestOutboxPoisonedTopic(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+	}{
+		{
+			name: "retry forever",
+		},
+		{
+			name:        "max attempts",
+			maxAttempts: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[outboxDbType](t, nil, 0)
+			published := map[string]int{}
+			publisher := gormdb.OutboxPublisherFunc(func(ctx context.Context, message *gormdb.OutboxMessage) error {
+				if message.Topic == "poisoned" {
+					return errors.New("poisoned")
+				}
+				published[message.Topic]++
+				return nil
+			})
+			outbox := gormdb.NewOutbox(gormDb, 0, publisher, &gormdb.OutboxConfig{
+				PollInterval: time.Hour,
+				BatchSize:    5,
+				MaxAttempts:  tt.maxAttempts,
+			})
+			ctx := context.Background()
+			if err := outbox.Start(ctx); err != nil {
+				t.Fatalf("start fail. err:%v", err)
+			}
+			t.Cleanup(func() {
+				outbox.Stop(ctx)
+			})
+
+			// the poisoned topic alone fills more than a batch before the healthy one
+			err := gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+				for i := 0; i < 12; i++ {
+					if err := outbox.Append(ctx, "poisoned", nil); err != nil {
+						return err
+					}
+				}
+				for i := 0; i < 3; i++ {
+					if err := outbox.Append(ctx, "healthy", nil); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("append fail. err:%v", err)
+			}
+
+			for i := 0; i < 2; i++ {
+				if err := outbox.Relay(ctx); err != nil {
+					t.Fatalf("relay fail. err:%v", err)
+				}
+			}
+			if published["healthy"] != 3 {
+				t.Fatalf("healthy topic starved. published:%d", published["healthy"])
+			}
+
+			var pending int64
+			if err := gormDb.FromCtx(ctx, 0).Model(&gormdb.OutboxMessage{}).
+				Where("topic = ? AND sent_at IS NULL", "poisoned").
+				Count(&pending).Error; err != nil {
+				t.Fatalf("count fail. err:%v", err)
+			}
+			if pending != 12 {
+				t.Fatalf("poisoned messages must not be sent. pending:%d", pending)
+			}
+		})
+	}
+}
+
+func newOutboxTestDb(t *testing.T, publisher gormdb.OutboxPublisher, config *gormdb.OutboxConfig) (*gormdb.GormDb[outboxDbType], *gormdb.Outbox[outboxDbType]) {
+	gormDb := gormdbtest.New[outboxDbType](t, &gormdb.Config{
+		Log:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+		LogLevel: logger.Silent,
+	}, 0)
+	outbox := gormdb.NewOutbox(gormDb, 0, publisher, config)
+	if err := outbox.Start(context.Background()); err != nil {
+		t.Fatalf("start fail. err:%v", err)
+	}
+	t.Cleanup(func() {
+		outbox.Stop(context.Background())
+	})
+	return gormDb, outbox
+}
+
+func appendOutbox(t *testing.T, gormDb *gormdb.GormDb[outboxDbType], outbox *gormdb.Outbox[outboxDbType], topics ...string) {
+	err := gormDb.WithTx(context.Background(), 0, func(ctx context.Context) error {
+		for i, topic := range topics {
+			if err := outbox.Append(ctx, topic, []byte(strconv.Itoa(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("append fail. err:%v", err)
+	}
+}
+
+func getOutboxMessages(t *testing.T, gormDb *gormdb.GormDb[outboxDbType]) []*gormdb.OutboxMessage {
+	messages := []*gormdb.OutboxMessage{}
+	if err := gormDb.GetDb(0).Order("id").Find(&messages).Error; err != nil {
+		t.Fatalf("find fail. err:%v", err)
+	}
+	return messages
+}
+
+func TestOutboxOrder(t *testing.T) {
+	published := []string{}
+	publisher := gormdb.OutboxPublisherFunc(func(ctx context.Context, message *gormdb.OutboxMessage) error {
+		published = append(published, fmt.Sprintf("%s:%s", message.Topic, message.Payload))
+		return nil
+	})
+	gormDb, outbox := newOutboxTestDb(t, publisher, &gormdb.OutboxConfig{PollInterval: time.Hour, BatchSize: 2})
+	appendOutbox(t, gormDb, outbox, "a", "b", "a", "b", "a")
+
+	// a rolled back transaction appends nothing
+	err := gormDb.WithTx(context.Background(), 0, func(ctx context.Context) error {
+		if err := outbox.Append(ctx, "a", nil); err != nil {
+			return err
+		}
+		return errTxTest
+	})
+	if !errors.Is(err, errTxTest) {
+		t.Fatalf("err:%v want:%v", err, errTxTest)
+	}
+	if err := outbox.Append(context.Background(), "a", nil); err == nil {
+		t.Fatalf("append out of transaction must fail")
+	}
+
+	// a relay publishes a batch
+	for i := 0; i < 3; i++ {
+		if err := outbox.Relay(context.Background()); err != nil {
+			t.Fatalf("relay fail. err:%v", err)
+		}
+	}
+	want := "[a:0 b:1 a:2 b:3 a:4]"
+	if fmt.Sprint(published) != want {
+		t.Fatalf("published:%v want:%s", published, want)
+	}
+	for _, message := range getOutboxMessages(t, gormDb) {
+		if message.SentAt == nil || message.Attempts != 1 {
+			t.Fatalf("message:%+v", message)
+		}
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxAttempts int
+		backoff     time.Duration
+		// the first message of topic a fails this many times
+		failures     int
+		relays       int
+		wantAttempts int
+		wantSent     bool
+		wantFailed   bool
+		wantNext     bool
+	}{
+		{
+			name:         "retry until published",
+			backoff:      time.Millisecond,
+			failures:     2,
+			relays:       3,
+			wantAttempts: 3,
+			wantSent:     true,
+			wantNext:     true,
+		},
+		{
+			name:         "retrying",
+			backoff:      time.Millisecond,
+			failures:     5,
+			relays:       2,
+			wantAttempts: 2,
+		},
+		{
+			name:         "skipped in backoff",
+			backoff:      time.Hour,
+			failures:     5,
+			relays:       2,
+			wantAttempts: 1,
+		},
+		{
+			name:         "failed after max attempts",
+			maxAttempts:  2,
+			backoff:      time.Millisecond,
+			failures:     5,
+			relays:       3,
+			wantAttempts: 2,
+			wantFailed:   true,
+			wantNext:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failures := 0
+			publisher := gormdb.OutboxPublisherFunc(func(ctx context.Context, message *gormdb.OutboxMessage) error {
+				if string(message.Payload) == "0" && failures < tt.failures {
+					failures++
+					return errors.New("publish fail")
+				}
+				return nil
+			})
+			gormDb, outbox := newOutboxTestDb(t, publisher, &gormdb.OutboxConfig{
+				PollInterval:    time.Hour,
+				RetryBackoff:    tt.backoff,
+				RetryMaxBackoff: tt.backoff,
+				MaxAttempts:     tt.maxAttempts,
+			})
+			appendOutbox(t, gormDb, outbox, "a", "a", "b")
+
+			for i := 0; i < tt.relays; i++ {
+				if err := outbox.Relay(context.Background()); err != nil {
+					t.Fatalf("relay fail. err:%v", err)
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			messages := getOutboxMessages(t, gormDb)
+			first := messages[0]
+			if first.Attempts != tt.wantAttempts || (first.SentAt != nil) != tt.wantSent || (first.FailedAt != nil) != tt.wantFailed {
+				t.Fatalf("first message:%+v", first)
+			}
+			if tt.wantSent != (first.LastError == "") {
+				t.Fatalf("last error:%s", first.LastError)
+			}
+			// later messages of the topic wait for the first one
+			if (messages[1].SentAt != nil) != tt.wantNext {
+				t.Fatalf("next message of topic:%+v", messages[1])
+			}
+			// other topics are not blocked
+			if messages[2].SentAt == nil {
+				t.Fatalf("message of other topic not published")
+			}
+		})
+	}
+}
+
+func TestOutboxStopCancelsPublish(t *testing.T) {
+	publishing := make(chan struct{}, 1)
+	publishErr := make(chan error, 1)
+	publisher := gormdb.OutboxPublisherFunc(func(ctx context.Context, message *gormdb.OutboxMessage) error {
+		publishing <- struct{}{}
+		<-ctx.Done()
+		publishErr <- ctx.Err()
+		return ctx.Err()
+	})
+	gormDb, outbox := newOutboxTestDb(t, publisher, &gormdb.OutboxConfig{
+		PollInterval:   time.Millisecond,
+		PublishTimeout: time.Hour,
+	})
+	appendOutbox(t, gormDb, outbox, "a")
+
+	<-publishing
+	stopped := make(chan struct{})
+	go func() {
+		outbox.Stop(context.Background())
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("stop blocked by a running publish")
+	}
+	if err := <-publishErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("publish ctx err:%v", err)
+	}
+
+	// canceled is not an attempt
+	message := getOutboxMessages(t, gormDb)[0]
+	if message.Attempts != 0 || message.SentAt != nil || message.LastError != "" {
+		t.Fatalf("message:%+v", message)
+	}
+}
@@ -0,0 +1,76 @@
+package gormdb
+
+import (
+	"database/sql"
+	"time"
+)
+
+// zero value fields keep the database/sql default
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// override Config.Pool of the db type, include its replicas
+type DbTypeGetPoolConfig interface {
+	GetPoolConfig(config *Config) *PoolConfig
+}
+
+type PoolStats struct {
+	Primary sql.DBStats
+	// same order as DbTypeGetReplicaDsns.GetReplicaDsns
+	Replicas []sql.DBStats
+}
+
+func (o *PoolConfig) apply(db *sql.DB) {
+	if o.MaxOpenConns != 0 {
+		db.SetMaxOpenConns(o.MaxOpenConns)
+	}
+	if o.MaxIdleConns != 0 {
+		db.SetMaxIdleConns(o.MaxIdleConns)
+	}
+	if o.ConnMaxLifetime != 0 {
+		db.SetConnMaxLifetime(o.ConnMaxLifetime)
+	}
+	if o.ConnMaxIdleTime != 0 {
+		db.SetConnMaxIdleTime(o.ConnMaxIdleTime)
+	}
+}
+
+func (o *GormDb[T]) getPoolConfig(dbType T) *PoolConfig {
+	if t, ok := any(dbType).(DbTypeGetPoolConfig); ok {
+		if poolConfig := t.GetPoolConfig(o.config); poolConfig != nil {
+			return poolConfig
+		}
+	}
+
+	return &o.config.Pool
+}
+
+// return pool stats of every connected db type
+func (o *GormDb[T]) Stats() map[T]*PoolStats {
+	o.mutex.RLock()
+	defer o.mutex.RUnlock()
+
+	stats := make(map[T]*PoolStats, len(o.db))
+	for dbType, db := range o.db {
+		sqlDb, err := db.DB()
+		if err != nil {
+			continue
+		}
+
+		s := &PoolStats{
+			Primary: sqlDb.Stats(),
+		}
+		if rs, ok := o.replicas[dbType]; ok {
+			for _, r := range rs.replicas {
+				s.Replicas = append(s.Replicas, r.db.Stats())
+			}
+		}
+		stats[dbType] = s
+	}
+
+	return stats
+}
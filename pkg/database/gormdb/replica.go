@@ -0,0 +1,317 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+type ReplicaPolicy string
+
+const (
+	ReplicaPolicyRoundRobin   ReplicaPolicy = "round_robin"
+	ReplicaPolicyRandom       ReplicaPolicy = "random"
+	ReplicaPolicyLeastLatency ReplicaPolicy = "least_latency"
+)
+
+// reads not in a transaction go to one of the replicas, writes go to the primary from DbTypeGetDsn
+type DbTypeGetReplicaDsns interface {
+	GetReplicaDsns(config *Config) []string
+}
+
+type contextKey string
+
+const (
+	forcePrimaryContextKey contextKey = "force_primary"
+
+	replicaSettingKey      = "jf:replica"
+	replicaStartSettingKey = "jf:replica_start"
+	// ConnPool of the statement before switching to a replica
+	replicaPoolSettingKey = "jf:replica_pool"
+)
+
+// reads with the returned ctx go to the primary
+func ForcePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, forcePrimaryContextKey, true)
+}
+
+func isForcePrimary(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+
+	v, _ := ctx.Value(forcePrimaryContextKey).(bool)
+	return v
+}
+
+// return a copy of config for each config.ReplicaHosts
+func GetReplicaConfigs(config *Config) []*Config {
+	configs := make([]*Config, 0, len(config.ReplicaHosts))
+	for _, host := range config.ReplicaHosts {
+		c := *config
+		c.Host = host
+		configs = append(configs, &c)
+	}
+
+	return configs
+}
+
+type replica struct {
+	// redacted
+	dsn       string
+	db        *sql.DB
+	mutex     sync.Mutex
+	latency   time.Duration
+	downUntil time.Time
+}
+
+func (o *replica) isHealthy(now time.Time) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return !now.Before(o.downUntil)
+}
+
+func (o *replica) getLatency() time.Duration {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.latency
+}
+
+// a copy of the state for Health
+func (o *replica) health(now time.Time) *ReplicaHealth {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return &ReplicaHealth{
+		Dsn:       o.dsn,
+		Healthy:   !now.Before(o.downUntil),
+		DownUntil: o.downUntil,
+		Latency:   o.latency,
+	}
+}
+
+func (o *replica) markDown(retryInterval time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.downUntil = time.Now().Add(retryInterval)
+}
+
+// exponentially weighted moving average, recent queries weigh 1/5
+func (o *replica) observe(d time.Duration) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.latency == 0 {
+		o.latency = d
+	} else {
+		o.latency = (o.latency*4 + d) / 5
+	}
+}
+
+// a gorm plugin route reads to replicas
+type replicaSet struct {
+	policy        ReplicaPolicy
+	retryInterval time.Duration
+	replicas      []*replica
+	primary       gorm.ConnPool
+	next          atomic.Uint64
+}
+
+func (o *GormDb[T]) newReplicaSet(ctx context.Context, dialect Dialect, getDsns func(config *Config) []string, poolConfig *PoolConfig) (*replicaSet, error) {
+	retryInterval := o.config.ReplicaRetryInterval
+	if retryInterval == 0 {
+		retryInterval = 30 * time.Second
+	}
+
+	rs := &replicaSet{
+		policy:        o.config.ReplicaPolicy,
+		retryInterval: retryInterval,
+	}
+
+	for i := range getDsns(o.config) {
+		i := i
+		connector, err := o.newCredentialConnector(dialect, func(config *Config) string {
+			if dsns := getDsns(config); i < len(dsns) {
+				return dsns[i]
+			}
+			return ""
+		})
+		if err != nil {
+			rs.close()
+			return nil, err
+		}
+
+		// sql.OpenDB not connect, so a replica not reachable yet still can join later
+		sqlDb := sql.OpenDB(connector)
+		poolConfig.apply(sqlDb)
+
+		// a replica not reachable at start should not stop the primary from working
+		pingErr := sqlDb.PingContext(ctx)
+		// after ping, so the dsn has the user of the credential
+		r := &replica{
+			dsn: connector.redactedDsn(),
+			db:  sqlDb,
+		}
+		if pingErr != nil {
+			r.markDown(retryInterval)
+		}
+		rs.replicas = append(rs.replicas, r)
+	}
+
+	return rs, nil
+}
+
+func (o *replicaSet) Name() string {
+	return "jf:replica"
+}
+
+// the after callback runs right after the statement, so a read retried on the primary
+// still runs preload and AfterFind hooks
+func (o *replicaSet) Initialize(db *gorm.DB) error {
+	o.primary = db.ConnPool
+
+	query := db.Callback().Query().Get("gorm:query")
+	row := db.Callback().Row().Get("gorm:row")
+	if query == nil || row == nil {
+		return serr.New("gorm query callback not found")
+	}
+
+	if err := db.Callback().Query().Before("*").Register("jf:replica", o.switchReplica); err != nil {
+		return serr.Wrap(err)
+	}
+	if err := db.Callback().Query().After("gorm:query").Register("jf:replica_after", o.afterReplica(query)); err != nil {
+		return serr.Wrap(err)
+	}
+	if err := db.Callback().Row().Before("*").Register("jf:replica", o.switchReplica); err != nil {
+		return serr.Wrap(err)
+	}
+	if err := db.Callback().Row().After("gorm:row").Register("jf:replica_after", o.afterReplica(row)); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *replicaSet) pick() *replica {
+	now := time.Now()
+	healthy := make([]*replica, 0, len(o.replicas))
+	for _, r := range o.replicas {
+		if r.isHealthy(now) {
+			healthy = append(healthy, r)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch o.policy {
+	case ReplicaPolicyRandom:
+		return healthy[rand.Intn(len(healthy))]
+	case ReplicaPolicyLeastLatency:
+		best := healthy[0]
+		for _, r := range healthy[1:] {
+			if r.getLatency() < best.getLatency() {
+				best = r
+			}
+		}
+		return best
+	default:
+		return healthy[o.next.Add(1)%uint64(len(healthy))]
+	}
+}
+
+func isReadStatement(stmt *gorm.Statement) bool {
+	if _, locking := stmt.Clauses["FOR"]; locking {
+		return false
+	}
+
+	// raw sql
+	if rawSql := strings.TrimSpace(stmt.SQL.String()); rawSql != "" {
+		return len(rawSql) > 6 &&
+			strings.EqualFold(rawSql[:6], "select") &&
+			!strings.HasSuffix(strings.ToLower(rawSql), "for update")
+	}
+
+	return true
+}
+
+func (o *replicaSet) switchReplica(db *gorm.DB) {
+	// only route statements on the pool, not in a transaction or a pinned connection
+	if db.Error != nil || db.Statement.ConnPool != o.primary {
+		return
+	}
+	if isForcePrimary(db.Statement.Context) || !isReadStatement(db.Statement) {
+		return
+	}
+
+	r := o.pick()
+	if r == nil {
+		return
+	}
+
+	db.Statement.Settings.Store(replicaPoolSettingKey, db.Statement.ConnPool)
+	db.Statement.ConnPool = r.db
+	db.Statement.Settings.Store(replicaSettingKey, r)
+	db.Statement.Settings.Store(replicaStartSettingKey, time.Now())
+}
+
+// restore the pool, so a reused statement like q.Find then q.Create not write to the replica.
+// a read failed by a connection error runs again by run on the primary
+func (o *replicaSet) afterReplica(run func(db *gorm.DB)) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		v, ok := db.Statement.Settings.LoadAndDelete(replicaSettingKey)
+		if !ok {
+			return
+		}
+		r := v.(*replica)
+		if pool, ok := db.Statement.Settings.LoadAndDelete(replicaPoolSettingKey); ok {
+			db.Statement.ConnPool = pool.(gorm.ConnPool)
+		}
+
+		if start, ok := db.Statement.Settings.LoadAndDelete(replicaStartSettingKey); ok {
+			r.observe(time.Since(start.(time.Time)))
+		}
+
+		if isConnectionError(statementError(db)) {
+			r.markDown(o.retryInterval)
+
+			// switchReplica only switches a statement without error, so the error is of the replica
+			db.Error = nil
+			run(db)
+		}
+	}
+}
+
+// the error of the statement, Row keeps its error in *sql.Row until Scan
+func statementError(db *gorm.DB) error {
+	if row, ok := db.Statement.Dest.(*sql.Row); ok && db.Error == nil {
+		return row.Err()
+	}
+	return db.Error
+}
+
+// state of each replica, in the order of DbTypeGetReplicaDsns
+func (o *replicaSet) health() []*ReplicaHealth {
+	now := time.Now()
+	result := make([]*ReplicaHealth, 0, len(o.replicas))
+	for _, r := range o.replicas {
+		result = append(result, r.health(now))
+	}
+	return result
+}
+
+func (o *replicaSet) close() error {
+	var err error
+	for _, r := range o.replicas {
+		if e := r.db.Close(); e != nil {
+			err = serr.Wrapf(e, "dsn:%s", r.dsn)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,222 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"syscall"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// a database/sql driver of a replica which is not reachable
+type downDriver struct{}
+
+func (downDriver) Open(name string) (driver.Conn, error) {
+	return nil, syscall.ECONNREFUSED
+}
+
+func init() {
+	sql.Register("gormdb_down", downDriver{})
+}
+
+type replicaItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+type replicaDbType int
+
+func (replicaDbType) GetTables() []interface{} {
+	return []interface{}{&replicaItem{}}
+}
+
+// the primary and one replica, each has the row 1 named by itself
+func newReplicaTestDb(t *testing.T) (*GormDb[replicaDbType], *replicaSet) {
+	config := &Config{
+		AutoMigrate: true,
+		LogLevel:    logger.Silent,
+	}
+	config.dsnOverride = func(dbType any, config *Config) (Dialect, string) {
+		return DialectSqlite, fmt.Sprintf("file:%s_primary?mode=memory&cache=shared", t.Name())
+	}
+	gormDb := NewGormDb[replicaDbType](config)
+	t.Cleanup(func() {
+		gormDb.Stop(context.Background())
+	})
+	if err := gormDb.Connect(); err != nil {
+		t.Fatalf("connect fail. err:%v", err)
+	}
+	primary := gormDb.Get()
+	if err := primary.Create(&replicaItem{Id: 1, Name: "primary"}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+
+	replicaDsn := fmt.Sprintf("file:%s_replica?mode=memory&cache=shared", t.Name())
+	rs, err := gormDb.newReplicaSet(context.Background(), DialectSqlite, func(config *Config) []string {
+		return []string{replicaDsn}
+	}, gormDb.getPoolConfig(0))
+	if err != nil {
+		t.Fatalf("new replica set fail. err:%v", err)
+	}
+	dialector, err := DialectSqlite.Open(replicaDsn)
+	if err != nil {
+		t.Fatalf("dialector fail. err:%v", err)
+	}
+	replicaDb, err := gorm.Open(dialector, &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("open replica fail. err:%v", err)
+	}
+	// the in-memory database is dropped with its last connection
+	t.Cleanup(func() {
+		if sqlDb, err := replicaDb.DB(); err == nil {
+			sqlDb.Close()
+		}
+	})
+	if err := replicaDb.AutoMigrate(&replicaItem{}); err != nil {
+		t.Fatalf("migrate replica fail. err:%v", err)
+	}
+	if err := replicaDb.Create(&replicaItem{Id: 1, Name: "replica"}).Error; err != nil {
+		t.Fatalf("create replica fail. err:%v", err)
+	}
+
+	if err := primary.Use(rs); err != nil {
+		t.Fatalf("use fail. err:%v", err)
+	}
+	gormDb.mutex.Lock()
+	gormDb.replicas[0] = rs
+	gormDb.mutex.Unlock()
+
+	return gormDb, rs
+}
+
+func TestReplicaRouting(t *testing.T) {
+	gormDb, _ := newReplicaTestDb(t)
+	db := gormDb.Get()
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		read func(item *replicaItem) error
+		want string
+	}{
+		{
+			name: "find",
+			read: func(item *replicaItem) error {
+				return db.WithContext(ctx).First(item, 1).Error
+			},
+			want: "replica",
+		},
+		{
+			name: "raw select",
+			read: func(item *replicaItem) error {
+				return db.WithContext(ctx).Raw("SELECT * FROM replica_items WHERE id = ?", 1).Scan(item).Error
+			},
+			want: "replica",
+		},
+		{
+			name: "force primary",
+			read: func(item *replicaItem) error {
+				return db.WithContext(ForcePrimary(ctx)).First(item, 1).Error
+			},
+			want: "primary",
+		},
+		{
+			name: "transaction",
+			read: func(item *replicaItem) error {
+				return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+					return tx.First(item, 1).Error
+				})
+			},
+			want: "primary",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &replicaItem{}
+			if err := tt.read(item); err != nil {
+				t.Fatalf("read fail. err:%v", err)
+			}
+			if item.Name != tt.want {
+				t.Fatalf("got:%s want:%s", item.Name, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplicaRestorePool(t *testing.T) {
+	gormDb, _ := newReplicaTestDb(t)
+	db := gormDb.Get()
+
+	// the statement of q is reused by Find and Create
+	q := db.Where("id > ?", 0)
+	if err := q.Find(&[]*replicaItem{}).Error; err != nil {
+		t.Fatalf("find fail. err:%v", err)
+	}
+	if err := q.Create(&replicaItem{Id: 2, Name: "new"}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+
+	var count int64
+	if err := db.WithContext(ForcePrimary(context.Background())).Model(&replicaItem{}).Where("id = ?", 2).Count(&count).Error; err != nil {
+		t.Fatalf("count fail. err:%v", err)
+	}
+	if count != 1 {
+		t.Fatalf("write not on the primary. count:%d", count)
+	}
+}
+
+func TestReplicaMarkDown(t *testing.T) {
+	gormDb, rs := newReplicaTestDb(t)
+	db := gormDb.Get()
+
+	downDb, err := sql.Open("gormdb_down", "")
+	if err != nil {
+		t.Fatalf("open fail. err:%v", err)
+	}
+	rs.replicas[0].db.Close()
+	rs.replicas[0].db = downDb
+
+	steps := []struct {
+		name string
+		read func(item *replicaItem) error
+	}{
+		{
+			name: "find retried on primary",
+			read: func(item *replicaItem) error {
+				return db.First(item, 1).Error
+			},
+		},
+		{
+			name: "row retried on primary",
+			read: func(item *replicaItem) error {
+				return db.Raw("SELECT name FROM replica_items WHERE id = ?", 1).Row().Scan(&item.Name)
+			},
+		},
+	}
+	for _, step := range steps {
+		rs.replicas[0].mutex.Lock()
+		rs.replicas[0].downUntil = time.Time{}
+		rs.replicas[0].mutex.Unlock()
+
+		item := &replicaItem{}
+		if err := step.read(item); err != nil {
+			t.Fatalf("%s fail. err:%v", step.name, err)
+		}
+		if item.Name != "primary" {
+			t.Fatalf("%s got:%s want:primary", step.name, item.Name)
+		}
+
+		status := gormDb.Health()[0]
+		if len(status.Replicas) != 1 {
+			t.Fatalf("%s replicas:%d want:1", step.name, len(status.Replicas))
+		}
+		if status.Replicas[0].Healthy || status.Replicas[0].DownUntil.IsZero() {
+			t.Fatalf("%s replica not marked down. health:%+v", step.name, status.Replicas[0])
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// a field of this type is the version column of optimistic locking, see Repository.Update
+type Version int64
+
+var versionType = reflect.TypeOf(Version(0))
+
+// the row is deleted or updated by others since it was read
+var ErrVersionConflict = errors.New("version conflict")
+
+// CRUD of model M in a db type, use the transaction of WithTx in ctx if any
+type Repository[M any] struct {
+	fromCtx func(ctx context.Context) (*gorm.DB, error)
+}
+
+func NewRepository[M any, T comparable](gormDb *GormDb[T], dbType T) *Repository[M] {
+	return &Repository[M]{
+		fromCtx: func(ctx context.Context) (*gorm.DB, error) {
+			return gormDb.TryFromCtx(ctx, dbType)
+		},
+	}
+}
+
+type Page[M any] struct {
+	Items []*M
+	// pass to Repository.Page for the next page, empty if no more
+	NextCursor string
+}
+
+func (o *Repository[M]) getDb(ctx context.Context) (*gorm.DB, *schema.Schema, error) {
+	db, err := o.fromCtx(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stmt := &gorm.Statement{DB: db}
+	if err := stmt.Parse(new(M)); err != nil {
+		return nil, nil, serr.Wrap(err)
+	}
+
+	return db, stmt.Schema, nil
+}
+
+func getVersionField(s *schema.Schema) *schema.Field {
+	for _, field := range s.Fields {
+		if field.FieldType == versionType && field.DBName != "" {
+			return field
+		}
+	}
+	return nil
+}
+
+// update or delete without primary key would change every row
+func checkPrimaryKey(ctx context.Context, s *schema.Schema, m interface{}) error {
+	if len(s.PrimaryFields) == 0 {
+		return serr.Errorf("no primary key. table:%s", s.Table)
+	}
+
+	rv := reflect.ValueOf(m).Elem()
+	for _, field := range s.PrimaryFields {
+		if _, isZero := field.ValueOf(ctx, rv); isZero {
+			return serr.Errorf("primary key is zero. table:%s column:%s", s.Table, field.DBName)
+		}
+	}
+	return nil
+}
+
+// return gorm.ErrRecordNotFound (check by errors.Is) if not found
+func (o *Repository[M]) Get(ctx context.Context, id interface{}) (*M, error) {
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return nil, serr.Errorf("no primary key. table:%s", s.Table)
+	}
+
+	m := new(M)
+	column := clause.Column{Table: clause.CurrentTable, Name: s.PrioritizedPrimaryField.DBName}
+	if err := db.Where(clause.Eq{Column: column, Value: id}).Take(m).Error; err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return m, nil
+}
+
+func (o *Repository[M]) Find(ctx context.Context, filter *Filter[M]) ([]*M, error) {
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err = filter.where(db, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, order := range filter.getOrders() {
+		dbName, err := lookUpColumn(s, order.column)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: dbName}, Desc: order.desc})
+	}
+	if limit := filter.getLimit(); limit > 0 {
+		db = db.Limit(limit)
+	}
+
+	ms := []*M{}
+	if err := db.Find(&ms).Error; err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	return ms, nil
+}
+
+func (o *Repository[M]) Count(ctx context.Context, filter *Filter[M]) (int64, error) {
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	db, err = filter.where(db.Model(new(M)), s)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := db.Count(&count).Error; err != nil {
+		return 0, serr.Wrap(err)
+	}
+
+	return count, nil
+}
+
+// a zero version column is set to 1
+func (o *Repository[M]) Create(ctx context.Context, m *M) error {
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return err
+	}
+
+	if versionField := getVersionField(s); versionField != nil {
+		if _, isZero := versionField.ValueOf(ctx, reflect.ValueOf(m).Elem()); isZero {
+			if err := versionField.Set(ctx, reflect.ValueOf(m).Elem(), Version(1)); err != nil {
+				return serr.Wrap(err)
+			}
+		}
+	}
+
+	if err := db.Create(m).Error; err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+// update all columns of m by primary key, zero values included
+//
+// if M has a Version field, the row is updated only when its version is still the version of m,
+// and the version is increased. otherwise ErrVersionConflict is returned and m is not changed
+func (o *Repository[M]) Update(ctx context.Context, m *M) error {
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkPrimaryKey(ctx, s, m); err != nil {
+		return err
+	}
+
+	versionField := getVersionField(s)
+	if versionField == nil {
+		if err := db.Model(m).Select("*").Updates(m).Error; err != nil {
+			return serr.Wrap(err)
+		}
+		return nil
+	}
+
+	rv := reflect.ValueOf(m).Elem()
+	v, _ := versionField.ValueOf(ctx, rv)
+	version := v.(Version)
+	if err := versionField.Set(ctx, rv, version+1); err != nil {
+		return serr.Wrap(err)
+	}
+
+	column := clause.Column{Table: clause.CurrentTable, Name: versionField.DBName}
+	result := db.Model(m).Where(clause.Eq{Column: column, Value: version}).Select("*").Updates(m)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = ErrVersionConflict
+	}
+	if result.Error != nil {
+		versionField.Set(ctx, rv, version)
+		if errors.Is(result.Error, ErrVersionConflict) {
+			return ErrVersionConflict
+		}
+		return serr.Wrap(result.Error)
+	}
+
+	return nil
+}
+
+// insert m, or update all columns if the primary key or a unique key exists. the version is not checked
+func (o *Repository[M]) Upsert(ctx context.Context, m *M) error {
+	db, _, err := o.getDb(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Clauses(clause.OnConflict{UpdateAll: true}).Create(m).Error; err != nil {
+		return serr.Wrap(err)
+	}
+	return nil
+}
+
+// delete m by primary key, if M has a Version field, ErrVersionConflict is returned when the version changed
+func (o *Repository[M]) Delete(ctx context.Context, m *M) error {
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return err
+	}
+	if err := checkPrimaryKey(ctx, s, m); err != nil {
+		return err
+	}
+
+	versionField := getVersionField(s)
+	if versionField == nil {
+		if err := db.Delete(m).Error; err != nil {
+			return serr.Wrap(err)
+		}
+		return nil
+	}
+
+	version, _ := versionField.ValueOf(ctx, reflect.ValueOf(m).Elem())
+	column := clause.Column{Table: clause.CurrentTable, Name: versionField.DBName}
+	result := db.Where(clause.Eq{Column: column, Value: version}).Delete(m)
+	if result.Error != nil {
+		return serr.Wrap(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrVersionConflict
+	}
+
+	return nil
+}
+
+type cursor struct {
+	Columns []string          `json:"c"`
+	Values  []json.RawMessage `json:"v"`
+}
+
+// keyset pagination ordered by orders of filter then the primary key, so rows inserted meanwhile not shift pages
+//
+// cursor is empty for the first page. order columns must be not null
+func (o *Repository[M]) Page(ctx context.Context, filter *Filter[M], cursorToken string, limit int) (*Page[M], error) {
+	if limit <= 0 {
+		return nil, serr.Errorf("limit must be greater than 0. limit:%d", limit)
+	}
+
+	db, s, err := o.getDb(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err = filter.where(db, s)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := []*schema.Field{}
+	desc := []bool{}
+	for _, order := range filter.getOrders() {
+		dbName, err := lookUpColumn(s, order.column)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, s.FieldsByDBName[dbName])
+		desc = append(desc, order.desc)
+	}
+	for _, primaryField := range s.PrimaryFields {
+		found := false
+		for _, field := range fields {
+			found = found || field == primaryField
+		}
+		if !found {
+			fields = append(fields, primaryField)
+			desc = append(desc, false)
+		}
+	}
+	if len(s.PrimaryFields) == 0 {
+		return nil, serr.Errorf("no primary key. table:%s", s.Table)
+	}
+
+	if cursorToken != "" {
+		expression, err := cursorExpression(cursorToken, fields, desc)
+		if err != nil {
+			return nil, err
+		}
+		db = db.Clauses(clause.Where{Exprs: []clause.Expression{expression}})
+	}
+
+	for i, field := range fields {
+		db = db.Order(clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Desc: desc[i]})
+	}
+
+	ms := []*M{}
+	if err := db.Limit(limit + 1).Find(&ms).Error; err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	page := &Page[M]{
+		Items: ms,
+	}
+	if len(ms) > limit {
+		page.Items = ms[:limit]
+		page.NextCursor, err = encodeCursor(ctx, fields, page.Items[limit-1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return page, nil
+}
+
+func encodeCursor(ctx context.Context, fields []*schema.Field, m interface{}) (string, error) {
+	c := cursor{}
+	rv := reflect.ValueOf(m).Elem()
+	for _, field := range fields {
+		v, _ := field.ValueOf(ctx, rv)
+		data, err := json.Marshal(v)
+		if err != nil {
+			return "", serr.Wrap(err)
+		}
+		c.Columns = append(c.Columns, field.DBName)
+		c.Values = append(c.Values, data)
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", serr.Wrap(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// (a > ?) OR (a = ? AND b > ?) OR ..., > is < for desc columns
+func cursorExpression(cursorToken string, fields []*schema.Field, desc []bool) (clause.Expression, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursorToken)
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+	c := cursor{}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, serr.Wrap(err)
+	}
+	if len(c.Columns) != len(fields) || len(c.Values) != len(fields) {
+		return nil, serr.New("cursor not match the order")
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, field := range fields {
+		if c.Columns[i] != field.DBName {
+			return nil, serr.Errorf("cursor not match the order. column:%s", c.Columns[i])
+		}
+		v := reflect.New(field.FieldType)
+		if err := json.Unmarshal(c.Values[i], v.Interface()); err != nil {
+			return nil, serr.Wrapf(err, "column:%s", field.DBName)
+		}
+		values[i] = v.Elem().Interface()
+	}
+
+	ors := make([]clause.Expression, 0, len(fields))
+	for i, field := range fields {
+		ands := make([]clause.Expression, 0, i+1)
+		for j := 0; j < i; j++ {
+			ands = append(ands, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: fields[j].DBName}, Value: values[j]})
+		}
+
+		column := clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+		if desc[i] {
+			ands = append(ands, clause.Lt{Column: column, Value: values[i]})
+		} else {
+			ands = append(ands, clause.Gt{Column: column, Value: values[i]})
+		}
+		ors = append(ors, clause.And(ands...))
+	}
+
+	// gorm joins an OrConditions of one expression to the filter by OR
+	if len(ors) == 1 {
+		return ors[0], nil
+	}
+	return clause.Or(ors...), nil
+}
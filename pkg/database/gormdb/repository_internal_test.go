@@ -0,0 +1,136 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type cursorItem struct {
+	Id        int64 `gorm:"primaryKey"`
+	Name      string
+	CreatedAt time.Time
+}
+
+func TestCursor(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("open fail. err:%v", err)
+	}
+	s, err := schema.Parse(&cursorItem{}, &sync.Map{}, db.NamingStrategy)
+	if err != nil {
+		t.Fatalf("parse fail. err:%v", err)
+	}
+	id, name, createdAt := s.FieldsByDBName["id"], s.FieldsByDBName["name"], s.FieldsByDBName["created_at"]
+
+	item := &cursorItem{
+		Id:        7,
+		Name:      "sword",
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC),
+	}
+
+	tests := []struct {
+		name     string
+		fields   []*schema.Field
+		desc     []bool
+		wantSql  string
+		wantVars string
+	}{
+		{
+			name:     "primary key",
+			fields:   []*schema.Field{id},
+			desc:     []bool{false},
+			wantSql:  "SELECT * FROM `cursor_items` WHERE `cursor_items`.`id` > ?",
+			wantVars: "[7]",
+		},
+		{
+			name:     "desc and primary key",
+			fields:   []*schema.Field{name, id},
+			desc:     []bool{true, false},
+			wantSql:  "SELECT * FROM `cursor_items` WHERE (`cursor_items`.`name` < ? OR (`cursor_items`.`name` = ? AND `cursor_items`.`id` > ?))",
+			wantVars: "[sword sword 7]",
+		},
+		{
+			name:     "time",
+			fields:   []*schema.Field{createdAt, id},
+			desc:     []bool{false, false},
+			wantSql:  "SELECT * FROM `cursor_items` WHERE (`cursor_items`.`created_at` > ? OR (`cursor_items`.`created_at` = ? AND `cursor_items`.`id` > ?))",
+			wantVars: fmt.Sprint([]interface{}{item.CreatedAt, item.CreatedAt, 7}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := encodeCursor(context.Background(), tt.fields, item)
+			if err != nil {
+				t.Fatalf("encode fail. err:%v", err)
+			}
+			expression, err := cursorExpression(token, tt.fields, tt.desc)
+			if err != nil {
+				t.Fatalf("decode fail. err:%v", err)
+			}
+
+			stmt := db.Where(expression).Find(&[]*cursorItem{}).Statement
+			if stmt.SQL.String() != tt.wantSql {
+				t.Fatalf("sql:%s want:%s", stmt.SQL.String(), tt.wantSql)
+			}
+			if fmt.Sprint(stmt.Vars) != tt.wantVars {
+				t.Fatalf("vars:%v want:%s", stmt.Vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestCursorNotMatch(t *testing.T) {
+	s, err := schema.Parse(&cursorItem{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatalf("parse fail. err:%v", err)
+	}
+	id, name := s.FieldsByDBName["id"], s.FieldsByDBName["name"]
+
+	token, err := encodeCursor(context.Background(), []*schema.Field{name, id}, &cursorItem{Id: 1, Name: "a"})
+	if err != nil {
+		t.Fatalf("encode fail. err:%v", err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		fields []*schema.Field
+	}{
+		{
+			name:   "not base64",
+			token:  "!",
+			fields: []*schema.Field{id},
+		},
+		{
+			name:   "fewer columns",
+			token:  token,
+			fields: []*schema.Field{id},
+		},
+		{
+			name:   "other columns",
+			token:  token,
+			fields: []*schema.Field{id, name},
+		},
+		{
+			name:   "value type",
+			token:  base64.RawURLEncoding.EncodeToString([]byte(`{"c":["id"],"v":["a"]}`)),
+			fields: []*schema.Field{id},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desc := make([]bool, len(tt.fields))
+			if _, err := cursorExpression(tt.token, tt.fields, desc); err == nil {
+				t.Fatalf("want error")
+			}
+		})
+	}
+}
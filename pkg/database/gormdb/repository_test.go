@@ -0,0 +1,143 @@
+package gormdb_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+)
+
+type repositoryItem struct {
+	Id      int64 `gorm:"primaryKey"`
+	OwnerId int64
+	Level   int
+}
+
+type repositoryDbType int
+
+func (repositoryDbType) GetTables() []interface{} {
+	return []interface{}{&repositoryItem{}}
+}
+
+func TestRepositoryPage(t *testing.T) {
+	gormDb := gormdbtest.New[repositoryDbType](t, nil, 0)
+	repository := gormdb.NewRepository[repositoryItem](gormDb, 0)
+	ctx := context.Background()
+	for i := int64(1); i <= 20; i++ {
+		item := &repositoryItem{
+			Id:      i,
+			OwnerId: i % 2,
+			Level:   int(i % 5),
+		}
+		if err := repository.Create(ctx, item); err != nil {
+			t.Fatalf("create fail. err:%v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter *gormdb.Filter[repositoryItem]
+		limit  int
+		want   []int64
+	}{
+		{
+			name:  "no filter",
+			limit: 7,
+			want:  []int64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20},
+		},
+		{
+			name:   "filter with primary key order",
+			filter: gormdb.NewFilter[repositoryItem]().Eq("owner_id", 1),
+			limit:  3,
+			want:   []int64{1, 3, 5, 7, 9, 11, 13, 15, 17, 19},
+		},
+		{
+			name:   "filter with order",
+			filter: gormdb.NewFilter[repositoryItem]().Eq("owner_id", 0).Asc("level"),
+			limit:  2,
+			want:   []int64{10, 20, 6, 16, 2, 12, 8, 18, 4, 14},
+		},
+		{
+			name:   "filter with desc order",
+			filter: gormdb.NewFilter[repositoryItem]().Gt("level", 2).Desc("level"),
+			limit:  3,
+			want:   []int64{4, 9, 14, 19, 3, 8, 13, 18},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int64{}
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatalf("too many pages. got:%v", got)
+				}
+
+				page, err := repository.Page(ctx, tt.filter, cursor, tt.limit)
+				if err != nil {
+					t.Fatalf("page fail. err:%v", err)
+				}
+				if len(page.Items) > tt.limit {
+					t.Fatalf("page larger than limit. len:%d", len(page.Items))
+				}
+				for _, item := range page.Items {
+					got = append(got, item.Id)
+				}
+
+				if page.NextCursor == "" {
+					break
+				}
+				cursor = page.NextCursor
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got:%v want:%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepositoryPageBadCursor(t *testing.T) {
+	gormDb := gormdbtest.New[repositoryDbType](t, nil, 0)
+	repository := gormdb.NewRepository[repositoryItem](gormDb, 0)
+	ctx := context.Background()
+	for i := int64(1); i <= 3; i++ {
+		if err := repository.Create(ctx, &repositoryItem{Id: i}); err != nil {
+			t.Fatalf("create fail. err:%v", err)
+		}
+	}
+
+	page, err := repository.Page(ctx, nil, "", 1)
+	if err != nil {
+		t.Fatalf("page fail. err:%v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter *gormdb.Filter[repositoryItem]
+		cursor string
+	}{
+		{
+			name:   "not base64",
+			cursor: "!",
+		},
+		{
+			name:   "not json",
+			cursor: "bm90IGpzb24",
+		},
+		{
+			name:   "other order",
+			filter: gormdb.NewFilter[repositoryItem]().Asc("level"),
+			cursor: page.NextCursor,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := repository.Page(ctx, tt.filter, tt.cursor, 1); err == nil {
+				t.Fatalf("want error")
+			}
+		})
+	}
+}
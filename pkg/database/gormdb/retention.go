@@ -0,0 +1,422 @@
+package gormdb
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/trigger"
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type RetentionAction string
+
+const (
+	RetentionActionDelete RetentionAction = "delete"
+	// move rows to the table <table>_archive, created like the table without its indexes.
+	// columns of the model missing in it are added each run
+	RetentionActionArchiveTable RetentionAction = "archive_table"
+	// append rows to a gzip json lines file in RetentionPolicy.ArchiveDir, then delete them
+	RetentionActionArchiveFile RetentionAction = "archive_file"
+)
+
+// policies of GetRetentionPolicies are run by triggers of GormDb.RetentionTriggers
+type DbTypeGetRetentionPolicies interface {
+	GetRetentionPolicies() []*RetentionPolicy
+}
+
+// remove rows older than Keep of the table of Model. the model must have a primary key
+//
+// rows are hard deleted by gorm Delete of the model, so caches of NewCache are invalidated,
+// audited tables get a delete log of each row and delete hooks of the model are called
+type RetentionPolicy struct {
+	Model interface{}
+	// column of the row time, default is created_at
+	TimeColumn string
+	Keep       time.Duration
+	// default is RetentionActionDelete
+	Action RetentionAction
+	// directory of files of RetentionActionArchiveFile, a file <table>_<run time>.jsonl.gz each run
+	ArchiveDir string
+	// rows of one batch, default is 1000
+	BatchSize int
+	// sleep between batches, so hot tables are not locked long, default is 100 milliseconds
+	BatchInterval time.Duration
+	// run interval of the trigger, default is 1 hour
+	Interval time.Duration
+	// called after each batch and after the run, default logs the run
+	Report func(ctx context.Context, report *RetentionReport)
+}
+
+type RetentionReport struct {
+	Table  string
+	Action RetentionAction
+	// rows with time before Cutoff are removed
+	Cutoff    time.Time
+	StartedAt time.Time
+	Batches   int
+	Rows      int64
+	// archive file of RetentionActionArchiveFile, empty if no row
+	File string
+	// another process is running the policy, this run did nothing
+	Skipped bool
+	// the run finished, false for progress reports after batches
+	Done bool
+	Err  error
+}
+
+func (o *RetentionPolicy) withDefaults() *RetentionPolicy {
+	p := *o
+	if p.TimeColumn == "" {
+		p.TimeColumn = "created_at"
+	}
+	if p.Action == "" {
+		p.Action = RetentionActionDelete
+	}
+	if p.BatchSize <= 0 {
+		p.BatchSize = 1000
+	}
+	if p.BatchInterval <= 0 {
+		p.BatchInterval = 100 * time.Millisecond
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Hour
+	}
+
+	return &p
+}
+
+// a trigger for each policy of dbTypes implement DbTypeGetRetentionPolicies, run them by trigger.TriggerManager
+func (o *GormDb[T]) RetentionTriggers(dbTypes ...T) []*trigger.Trigger {
+	triggers := []*trigger.Trigger{}
+	for _, dbType := range dbTypes {
+		t, ok := any(dbType).(DbTypeGetRetentionPolicies)
+		if !ok {
+			continue
+		}
+
+		for _, policy := range t.GetRetentionPolicies() {
+			dbType, policy := dbType, policy.withDefaults()
+			name := fmt.Sprintf("gormdb retention %v %T", dbType, policy.Model)
+			// stopping the trigger cancels a long run between batches
+			triggers = append(triggers, trigger.NewContextTrigger(policy.Interval, name, func(ctx context.Context) error {
+				_, err := o.RunRetention(ctx, dbType, policy)
+				return err
+			}))
+		}
+	}
+
+	return triggers
+}
+
+// remove expired rows of policy once, in batches until no expired row
+//
+// only one process runs a table at the same time, others return a skipped report
+func (o *GormDb[T]) RunRetention(ctx context.Context, dbType T, policy *RetentionPolicy) (*RetentionReport, error) {
+	policy = policy.withDefaults()
+	if policy.Keep <= 0 {
+		return nil, serr.Errorf("keep must be greater than 0. keep:%s", policy.Keep)
+	}
+	if policy.Action == RetentionActionArchiveFile && policy.ArchiveDir == "" {
+		return nil, serr.New("archive dir is empty")
+	}
+
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return nil, err
+	}
+	db = db.WithContext(ForcePrimary(ctx))
+
+	s, err := o.parseSchema(policy.Model)
+	if err != nil {
+		return nil, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return nil, serr.Errorf("no primary key. table:%s", s.Table)
+	}
+	if _, ok := s.FieldsByDBName[policy.TimeColumn]; !ok {
+		return nil, serr.Errorf("unknown column. table:%s column:%s", s.Table, policy.TimeColumn)
+	}
+
+	now := time.Now()
+	report := &RetentionReport{
+		Table:     s.Table,
+		Action:    policy.Action,
+		Cutoff:    now.Add(-policy.Keep),
+		StartedAt: now,
+	}
+
+	dialect := o.getDialect(dbType)
+	name := fmt.Sprintf("jf_retention:%s", s.Table)
+	err = db.Connection(func(conn *gorm.DB) error {
+		// a new session, so statements on conn not share conditions
+		conn = conn.Session(&gorm.Session{})
+		locked, err := dialect.tryLock(conn, name, 0)
+		if err != nil {
+			return err
+		}
+		if !locked {
+			report.Skipped = true
+			return nil
+		}
+		// still unlock after ctx is canceled, conn goes back to the pool with the lock otherwise
+		defer dialect.unlock(conn.WithContext(context.WithoutCancel(ctx)), name)
+
+		r := &retentionRun{
+			policy:  policy,
+			schema:  s,
+			dialect: dialect,
+			db:      conn,
+			report:  report,
+		}
+		err = r.run(ctx)
+		if closeErr := r.close(); err == nil {
+			err = closeErr
+		}
+		return err
+	})
+
+	report.Done = true
+	report.Err = err
+	if policy.Report != nil {
+		policy.Report(ctx, report)
+	} else {
+		o.logRetention(ctx, report)
+	}
+
+	return report, err
+}
+
+func (o *GormDb[T]) logRetention(ctx context.Context, report *RetentionReport) {
+	attrs := []interface{}{
+		slog.String("table", report.Table),
+		slog.String("action", string(report.Action)),
+		slog.Time("cutoff", report.Cutoff),
+		slog.Int("batches", report.Batches),
+		slog.Int64("rows", report.Rows),
+		slog.Bool("skipped", report.Skipped),
+		slog.Duration("elapsed", time.Since(report.StartedAt)),
+	}
+	if report.File != "" {
+		attrs = append(attrs, slog.String("file", report.File))
+	}
+
+	if report.Err != nil {
+		attrs = append(attrs, slog.Any("err", serr.ToJSON(report.Err, true)))
+		o.log().WarnContext(ctx, "gormdb retention fail", attrs...)
+		return
+	}
+	o.log().InfoContext(ctx, "gormdb retention", attrs...)
+}
+
+type retentionRun struct {
+	policy  *RetentionPolicy
+	schema  *schema.Schema
+	dialect Dialect
+	db      *gorm.DB
+	report  *RetentionReport
+	file    *os.File
+	gz      *gzip.Writer
+}
+
+func (o *retentionRun) run(ctx context.Context) error {
+	archiveTable := o.schema.Table + "_archive"
+	if o.policy.Action == RetentionActionArchiveTable {
+		if err := o.migrateArchiveTable(archiveTable); err != nil {
+			return err
+		}
+	}
+
+	for {
+		ids, err := o.expiredIds()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		switch o.policy.Action {
+		case RetentionActionDelete:
+			err = o.delete(o.db, ids)
+		case RetentionActionArchiveTable:
+			err = o.db.Transaction(func(tx *gorm.DB) error {
+				if err := o.copyToTable(tx, archiveTable, ids); err != nil {
+					return err
+				}
+				return o.delete(tx, ids)
+			})
+		case RetentionActionArchiveFile:
+			// rows are deleted after written, so a failure may archive rows twice but never lose them
+			if err = o.writeFile(ids); err == nil {
+				err = o.delete(o.db, ids)
+			}
+		default:
+			err = serr.Errorf("unknown action:%s", o.policy.Action)
+		}
+		if err != nil {
+			return err
+		}
+
+		o.report.Batches++
+		if o.policy.Report != nil {
+			o.policy.Report(ctx, o.report)
+		}
+
+		if len(ids) < o.policy.BatchSize {
+			return nil
+		}
+		if err := sleepContext(ctx, o.policy.BatchInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// not AutoMigrate, named indexes of the model would collide with the ones of the table
+func (o *retentionRun) migrateArchiveTable(archiveTable string) error {
+	if err := o.dialect.createTableLike(o.db, archiveTable, o.schema.Table); err != nil {
+		return err
+	}
+
+	migrator := o.db.Table(archiveTable).Migrator()
+	for _, dbName := range o.schema.DBNames {
+		if migrator.HasColumn(o.policy.Model, dbName) {
+			continue
+		}
+		if err := migrator.AddColumn(o.policy.Model, dbName); err != nil {
+			return serr.Wrapf(err, "table:%s column:%s", archiveTable, dbName)
+		}
+	}
+
+	return nil
+}
+
+func (o *retentionRun) primaryColumn() clause.Column {
+	return clause.Column{Name: o.schema.PrioritizedPrimaryField.DBName}
+}
+
+func (o *retentionRun) expiredIds() ([]interface{}, error) {
+	// scan into the field type, drivers may return ids as []byte
+	values := reflect.New(reflect.SliceOf(o.schema.PrioritizedPrimaryField.FieldType))
+	err := o.db.Table(o.schema.Table).
+		Where(clause.Lt{Column: clause.Column{Name: o.policy.TimeColumn}, Value: o.report.Cutoff}).
+		Order(clause.OrderByColumn{Column: o.primaryColumn()}).
+		Limit(o.policy.BatchSize).
+		Pluck(o.schema.PrioritizedPrimaryField.DBName, values.Interface()).Error
+	if err != nil {
+		return nil, serr.Wrapf(err, "table:%s", o.schema.Table)
+	}
+
+	ids := make([]interface{}, 0, values.Elem().Len())
+	for i := 0; i < values.Elem().Len(); i++ {
+		ids = append(ids, values.Elem().Index(i).Interface())
+	}
+	return ids, nil
+}
+
+// hard delete by the model with primary keys, so callbacks see which rows are deleted
+func (o *retentionRun) delete(db *gorm.DB, ids []interface{}) error {
+	ctx := db.Statement.Context
+	field := o.schema.PrioritizedPrimaryField
+	rows := reflect.MakeSlice(reflect.SliceOf(reflect.PtrTo(o.schema.ModelType)), 0, len(ids))
+	for _, id := range ids {
+		row := reflect.New(o.schema.ModelType)
+		if err := field.Set(ctx, row.Elem(), id); err != nil {
+			return serr.Wrap(err)
+		}
+		rows = reflect.Append(rows, row)
+	}
+
+	result := db.Unscoped().Delete(rows.Interface())
+	if result.Error != nil {
+		return serr.Wrapf(result.Error, "table:%s", o.schema.Table)
+	}
+
+	o.report.Rows += result.RowsAffected
+	return nil
+}
+
+func (o *retentionRun) copyToTable(db *gorm.DB, archiveTable string, ids []interface{}) error {
+	vars := make([]interface{}, 0, len(o.schema.DBNames))
+	for _, dbName := range o.schema.DBNames {
+		vars = append(vars, clause.Column{Name: dbName})
+	}
+	columns := clause.Expr{SQL: strings.TrimSuffix(strings.Repeat("?,", len(vars)), ","), Vars: vars}
+
+	err := db.Exec("INSERT INTO ? (?) SELECT ? FROM ? WHERE ?",
+		clause.Table{Name: archiveTable},
+		columns,
+		columns,
+		clause.Table{Name: o.schema.Table},
+		clause.IN{Column: o.primaryColumn(), Values: ids}).Error
+	if err != nil {
+		return serr.Wrapf(err, "table:%s", archiveTable)
+	}
+
+	return nil
+}
+
+func (o *retentionRun) writeFile(ids []interface{}) error {
+	rows := reflect.New(reflect.SliceOf(o.schema.ModelType))
+	if err := o.db.Table(o.schema.Table).
+		Where(clause.IN{Column: o.primaryColumn(), Values: ids}).
+		Order(clause.OrderByColumn{Column: o.primaryColumn()}).
+		Find(rows.Interface()).Error; err != nil {
+		return serr.Wrapf(err, "table:%s", o.schema.Table)
+	}
+
+	if o.gz == nil {
+		path := filepath.Join(o.policy.ArchiveDir,
+			fmt.Sprintf("%s_%s.jsonl.gz", o.schema.Table, o.report.StartedAt.Format("20060102T150405")))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return serr.Wrap(err)
+		}
+		o.file = file
+		o.gz = gzip.NewWriter(file)
+		o.report.File = path
+	}
+
+	encoder := json.NewEncoder(o.gz)
+	for i := 0; i < rows.Elem().Len(); i++ {
+		if err := encoder.Encode(rows.Elem().Index(i).Interface()); err != nil {
+			return serr.Wrap(err)
+		}
+	}
+
+	// rows must be on disk before deleted
+	if err := o.gz.Flush(); err != nil {
+		return serr.Wrap(err)
+	}
+	if err := o.file.Sync(); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *retentionRun) close() error {
+	if o.gz == nil {
+		return nil
+	}
+
+	gzErr := o.gz.Close()
+	fileErr := o.file.Close()
+	if gzErr != nil {
+		return serr.Wrap(gzErr)
+	}
+	if fileErr != nil {
+		return serr.Wrap(fileErr)
+	}
+	return nil
+}
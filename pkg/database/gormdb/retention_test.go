@@ -0,0 +1,257 @@
+package gormdb_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+)
+
+type retentionItem struct {
+	Id int64 `gorm:"primaryKey"`
+	// a named index, names of indexes are unique in a sqlite database
+	Name      string `gorm:"index:idx_retention_name"`
+	CreatedAt time.Time
+}
+
+type retentionDbType int
+
+func (retentionDbType) GetTables() []interface{} {
+	return []interface{}{&retentionItem{}}
+}
+
+func (retentionDbType) GetRetentionPolicies() []*gormdb.RetentionPolicy {
+	return []*gormdb.RetentionPolicy{
+		{Model: &retentionItem{}, Keep: time.Hour},
+		{Model: &retentionItem{}, Keep: time.Hour, Action: gormdb.RetentionActionArchiveTable},
+	}
+}
+
+// ids 1 to 3 expired, 4 and 5 not
+func newRetentionTestDb(t *testing.T) *gormdb.GormDb[retentionDbType] {
+	gormDb := gormdbtest.New[retentionDbType](t, &gormdb.Config{
+		Log: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}, 0)
+	now := time.Now()
+	items := []*retentionItem{}
+	for i := int64(1); i <= 5; i++ {
+		createdAt := now.Add(-2 * time.Hour)
+		if i > 3 {
+			createdAt = now
+		}
+		items = append(items, &retentionItem{Id: i, Name: fmt.Sprint("item", i), CreatedAt: createdAt})
+	}
+	if err := gormDb.GetDb(0).Create(items).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+	return gormDb
+}
+
+func getRetentionIds(t *testing.T, db *gorm.DB, table string) []int64 {
+	ids := []int64{}
+	if err := db.Table(table).Order("id").Pluck("id", &ids).Error; err != nil {
+		t.Fatalf("pluck fail. table:%s err:%v", table, err)
+	}
+	return ids
+}
+
+func readRetentionFile(t *testing.T, path string) []int64 {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open fail. err:%v", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("gzip fail. err:%v", err)
+	}
+
+	ids := []int64{}
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		item := &retentionItem{}
+		if err := json.Unmarshal(scanner.Bytes(), item); err != nil {
+			t.Fatalf("unmarshal fail. line:%s err:%v", scanner.Text(), err)
+		}
+		ids = append(ids, item.Id)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("scan fail. err:%v", err)
+	}
+	return ids
+}
+
+func TestRunRetention(t *testing.T) {
+	tests := []struct {
+		name   string
+		action gormdb.RetentionAction
+		// run before the retention
+		prepare     func(db *gorm.DB) error
+		wantArchive string
+		wantFile    string
+	}{
+		{
+			name:   "delete",
+			action: gormdb.RetentionActionDelete,
+		},
+		{
+			name:        "archive table",
+			action:      gormdb.RetentionActionArchiveTable,
+			wantArchive: "[1 2 3]",
+		},
+		{
+			name:   "archive table missing columns",
+			action: gormdb.RetentionActionArchiveTable,
+			prepare: func(db *gorm.DB) error {
+				return db.Exec("CREATE TABLE retention_items_archive (id integer, created_at datetime)").Error
+			},
+			wantArchive: "[1 2 3]",
+		},
+		{
+			name:     "archive file",
+			action:   gormdb.RetentionActionArchiveFile,
+			wantFile: "[1 2 3]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newRetentionTestDb(t)
+			db := gormDb.GetDb(0)
+			if tt.prepare != nil {
+				if err := tt.prepare(db); err != nil {
+					t.Fatalf("prepare fail. err:%v", err)
+				}
+			}
+
+			progress := 0
+			policy := &gormdb.RetentionPolicy{
+				Model:         &retentionItem{},
+				Keep:          time.Hour,
+				Action:        tt.action,
+				ArchiveDir:    t.TempDir(),
+				BatchSize:     2,
+				BatchInterval: time.Millisecond,
+				Report: func(ctx context.Context, report *gormdb.RetentionReport) {
+					if !report.Done {
+						progress++
+					}
+				},
+			}
+			report, err := gormDb.RunRetention(context.Background(), 0, policy)
+			if err != nil {
+				t.Fatalf("run fail. err:%v", err)
+			}
+			if report.Batches != 2 || report.Rows != 3 || !report.Done || report.Skipped || progress != 2 {
+				t.Fatalf("report:%+v progress:%d", report, progress)
+			}
+			if ids := fmt.Sprint(getRetentionIds(t, db, "retention_items")); ids != "[4 5]" {
+				t.Fatalf("ids:%s want:[4 5]", ids)
+			}
+
+			if tt.wantArchive != "" {
+				if ids := fmt.Sprint(getRetentionIds(t, db, "retention_items_archive")); ids != tt.wantArchive {
+					t.Fatalf("archive ids:%s want:%s", ids, tt.wantArchive)
+				}
+				names := []string{}
+				if err := db.Table("retention_items_archive").Order("id").Pluck("name", &names).Error; err != nil {
+					t.Fatalf("pluck fail. err:%v", err)
+				}
+				if fmt.Sprint(names) != "[item1 item2 item3]" {
+					t.Fatalf("archive names:%v", names)
+				}
+			}
+			if tt.wantFile != "" {
+				if ids := fmt.Sprint(readRetentionFile(t, report.File)); ids != tt.wantFile {
+					t.Fatalf("file ids:%s want:%s", ids, tt.wantFile)
+				}
+			} else if report.File != "" {
+				t.Fatalf("file:%s", report.File)
+			}
+
+			// nothing expired in the next run, the archive table exists
+			report, err = gormDb.RunRetention(context.Background(), 0, policy)
+			if err != nil {
+				t.Fatalf("run again fail. err:%v", err)
+			}
+			if report.Rows != 0 {
+				t.Fatalf("report of next run:%+v", report)
+			}
+		})
+	}
+}
+
+func TestRunRetentionInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy *gormdb.RetentionPolicy
+	}{
+		{
+			name:   "no keep",
+			policy: &gormdb.RetentionPolicy{Model: &retentionItem{}},
+		},
+		{
+			name:   "no archive dir",
+			policy: &gormdb.RetentionPolicy{Model: &retentionItem{}, Keep: time.Hour, Action: gormdb.RetentionActionArchiveFile},
+		},
+		{
+			name:   "unknown time column",
+			policy: &gormdb.RetentionPolicy{Model: &retentionItem{}, Keep: time.Hour, TimeColumn: "updated_at"},
+		},
+		{
+			name:   "unknown action",
+			policy: &gormdb.RetentionPolicy{Model: &retentionItem{}, Keep: time.Hour, Action: "move"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := newRetentionTestDb(t)
+			if _, err := gormDb.RunRetention(context.Background(), 0, tt.policy); err == nil {
+				t.Fatalf("want error")
+			}
+			if ids := fmt.Sprint(getRetentionIds(t, gormDb.GetDb(0), "retention_items")); ids != "[1 2 3 4 5]" {
+				t.Fatalf("ids:%s", ids)
+			}
+		})
+	}
+}
+
+func TestRunRetentionCanceled(t *testing.T) {
+	gormDb := newRetentionTestDb(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := gormDb.RunRetention(ctx, 0, &gormdb.RetentionPolicy{
+		Model:         &retentionItem{},
+		Keep:          time.Hour,
+		BatchSize:     1,
+		BatchInterval: time.Hour,
+		Report: func(ctx context.Context, report *gormdb.RetentionReport) {
+			// canceled in the interval after the first batch
+			cancel()
+		},
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err:%v want:%v", err, context.Canceled)
+	}
+	if ids := fmt.Sprint(getRetentionIds(t, gormDb.GetDb(0), "retention_items")); ids != "[2 3 4 5]" {
+		t.Fatalf("ids:%s want:[2 3 4 5]", ids)
+	}
+}
+
+func TestRetentionTriggers(t *testing.T) {
+	gormDb := newRetentionTestDb(t)
+	if triggers := gormDb.RetentionTriggers(0, 1); len(triggers) != 4 {
+		t.Fatalf("triggers:%d want:4", len(triggers))
+	}
+}
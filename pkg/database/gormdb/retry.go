@@ -0,0 +1,31 @@
+package gormdb
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+// base << retry, capped by max if max > 0, then randomly reduced up to half
+func backoffWithJitter(base time.Duration, retry int, max time.Duration) time.Duration {
+	wait := base << retry
+	if wait <= 0 || (max > 0 && wait > max) {
+		wait = max
+	}
+
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return serr.Wrap(ctx.Err())
+	case <-timer.C:
+		return nil
+	}
+}
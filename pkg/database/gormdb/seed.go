@@ -0,0 +1,552 @@
+package gormdb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// reference data applied after migrations, like item tables, configuration rows and test accounts
+//
+// rows are matched by natural key, missing rows are inserted and changed columns are updated.
+// rows not in the seed are never deleted
+type Seed struct {
+	// unique in a db type
+	Name string
+	// must be greater than 0, the seed is applied again when Version is greater than the applied one
+	Version int64
+	// model values like &Item{Code: "sword", Price: 10}, every column is seeded except
+	// auto create time, auto update time and a zero primary key
+	Rows []interface{}
+	// JSON or YAML files like {"Item": [{"code": "sword", "price": 10}]}, applied after Rows.
+	// keys are the struct name or table name of T.GetTables(), only columns in the file are seeded.
+	// relative paths are resolved against the working directory of the process, not the source file
+	Files []string
+	// natural key columns by struct name or table name, default is the primary key
+	Keys map[string][]string
+}
+
+type DbTypeGetSeeds interface {
+	GetSeeds() []*Seed
+}
+
+// bookkeeping table of applied seeds
+type SeedVersion struct {
+	Name      string `gorm:"primaryKey;size:191"`
+	Version   int64
+	AppliedAt time.Time
+}
+
+func (SeedVersion) TableName() string {
+	return "jf_seed_versions"
+}
+
+type SeedChangeKind string
+
+const (
+	SeedChangeInsert SeedChangeKind = "insert"
+	SeedChangeUpdate SeedChangeKind = "update"
+)
+
+type SeedChange struct {
+	Seed  string
+	Table string
+	Kind  SeedChangeKind
+	// natural key column to value
+	Key map[string]interface{}
+	// changed columns of SeedChangeUpdate
+	Changes map[string]*AuditChange
+}
+
+type SeedReport struct {
+	DbType string
+	DryRun bool
+	// pending seeds, applied unless DryRun
+	Seeds   []string
+	Changes []*SeedChange
+}
+
+func (o *SeedReport) String() string {
+	sb := strings.Builder{}
+	verb := "applied"
+	if o.DryRun {
+		verb = "would apply"
+	}
+	if len(o.Seeds) == 0 {
+		fmt.Fprintf(&sb, "db type %s: no pending seed\n", o.DbType)
+		return sb.String()
+	}
+
+	fmt.Fprintf(&sb, "db type %s: %s %s\n", o.DbType, verb, strings.Join(o.Seeds, ", "))
+	for _, change := range o.Changes {
+		keys := make([]string, 0, len(change.Key))
+		for column, v := range change.Key {
+			keys = append(keys, fmt.Sprintf("%s=%s", column, formatSeedValue(v)))
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(&sb, "  %s %s %s (%s)\n", change.Kind, change.Table, strings.Join(keys, ","), change.Seed)
+
+		columns := make([]string, 0, len(change.Changes))
+		for column := range change.Changes {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		for _, column := range columns {
+			fmt.Fprintf(&sb, "    %s: %s -> %s\n", column, formatSeedValue(change.Changes[column].From), formatSeedValue(change.Changes[column].To))
+		}
+	}
+	return sb.String()
+}
+
+// value of the column instead of pointers and structs like gorm.DeletedAt
+func formatSeedValue(v interface{}) string {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() || rv.Kind() == reflect.Ptr {
+		return "NULL"
+	}
+
+	v = rv.Interface()
+	if valuer, ok := v.(driver.Valuer); ok {
+		value, err := valuer.Value()
+		if err != nil {
+			return fmt.Sprintf("%v", v)
+		}
+		if value == nil {
+			return "NULL"
+		}
+		v = value
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func getSeeds[T comparable](dbType T) ([]*Seed, error) {
+	t, ok := any(dbType).(DbTypeGetSeeds)
+	if !ok {
+		return nil, nil
+	}
+
+	seeds := t.GetSeeds()
+	names := map[string]struct{}{}
+	for _, seed := range seeds {
+		if seed.Name == "" {
+			return nil, serr.New("seed name is empty")
+		}
+		if _, ok := names[seed.Name]; ok {
+			return nil, serr.Errorf("seed name duplicate. name:%s", seed.Name)
+		}
+		names[seed.Name] = struct{}{}
+		if seed.Version <= 0 {
+			return nil, serr.Errorf("seed version must be greater than 0. name:%s", seed.Name)
+		}
+	}
+
+	return seeds, nil
+}
+
+// apply pending seeds of T.GetSeeds() holding the migrate lock, AutoMigrate calls it after tables are migrated.
+// dryRun only reports the changes, nothing is written
+func (o *GormDb[T]) ApplySeeds(ctx context.Context, dbType T, dryRun bool) (*SeedReport, error) {
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.applySeeds(ForcePrimary(ctx), db, dbType, dryRun)
+}
+
+func (o *GormDb[T]) applySeeds(ctx context.Context, db *gorm.DB, dbType T, dryRun bool) (*SeedReport, error) {
+	report := &SeedReport{
+		DbType:  fmt.Sprint(dbType),
+		DryRun:  dryRun,
+		Seeds:   []string{},
+		Changes: []*SeedChange{},
+	}
+
+	seeds, err := getSeeds(dbType)
+	if err != nil {
+		return nil, err
+	}
+	if len(seeds) == 0 {
+		return report, nil
+	}
+
+	tables := []interface{}{}
+	if t, ok := any(dbType).(DbTypeGetTables); ok {
+		tables = t.GetTables()
+	}
+
+	apply := func(conn *gorm.DB) error {
+		// rows a dry run would write, so later seeds are diffed against them instead of the database
+		var planned seedPlan
+		if dryRun {
+			planned = seedPlan{}
+		}
+
+		applied := map[string]int64{}
+		if conn.Migrator().HasTable(&SeedVersion{}) {
+			versions := []*SeedVersion{}
+			if err := conn.Find(&versions).Error; err != nil {
+				return serr.Wrap(err)
+			}
+			for _, v := range versions {
+				applied[v.Name] = v.Version
+			}
+		}
+
+		for _, seed := range seeds {
+			if version, ok := applied[seed.Name]; ok && version >= seed.Version {
+				continue
+			}
+
+			report.Seeds = append(report.Seeds, seed.Name)
+			if err := o.applySeed(conn, seed, tables, report, planned); err != nil {
+				return serr.Wrapf(err, "seed:%s version:%d", seed.Name, seed.Version)
+			}
+		}
+		return nil
+	}
+
+	if dryRun {
+		if err := apply(db.WithContext(ctx)); err != nil {
+			return nil, err
+		}
+		return report, nil
+	}
+
+	if err := o.withMigrateLock(ctx, db, dbType, func(conn *gorm.DB) error {
+		if err := conn.AutoMigrate(&SeedVersion{}); err != nil {
+			return serr.Wrap(err)
+		}
+		return apply(conn)
+	}); err != nil {
+		return nil, err
+	}
+
+	if len(report.Seeds) != 0 {
+		o.log().InfoContext(ctx, "gormdb seeds applied",
+			slog.String("db_type", report.DbType),
+			slog.Any("seeds", report.Seeds),
+			slog.Int("changes", len(report.Changes)))
+	}
+	return report, nil
+}
+
+// rows and the version of a seed are written in a transaction
+func (o *GormDb[T]) applySeed(db *gorm.DB, seed *Seed, tables []interface{}, report *SeedReport, planned seedPlan) error {
+	rows, err := getSeedRows(db, seed, tables)
+	if err != nil {
+		return err
+	}
+
+	apply := func(tx *gorm.DB) error {
+		for _, row := range rows {
+			change, err := row.apply(tx, planned)
+			if err != nil {
+				return err
+			}
+			if change != nil {
+				change.Seed = seed.Name
+				report.Changes = append(report.Changes, change)
+			}
+		}
+		return nil
+	}
+
+	if report.DryRun {
+		return apply(db)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := apply(tx); err != nil {
+			return err
+		}
+
+		return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&SeedVersion{
+			Name:      seed.Name,
+			Version:   seed.Version,
+			AppliedAt: time.Now(),
+		}).Error
+	})
+}
+
+// table and natural key to the row written by a dry run
+type seedPlan map[string]reflect.Value
+
+func (o seedPlan) key(table string, key map[string]interface{}) string {
+	columns := make([]string, 0, len(key))
+	for column, v := range key {
+		columns = append(columns, fmt.Sprintf("%s=%s", column, formatSeedValue(v)))
+	}
+	sort.Strings(columns)
+	return table + " " + strings.Join(columns, ",")
+}
+
+type seedRow struct {
+	schema *schema.Schema
+	// pointer to the model
+	model reflect.Value
+	// columns set by the seed
+	columns []string
+	keys    []*schema.Field
+}
+
+func getSeedRows(db *gorm.DB, seed *Seed, tables []interface{}) ([]*seedRow, error) {
+	cache := &sync.Map{}
+	rows := []*seedRow{}
+	for i, v := range seed.Rows {
+		s, err := schema.Parse(v, cache, db.NamingStrategy)
+		if err != nil {
+			return nil, serr.Wrapf(err, "row:%d", i)
+		}
+
+		// a copy, so the seed declaration is not changed by create
+		model := reflect.New(s.ModelType)
+		model.Elem().Set(reflect.Indirect(reflect.ValueOf(v)))
+
+		row := &seedRow{
+			schema: s,
+			model:  model,
+		}
+		for _, dbName := range s.DBNames {
+			field := s.FieldsByDBName[dbName]
+			if field.AutoCreateTime > 0 || field.AutoUpdateTime > 0 {
+				continue
+			}
+			if _, isZero := field.ValueOf(db.Statement.Context, model.Elem()); isZero && field.PrimaryKey {
+				continue
+			}
+			row.columns = append(row.columns, dbName)
+		}
+		rows = append(rows, row)
+	}
+
+	schemas := map[string]*schema.Schema{}
+	for _, table := range tables {
+		s, err := schema.Parse(table, cache, db.NamingStrategy)
+		if err != nil {
+			return nil, serr.Wrap(err)
+		}
+		schemas[s.Name] = s
+		schemas[s.Table] = s
+	}
+	for _, path := range seed.Files {
+		fileRows, err := readSeedFile(db, schemas, path)
+		if err != nil {
+			return nil, serr.Wrapf(err, "path:%s", path)
+		}
+		rows = append(rows, fileRows...)
+	}
+
+	for _, row := range rows {
+		keys := seed.Keys[row.schema.Table]
+		if k, ok := seed.Keys[row.schema.Name]; ok {
+			keys = k
+		}
+		if err := row.setKeys(db.Statement.Context, keys); err != nil {
+			return nil, err
+		}
+	}
+
+	return rows, nil
+}
+
+func readSeedFile(db *gorm.DB, schemas map[string]*schema.Schema, path string) ([]*seedRow, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, serr.Wrap(err)
+	}
+
+	// JSON is YAML, and yaml.Node keeps the order of keys
+	document := yaml.Node{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, serr.Wrap(err)
+	}
+	if len(document.Content) == 0 {
+		return nil, nil
+	}
+	root := document.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, serr.Errorf("seed file must be an object. line:%d", root.Line)
+	}
+
+	rows := []*seedRow{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		s, ok := schemas[key.Value]
+		if !ok {
+			return nil, serr.Errorf("unknown table:%s line:%d", key.Value, key.Line)
+		}
+
+		values := []map[string]interface{}{}
+		if err := value.Decode(&values); err != nil {
+			return nil, serr.Wrapf(err, "table:%s", key.Value)
+		}
+		for _, v := range values {
+			row := &seedRow{
+				schema: s,
+				model:  reflect.New(s.ModelType),
+			}
+			for column, fieldValue := range v {
+				field := s.LookUpField(column)
+				if field == nil || field.DBName == "" {
+					return nil, serr.Errorf("unknown column:%s table:%s", column, key.Value)
+				}
+				if err := field.Set(db.Statement.Context, row.model.Elem(), fieldValue); err != nil {
+					return nil, serr.Wrapf(err, "column:%s table:%s", column, key.Value)
+				}
+				row.columns = append(row.columns, field.DBName)
+			}
+			sort.Strings(row.columns)
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, nil
+}
+
+func (o *seedRow) setKeys(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		if len(o.schema.PrimaryFields) == 0 {
+			return serr.Errorf("no natural key nor primary key. table:%s", o.schema.Table)
+		}
+		o.keys = o.schema.PrimaryFields
+	} else {
+		for _, key := range keys {
+			field := o.schema.LookUpField(key)
+			if field == nil || field.DBName == "" {
+				return serr.Errorf("unknown key column:%s table:%s", key, o.schema.Table)
+			}
+			o.keys = append(o.keys, field)
+		}
+	}
+
+	for _, field := range o.keys {
+		found := false
+		for _, column := range o.columns {
+			found = found || column == field.DBName
+		}
+		if !found {
+			return serr.Errorf("key column not set. table:%s column:%s", o.schema.Table, field.DBName)
+		}
+	}
+	return nil
+}
+
+func (o *seedRow) isKey(dbName string) bool {
+	for _, field := range o.keys {
+		if field.DBName == dbName {
+			return true
+		}
+	}
+	return false
+}
+
+// return nil if the row is unchanged. planned is not nil on a dry run, nothing is written then
+func (o *seedRow) apply(db *gorm.DB, planned seedPlan) (*SeedChange, error) {
+	ctx := db.Statement.Context
+	change := &SeedChange{
+		Table: o.schema.Table,
+		Key:   map[string]interface{}{},
+	}
+	conditions := make([]clause.Expression, 0, len(o.keys))
+	for _, field := range o.keys {
+		v, _ := field.ValueOf(ctx, o.model.Elem())
+		change.Key[field.DBName] = v
+		conditions = append(conditions, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: v})
+	}
+
+	dryRun := planned != nil
+	planKey := planned.key(o.schema.Table, change.Key)
+	existing, found := planned[planKey]
+	if !found {
+		// soft deleted rows are matched too, so seeding a deleted row restores it
+		existing = reflect.New(o.schema.ModelType)
+		result := db.Unscoped().Where(clause.And(conditions...)).Limit(1).Find(existing.Interface())
+		if result.Error != nil {
+			return nil, serr.Wrapf(result.Error, "table:%s", o.schema.Table)
+		}
+		found = result.RowsAffected != 0
+	}
+
+	if !found {
+		change.Kind = SeedChangeInsert
+		if dryRun {
+			planned[planKey] = o.model
+		} else if err := db.Create(o.model.Interface()).Error; err != nil {
+			return nil, serr.Wrapf(err, "table:%s", o.schema.Table)
+		}
+		return change, nil
+	}
+
+	change.Kind = SeedChangeUpdate
+	change.Changes = map[string]*AuditChange{}
+	updates := map[string]interface{}{}
+	for _, column := range o.columns {
+		if o.isKey(column) {
+			continue
+		}
+		field := o.schema.FieldsByDBName[column]
+		from, _ := field.ValueOf(ctx, existing.Elem())
+		to, _ := field.ValueOf(ctx, o.model.Elem())
+		if isSeedValueEqual(from, to) {
+			continue
+		}
+		change.Changes[column] = &AuditChange{From: from, To: to}
+		updates[column] = to
+	}
+	if len(updates) == 0 {
+		return nil, nil
+	}
+
+	if dryRun {
+		merged := reflect.New(o.schema.ModelType)
+		merged.Elem().Set(existing.Elem())
+		for column, v := range updates {
+			if err := o.schema.FieldsByDBName[column].Set(ctx, merged.Elem(), v); err != nil {
+				return nil, serr.Wrapf(err, "column:%s table:%s", column, o.schema.Table)
+			}
+		}
+		planned[planKey] = merged
+	} else if err := db.Unscoped().Model(reflect.New(o.schema.ModelType).Interface()).
+		Where(clause.And(conditions...)).
+		Updates(updates).Error; err != nil {
+		return nil, serr.Wrapf(err, "table:%s", o.schema.Table)
+	}
+	return change, nil
+}
+
+func isSeedValueEqual(a, b interface{}) bool {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	for av.Kind() == reflect.Ptr && !av.IsNil() {
+		av = av.Elem()
+	}
+	for bv.Kind() == reflect.Ptr && !bv.IsNil() {
+		bv = bv.Elem()
+	}
+	if !av.IsValid() || !bv.IsValid() {
+		return av.IsValid() == bv.IsValid()
+	}
+
+	// times from the database are in another location
+	if at, ok := av.Interface().(time.Time); ok {
+		bt, ok := bv.Interface().(time.Time)
+		return ok && at.Equal(bt)
+	}
+	return reflect.DeepEqual(av.Interface(), bv.Interface())
+}
@@ -0,0 +1,68 @@
+package gormdb
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/MinamiKotoriCute/serr"
+)
+
+// a small command to call from main of the application, e.g. `app seed -dry-run`
+//
+//	-dry-run           print what would change, nothing is written
+//	-format text|json  default text
+//	-db-type name      only seed the db type whose fmt.Sprint is name, default all db types passed to NewGormDb
+func RunSeedCommand[T comparable](ctx context.Context, gormDb *GormDb[T], args []string, w io.Writer) error {
+	flagSet := flag.NewFlagSet("seed", flag.ContinueOnError)
+	flagSet.SetOutput(w)
+	dryRun := flagSet.Bool("dry-run", false, "print what would change")
+	format := flagSet.String("format", "text", "text or json")
+	dbTypeName := flagSet.String("db-type", "", "only seed this db type")
+	if err := flagSet.Parse(args); err != nil {
+		return serr.Wrap(err)
+	}
+	if *format != "text" && *format != "json" {
+		return serr.Errorf("unknown format:%s", *format)
+	}
+
+	dbTypes := gormDb.dbTypes
+	if len(dbTypes) == 0 {
+		var defaultDbType T
+		dbTypes = []T{defaultDbType}
+	}
+
+	reports := []*SeedReport{}
+	for _, dbType := range dbTypes {
+		if *dbTypeName != "" && fmt.Sprint(dbType) != *dbTypeName {
+			continue
+		}
+
+		report, err := gormDb.ApplySeeds(ctx, dbType, *dryRun)
+		if err != nil {
+			return serr.Wrapf(err, "db type:%v", dbType)
+		}
+		reports = append(reports, report)
+	}
+	if len(reports) == 0 {
+		return serr.Errorf("db type not found. db_type:%s", *dbTypeName)
+	}
+
+	if *format == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(reports); err != nil {
+			return serr.Wrap(err)
+		}
+		return nil
+	}
+
+	for _, report := range reports {
+		if _, err := io.WriteString(w, report.String()); err != nil {
+			return serr.Wrap(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package gormdb_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+)
+
+type seedItem struct {
+	Id    int64 `gorm:"primaryKey"`
+	Code  string
+	Price int
+}
+
+type seedDbType int
+
+// seeds of the running test, the db type is connected without seeds
+var seedTestSeeds []*gormdb.Seed
+
+func (seedDbType) GetTables() []interface{} {
+	return []interface{}{&seedItem{}}
+}
+
+func (seedDbType) GetSeeds() []*gormdb.Seed {
+	return seedTestSeeds
+}
+
+func TestApplySeedsDryRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.yaml")
+	if err := os.WriteFile(path, []byte("seedItem:\n  - code: sword\n    price: 30\n  - code: bow\n    price: 7\n"), 0o600); err != nil {
+		t.Fatalf("write fail. err:%v", err)
+	}
+	keys := map[string][]string{"seedItem": {"code"}}
+
+	tests := []struct {
+		name     string
+		existing []*seedItem
+		seeds    []*gormdb.Seed
+		want     []string
+	}{
+		{
+			name: "insert and update",
+			existing: []*seedItem{
+				{Code: "sword", Price: 10},
+				{Code: "shield", Price: 5},
+			},
+			seeds: []*gormdb.Seed{
+				{Name: "a", Version: 1, Keys: keys, Rows: []interface{}{
+					&seedItem{Code: "sword", Price: 20},
+					&seedItem{Code: "shield", Price: 5},
+					&seedItem{Code: "bow", Price: 7},
+				}},
+			},
+			want: []string{
+				"update seed_items code=sword (a) price:10->20",
+				"insert seed_items code=bow (a)",
+			},
+		},
+		{
+			name: "row planned by an earlier seed",
+			seeds: []*gormdb.Seed{
+				{Name: "a", Version: 1, Keys: keys, Rows: []interface{}{
+					&seedItem{Code: "sword", Price: 10},
+				}},
+				{Name: "b", Version: 1, Keys: keys, Rows: []interface{}{
+					&seedItem{Code: "sword", Price: 20},
+					&seedItem{Code: "shield", Price: 5},
+				}},
+				{Name: "c", Version: 1, Keys: keys, Rows: []interface{}{
+					&seedItem{Code: "sword", Price: 20},
+				}},
+			},
+			want: []string{
+				"insert seed_items code=sword (a)",
+				"update seed_items code=sword (b) price:10->20",
+				"insert seed_items code=shield (b)",
+			},
+		},
+		{
+			name: "file after rows",
+			seeds: []*gormdb.Seed{
+				{Name: "a", Version: 1, Keys: keys, Files: []string{path}, Rows: []interface{}{
+					&seedItem{Code: "sword", Price: 10},
+				}},
+			},
+			want: []string{
+				"insert seed_items code=sword (a)",
+				"update seed_items code=sword (a) price:10->30",
+				"insert seed_items code=bow (a)",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seedTestSeeds = nil
+			gormDb := gormdbtest.New[seedDbType](t, nil, 0)
+			ctx := context.Background()
+			for _, item := range tt.existing {
+				if err := gormDb.FromCtx(ctx, 0).Create(item).Error; err != nil {
+					t.Fatalf("create fail. err:%v", err)
+				}
+			}
+
+			seedTestSeeds = tt.seeds
+			t.Cleanup(func() {
+				seedTestSeeds = nil
+			})
+			report, err := gormDb.ApplySeeds(ctx, 0, true)
+			if err != nil {
+				t.Fatalf("dry run fail. err:%v", err)
+			}
+
+			got := []string{}
+			for _, change := range report.Changes {
+				s := fmt.Sprintf("%s %s code=%v (%s)", change.Kind, change.Table, change.Key["code"], change.Seed)
+				if change := change.Changes["price"]; change != nil {
+					s += fmt.Sprintf(" price:%v->%v", change.From, change.To)
+				}
+				got = append(got, s)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got:%q want:%q", got, tt.want)
+			}
+
+			var count int64
+			if err := gormDb.FromCtx(ctx, 0).Model(&seedItem{}).Count(&count).Error; err != nil {
+				t.Fatalf("count fail. err:%v", err)
+			}
+			if count != int64(len(tt.existing)) {
+				t.Fatalf("dry run wrote rows. count:%d", count)
+			}
+		})
+	}
+}
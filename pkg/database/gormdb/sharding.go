@@ -0,0 +1,266 @@
+package gormdb
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+// return the index of the shard owns key
+type ShardStrategy[K any] interface {
+	Shard(key K) (int, error)
+}
+
+type Integer interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 | ~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64
+}
+
+type moduloStrategy[K Integer] struct {
+	shardCount int
+}
+
+// shard = key % shardCount
+func NewModuloStrategy[K Integer](shardCount int) ShardStrategy[K] {
+	return &moduloStrategy[K]{
+		shardCount: shardCount,
+	}
+}
+
+func (o *moduloStrategy[K]) Shard(key K) (int, error) {
+	if o.shardCount <= 0 {
+		return 0, serr.Errorf("shard count must be greater than 0. shard_count:%d", o.shardCount)
+	}
+
+	// shardCount may not fit in K, like 256 shards of uint8, so compute in 64 bits
+	var zero K
+	if ^zero > 0 {
+		return int(uint64(key) % uint64(o.shardCount)), nil
+	}
+
+	shard := int(int64(key) % int64(o.shardCount))
+	if shard < 0 {
+		shard += o.shardCount
+	}
+	return shard, nil
+}
+
+type consistentHashNode struct {
+	hash  uint32
+	shard int
+}
+
+type consistentHashStrategy[K any] struct {
+	nodes []consistentHashNode
+}
+
+// hash of fmt.Sprint(key) on a ring of virtualNodes nodes per shard,
+// adding a shard only moves about 1/shardCount of keys
+func NewConsistentHashStrategy[K any](shardCount int, virtualNodes int) ShardStrategy[K] {
+	if virtualNodes <= 0 {
+		virtualNodes = 100
+	}
+
+	nodes := make([]consistentHashNode, 0, shardCount*virtualNodes)
+	for shard := 0; shard < shardCount; shard++ {
+		for i := 0; i < virtualNodes; i++ {
+			nodes = append(nodes, consistentHashNode{
+				hash:  crc32.ChecksumIEEE([]byte(fmt.Sprintf("%d#%d", shard, i))),
+				shard: shard,
+			})
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].hash < nodes[j].hash
+	})
+
+	return &consistentHashStrategy[K]{
+		nodes: nodes,
+	}
+}
+
+func (o *consistentHashStrategy[K]) Shard(key K) (int, error) {
+	if len(o.nodes) == 0 {
+		return 0, serr.New("no shard")
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(fmt.Sprint(key)))
+	i := sort.Search(len(o.nodes), func(i int) bool {
+		return o.nodes[i].hash >= hash
+	})
+	if i == len(o.nodes) {
+		i = 0
+	}
+
+	return o.nodes[i].shard, nil
+}
+
+// keys from Min (inclusive) to the Min of next range (exclusive) belong to Shard
+type ShardRange[K cmp.Ordered] struct {
+	Min   K
+	Shard int
+}
+
+type rangeStrategy[K cmp.Ordered] struct {
+	ranges []ShardRange[K]
+}
+
+func NewRangeStrategy[K cmp.Ordered](ranges ...ShardRange[K]) ShardStrategy[K] {
+	ranges = append([]ShardRange[K]{}, ranges...)
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].Min < ranges[j].Min
+	})
+
+	return &rangeStrategy[K]{
+		ranges: ranges,
+	}
+}
+
+func (o *rangeStrategy[K]) Shard(key K) (int, error) {
+	i := sort.Search(len(o.ranges), func(i int) bool {
+		return o.ranges[i].Min > key
+	})
+	if i == 0 {
+		return 0, serr.Errorf("key not in any range. key:%v", key)
+	}
+
+	return o.ranges[i-1].Shard, nil
+}
+
+// map a shard key K to one of the db types of GormDb
+type Sharding[K any, T comparable] struct {
+	gormDb   *GormDb[T]
+	strategy ShardStrategy[K]
+	shards   []T
+}
+
+// shards[i] is the db type of shard index i returned by strategy
+func NewSharding[K any, T comparable](gormDb *GormDb[T], strategy ShardStrategy[K], shards ...T) *Sharding[K, T] {
+	return &Sharding[K, T]{
+		gormDb:   gormDb,
+		strategy: strategy,
+		shards:   shards,
+	}
+}
+
+func (o *Sharding[K, T]) Shards() []T {
+	return o.shards
+}
+
+func (o *Sharding[K, T]) GetShard(key K) (T, error) {
+	var dbType T
+	shard, err := o.strategy.Shard(key)
+	if err != nil {
+		return dbType, err
+	}
+	if shard < 0 || shard >= len(o.shards) {
+		return dbType, serr.Errorf("shard out of range. shard:%d shard_count:%d", shard, len(o.shards))
+	}
+
+	return o.shards[shard], nil
+}
+
+func (o *Sharding[K, T]) TryGetDb(key K) (*gorm.DB, error) {
+	dbType, err := o.GetShard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.gormDb.TryGetDb(dbType)
+}
+
+// return a gorm.DB or panic, see TryGetDb
+func (o *Sharding[K, T]) GetDb(key K) *gorm.DB {
+	db, err := o.TryGetDb(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+// see GormDb.TryFromCtx
+func (o *Sharding[K, T]) TryFromCtx(ctx context.Context, key K) (*gorm.DB, error) {
+	dbType, err := o.GetShard(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.gormDb.TryFromCtx(ctx, dbType)
+}
+
+// see GormDb.WithTx
+func (o *Sharding[K, T]) WithTx(ctx context.Context, key K, fc TxFunc) error {
+	dbType, err := o.GetShard(key)
+	if err != nil {
+		return err
+	}
+
+	return o.gormDb.WithTx(ctx, dbType, fc)
+}
+
+// run fc on every shard concurrently, return every failure
+func (o *Sharding[K, T]) Each(ctx context.Context, fc func(ctx context.Context, dbType T, db *gorm.DB) error) error {
+	return o.each(ctx, func(ctx context.Context, i int, dbType T, db *gorm.DB) error {
+		return fc(ctx, dbType, db)
+	})
+}
+
+func (o *Sharding[K, T]) each(ctx context.Context, fc func(ctx context.Context, i int, dbType T, db *gorm.DB) error) error {
+	errs := make([]error, len(o.shards))
+	wg := sync.WaitGroup{}
+	for i, dbType := range o.shards {
+		wg.Add(1)
+		go func(i int, dbType T) {
+			defer wg.Done()
+
+			db, err := o.gormDb.getDb(ctx, dbType)
+			if err == nil {
+				err = fc(ctx, i, dbType, db.WithContext(ctx))
+			}
+			if err != nil {
+				errs[i] = serr.Wrapf(err, "db type:%v", dbType)
+			}
+		}(i, dbType)
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// apply pending migrations and auto migrate tables on every shard, even if Config.AutoMigrate is false
+func (o *Sharding[K, T]) AutoMigrate(ctx context.Context) error {
+	return o.Each(ctx, func(ctx context.Context, dbType T, db *gorm.DB) error {
+		return o.gormDb.autoMigrate(ctx, db, dbType)
+	})
+}
+
+// query every shard concurrently, results are concatenated in shard order
+func ScatterGather[R any, K any, T comparable](ctx context.Context, sharding *Sharding[K, T], fc func(ctx context.Context, db *gorm.DB) ([]R, error)) ([]R, error) {
+	results := make([][]R, len(sharding.shards))
+	err := sharding.each(ctx, func(ctx context.Context, i int, dbType T, db *gorm.DB) error {
+		result, err := fc(ctx, db)
+		if err != nil {
+			return err
+		}
+
+		results[i] = result
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	all := []R{}
+	for _, result := range results {
+		all = append(all, result...)
+	}
+
+	return all, nil
+}
@@ -0,0 +1,263 @@
+package gormdb_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+)
+
+func TestModuloStrategy(t *testing.T) {
+	tests := []struct {
+		name       string
+		shardCount int
+		key        int64
+		want       int
+		wantErr    bool
+	}{
+		{
+			name:       "zero",
+			shardCount: 4,
+			key:        0,
+			want:       0,
+		},
+		{
+			name:       "positive",
+			shardCount: 4,
+			key:        10,
+			want:       2,
+		},
+		{
+			name:       "negative",
+			shardCount: 4,
+			key:        -1,
+			want:       3,
+		},
+		{
+			name:       "no shard",
+			shardCount: 0,
+			key:        1,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := gormdb.NewModuloStrategy[int64](tt.shardCount).Shard(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("got:%d want:%d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModuloStrategyKeyTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		shard func() (int, error)
+		want  int
+	}{
+		{
+			name:  "int8 more shards than max",
+			shard: func() (int, error) { return gormdb.NewModuloStrategy[int8](200).Shard(127) },
+			want:  127,
+		},
+		{
+			name:  "int8 negative",
+			shard: func() (int, error) { return gormdb.NewModuloStrategy[int8](200).Shard(-1) },
+			want:  199,
+		},
+		{
+			name:  "int8 min",
+			shard: func() (int, error) { return gormdb.NewModuloStrategy[int8](3).Shard(math.MinInt8) },
+			want:  1,
+		},
+		{
+			name:  "uint8 256 shards",
+			shard: func() (int, error) { return gormdb.NewModuloStrategy[uint8](256).Shard(255) },
+			want:  255,
+		},
+		{
+			name:  "uint64 max",
+			shard: func() (int, error) { return gormdb.NewModuloStrategy[uint64](10).Shard(math.MaxUint64) },
+			want:  5,
+		},
+		{
+			name:  "int64 min",
+			shard: func() (int, error) { return gormdb.NewModuloStrategy[int64](10).Shard(math.MinInt64) },
+			want:  2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.shard()
+			if err != nil {
+				t.Fatalf("shard fail. err:%v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("got:%d want:%d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRangeStrategy(t *testing.T) {
+	// not sorted on purpose
+	strategy := gormdb.NewRangeStrategy(
+		gormdb.ShardRange[int]{Min: 1000, Shard: 2},
+		gormdb.ShardRange[int]{Min: 0, Shard: 0},
+		gormdb.ShardRange[int]{Min: 100, Shard: 1},
+	)
+
+	tests := []struct {
+		name    string
+		key     int
+		want    int
+		wantErr bool
+	}{
+		{
+			name: "first min",
+			key:  0,
+			want: 0,
+		},
+		{
+			name: "before next min",
+			key:  99,
+			want: 0,
+		},
+		{
+			name: "next min",
+			key:  100,
+			want: 1,
+		},
+		{
+			name: "last range",
+			key:  1 << 30,
+			want: 2,
+		},
+		{
+			name:    "below every range",
+			key:     -1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := strategy.Shard(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Fatalf("got:%d want:%d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsistentHashStrategy(t *testing.T) {
+	const keys = 10000
+
+	tests := []struct {
+		name         string
+		shardCount   int
+		virtualNodes int
+		wantErr      bool
+	}{
+		{
+			name:       "default virtual nodes",
+			shardCount: 4,
+		},
+		{
+			name:         "virtual nodes",
+			shardCount:   8,
+			virtualNodes: 50,
+		},
+		{
+			name:       "no shard",
+			shardCount: 0,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			strategy := gormdb.NewConsistentHashStrategy[int](tt.shardCount, tt.virtualNodes)
+			grown := gormdb.NewConsistentHashStrategy[int](tt.shardCount+1, tt.virtualNodes)
+
+			counts := make([]int, tt.shardCount)
+			moved := 0
+			for key := 0; key < keys; key++ {
+				shard, err := strategy.Shard(key)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if shard < 0 || shard >= tt.shardCount {
+					t.Fatalf("shard out of range. shard:%d", shard)
+				}
+				if again, _ := strategy.Shard(key); again != shard {
+					t.Fatalf("shard not stable. key:%d", key)
+				}
+				counts[shard]++
+
+				grownShard, _ := grown.Shard(key)
+				if grownShard != shard {
+					if grownShard != tt.shardCount {
+						t.Fatalf("key moved between old shards. key:%d from:%d to:%d", key, shard, grownShard)
+					}
+					moved++
+				}
+			}
+
+			for shard, count := range counts {
+				if count < keys/tt.shardCount/2 {
+					t.Fatalf("shard too small. shard:%d count:%d", shard, count)
+				}
+			}
+			if moved > keys*2/(tt.shardCount+1) {
+				t.Fatalf("too many keys moved. moved:%d", moved)
+			}
+		})
+	}
+}
+
+func TestShardingGetShard(t *testing.T) {
+	sharding := gormdb.NewSharding[int64, string](nil, gormdb.NewModuloStrategy[int64](3), "a", "b")
+
+	tests := []struct {
+		name    string
+		key     int64
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "first",
+			key:  3,
+			want: "a",
+		},
+		{
+			name: "second",
+			key:  4,
+			want: "b",
+		},
+		{
+			name:    "strategy returns a missing shard",
+			key:     5,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := sharding.GetShard(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err:%v want err:%v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("got:%s want:%s", got, tt.want)
+			}
+		})
+	}
+}
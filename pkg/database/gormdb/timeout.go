@@ -0,0 +1,108 @@
+package gormdb
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+const (
+	queryTimeoutContextKey contextKey = "query_timeout"
+
+	timeoutSettingKey = "jf:timeout"
+)
+
+// statements with the returned ctx time out after timeout instead of Config.QueryTimeout, 0 means no timeout
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutContextKey, timeout)
+}
+
+type timeoutSetting struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// a gorm plugin limits the time of each statement by a ctx deadline
+//
+// rows of gorm.DB.Row and Rows, include Raw().Scan, are read after the callbacks,
+// so their ctx is not canceled by the callbacks but released when it expires, it limits reading the rows too
+type queryTimeout struct {
+	timeout time.Duration
+}
+
+func (o *queryTimeout) Name() string {
+	return "jf:timeout"
+}
+
+func (o *queryTimeout) Initialize(db *gorm.DB) error {
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("*").Register("jf:timeout", o.before),
+		callback.Create().After("*").Register("jf:timeout_after", o.after),
+		callback.Query().Before("*").Register("jf:timeout", o.before),
+		callback.Query().After("*").Register("jf:timeout_after", o.after),
+		callback.Update().Before("*").Register("jf:timeout", o.before),
+		callback.Update().After("*").Register("jf:timeout_after", o.after),
+		callback.Delete().Before("*").Register("jf:timeout", o.before),
+		callback.Delete().After("*").Register("jf:timeout_after", o.after),
+		callback.Row().Before("*").Register("jf:timeout", o.before),
+		callback.Row().After("*").Register("jf:timeout_after", o.afterRow),
+		callback.Raw().Before("*").Register("jf:timeout", o.before),
+		callback.Raw().After("*").Register("jf:timeout_after", o.after),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return serr.Wrap(err)
+	}
+
+	return nil
+}
+
+func (o *queryTimeout) before(db *gorm.DB) {
+	ctx := db.Statement.Context
+	timeout := o.timeout
+	if v, ok := ctx.Value(queryTimeoutContextKey).(time.Duration); ok {
+		timeout = v
+	}
+	if timeout <= 0 {
+		return
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	db.Statement.Settings.Store(timeoutSettingKey, &timeoutSetting{
+		ctx:    ctx,
+		cancel: cancel,
+	})
+	db.Statement.Context = timeoutCtx
+}
+
+func (o *queryTimeout) after(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(timeoutSettingKey)
+	if !ok {
+		return
+	}
+
+	// the statement may be reused, so restore the ctx of the caller
+	setting := v.(*timeoutSetting)
+	setting.cancel()
+	db.Statement.Context = setting.ctx
+}
+
+func (o *queryTimeout) afterRow(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(timeoutSettingKey)
+	if !ok {
+		return
+	}
+
+	// no rows to read on error
+	setting := v.(*timeoutSetting)
+	if db.Error != nil {
+		setting.cancel()
+	}
+	db.Statement.Context = setting.ctx
+}
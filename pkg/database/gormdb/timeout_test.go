@@ -0,0 +1,124 @@
+package gormdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type timeoutItem struct {
+	Id   int64 `gorm:"primaryKey"`
+	Name string
+}
+
+type timeoutDbType int
+
+func (timeoutDbType) GetTables() []interface{} {
+	return []interface{}{&timeoutItem{}}
+}
+
+func TestQueryTimeout(t *testing.T) {
+	gormDb := gormdbtest.New[timeoutDbType](t, &gormdb.Config{
+		QueryTimeout: time.Hour,
+		LogLevel:     logger.Silent,
+	}, 0)
+	db := gormDb.GetDb(0)
+	if err := db.Create(&timeoutItem{Id: 1, Name: "a"}).Error; err != nil {
+		t.Fatalf("create fail. err:%v", err)
+	}
+
+	// whether the statement ctx has a deadline when it runs
+	hasDeadline := false
+	record := func(db *gorm.DB) {
+		_, hasDeadline = db.Statement.Context.Deadline()
+	}
+	if err := db.Callback().Query().Before("gorm:query").Register("test:deadline", record); err != nil {
+		t.Fatalf("register fail. err:%v", err)
+	}
+	if err := db.Callback().Row().Before("gorm:row").Register("test:deadline", record); err != nil {
+		t.Fatalf("register fail. err:%v", err)
+	}
+
+	tests := []struct {
+		name string
+		run  func(db *gorm.DB) (string, error)
+	}{
+		{
+			name: "find",
+			run: func(db *gorm.DB) (string, error) {
+				item := &timeoutItem{}
+				err := db.Take(item, 1).Error
+				return item.Name, err
+			},
+		},
+		{
+			name: "row",
+			run: func(db *gorm.DB) (string, error) {
+				name := ""
+				err := db.Model(&timeoutItem{}).Select("name").Where("id = ?", 1).Row().Scan(&name)
+				return name, err
+			},
+		},
+		{
+			name: "rows",
+			run: func(db *gorm.DB) (string, error) {
+				rows, err := db.Model(&timeoutItem{}).Select("name").Rows()
+				if err != nil {
+					return "", err
+				}
+				defer rows.Close()
+
+				name := ""
+				for rows.Next() {
+					if err := rows.Scan(&name); err != nil {
+						return "", err
+					}
+				}
+				return name, rows.Err()
+			},
+		},
+		{
+			name: "raw scan",
+			run: func(db *gorm.DB) (string, error) {
+				name := ""
+				err := db.Raw("SELECT name FROM timeout_items WHERE id = ?", 1).Scan(&name).Error
+				return name, err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// rows are read after the callbacks, so they must not be canceled by them
+			hasDeadline = false
+			name, err := tt.run(db.WithContext(context.Background()))
+			if err != nil {
+				t.Fatalf("run fail. err:%v", err)
+			}
+			if name != "a" {
+				t.Fatalf("name:%s want:a", name)
+			}
+			if !hasDeadline {
+				t.Fatalf("no deadline")
+			}
+
+			_, err = tt.run(db.WithContext(gormdb.WithQueryTimeout(context.Background(), time.Nanosecond)))
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("err:%v want:%v", err, context.DeadlineExceeded)
+			}
+
+			hasDeadline = false
+			if _, err := tt.run(db.WithContext(gormdb.WithQueryTimeout(context.Background(), 0))); err != nil {
+				t.Fatalf("run without timeout fail. err:%v", err)
+			}
+			if hasDeadline {
+				t.Fatalf("deadline without timeout")
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package gormdb
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/MinamiKotoriCute/serr"
+	"gorm.io/gorm"
+)
+
+type TxFunc func(ctx context.Context) error
+
+type txContextKey[T comparable] struct {
+	gormDb *GormDb[T]
+	dbType T
+}
+
+// functions to run after the outermost transaction of WithTx committed
+type afterCommitHooks struct {
+	mutex sync.Mutex
+	fcs   []func()
+}
+
+type afterCommitContextKey struct{}
+
+// run fc after the outermost transaction of WithTx in ctx committed,
+// return false if ctx is not in WithTx, fc is not run
+func addAfterCommit(ctx context.Context, fc func()) bool {
+	hooks, ok := ctx.Value(afterCommitContextKey{}).(*afterCommitHooks)
+	if !ok {
+		return false
+	}
+
+	hooks.mutex.Lock()
+	defer hooks.mutex.Unlock()
+	hooks.fcs = append(hooks.fcs, fc)
+	return true
+}
+
+func (o *afterCommitHooks) run() {
+	o.mutex.Lock()
+	fcs := o.fcs
+	o.fcs = nil
+	o.mutex.Unlock()
+
+	for _, fc := range fcs {
+		fc()
+	}
+}
+
+func (o *GormDb[T]) getTx(ctx context.Context, dbType T) (*gorm.DB, bool) {
+	tx, ok := ctx.Value(txContextKey[T]{gormDb: o, dbType: dbType}).(*gorm.DB)
+	return tx, ok
+}
+
+// return the transaction of dbType started by WithTx, or the db of TryGetDb if not in a transaction
+func (o *GormDb[T]) TryFromCtx(ctx context.Context, dbType T) (*gorm.DB, error) {
+	if tx, ok := o.getTx(ctx, dbType); ok {
+		return tx.WithContext(ctx), nil
+	}
+
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	return db.WithContext(ctx), nil
+}
+
+// return a gorm.DB or panic, see TryFromCtx
+func (o *GormDb[T]) FromCtx(ctx context.Context, dbType T) *gorm.DB {
+	db, err := o.TryFromCtx(ctx, dbType)
+	if err != nil {
+		panic(err)
+	}
+
+	return db
+}
+
+// run fc in a transaction of dbType, FromCtx(ctx, dbType) inside fc returns the transaction
+//
+// nested WithTx uses savepoint. the outermost transaction is retried with backoff
+// when database reports deadlock or lock wait timeout, so fc must be safe to run again.
+// return CircuitOpenError without starting while the circuit breaker of dbType is open
+func (o *GormDb[T]) WithTx(ctx context.Context, dbType T, fc TxFunc) error {
+	key := txContextKey[T]{gormDb: o, dbType: dbType}
+
+	if parent, ok := o.getTx(ctx, dbType); ok {
+		return parent.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fc(context.WithValue(ctx, key, tx))
+		})
+	}
+
+	if err := o.checkBreaker(dbType); err != nil {
+		return err
+	}
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return err
+	}
+
+	maxRetry := o.config.TxMaxRetry
+	if maxRetry == 0 {
+		maxRetry = 3
+	}
+	backoff := o.config.TxRetryBackoff
+	if backoff == 0 {
+		backoff = 50 * time.Millisecond
+	}
+
+	for retry := 0; ; retry++ {
+		hooks := &afterCommitHooks{}
+		hooksCtx := context.WithValue(ctx, afterCommitContextKey{}, hooks)
+		err := db.WithContext(hooksCtx).Transaction(func(tx *gorm.DB) error {
+			return fc(context.WithValue(hooksCtx, key, tx))
+		})
+		if err == nil {
+			hooks.run()
+			return nil
+		}
+		if retry >= maxRetry || !isRetryableTxError(err) {
+			return err
+		}
+
+		if err := sleepContext(ctx, backoffWithJitter(backoff, retry, 0)); err != nil {
+			return err
+		}
+	}
+}
+
+// begin a transaction of dbType, FromCtx(ctx, dbType) and WithTx with the returned ctx use it
+//
+// the transaction is not retried nor committed, caller must Commit or Rollback the returned gorm.DB
+func (o *GormDb[T]) Begin(ctx context.Context, dbType T) (context.Context, *gorm.DB, error) {
+	if _, ok := o.getTx(ctx, dbType); ok {
+		return nil, nil, serr.Errorf("already in a transaction. db type:%v", dbType)
+	}
+
+	if err := o.checkBreaker(dbType); err != nil {
+		return nil, nil, err
+	}
+	db, err := o.getDb(ctx, dbType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx := db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return nil, nil, serr.Wrap(tx.Error)
+	}
+
+	return context.WithValue(ctx, txContextKey[T]{gormDb: o, dbType: dbType}, tx), tx, nil
+}
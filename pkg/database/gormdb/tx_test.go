@@ -0,0 +1,229 @@
+package gormdb_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb"
+	"github.com/MinamiKotoriCute/jf/pkg/database/gormdb/gormdbtest"
+	"github.com/go-sql-driver/mysql"
+)
+
+type txItem struct {
+	Id int64 `gorm:"primaryKey"`
+}
+
+type txDbType int
+
+func (txDbType) GetTables() []interface{} {
+	return []interface{}{&txItem{}}
+}
+
+var errTxTest = errors.New("tx test")
+
+func TestWithTx(t *testing.T) {
+	tests := []struct {
+		name string
+		fc   func(gormDb *gormdb.GormDb[txDbType], ctx context.Context) error
+		want []int64
+	}{
+		{
+			name: "commit",
+			fc: func(gormDb *gormdb.GormDb[txDbType], ctx context.Context) error {
+				return gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+					return gormDb.FromCtx(ctx, 0).Create(&txItem{Id: 1}).Error
+				})
+			},
+			want: []int64{1},
+		},
+		{
+			name: "rollback",
+			fc: func(gormDb *gormdb.GormDb[txDbType], ctx context.Context) error {
+				err := gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+					if err := gormDb.FromCtx(ctx, 0).Create(&txItem{Id: 1}).Error; err != nil {
+						return err
+					}
+					return errTxTest
+				})
+				if !errors.Is(err, errTxTest) {
+					return err
+				}
+				return nil
+			},
+			want: []int64{},
+		},
+		{
+			name: "nested rollback to savepoint",
+			fc: func(gormDb *gormdb.GormDb[txDbType], ctx context.Context) error {
+				return gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+					if err := gormDb.FromCtx(ctx, 0).Create(&txItem{Id: 1}).Error; err != nil {
+						return err
+					}
+					err := gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+						if err := gormDb.FromCtx(ctx, 0).Create(&txItem{Id: 2}).Error; err != nil {
+							return err
+						}
+						return errTxTest
+					})
+					if !errors.Is(err, errTxTest) {
+						return err
+					}
+					return gormDb.FromCtx(ctx, 0).Create(&txItem{Id: 3}).Error
+				})
+			},
+			want: []int64{1, 3},
+		},
+		{
+			name: "begin",
+			fc: func(gormDb *gormdb.GormDb[txDbType], ctx context.Context) error {
+				txCtx, tx, err := gormDb.Begin(ctx, 0)
+				if err != nil {
+					return err
+				}
+				if err := gormDb.FromCtx(txCtx, 0).Create(&txItem{Id: 1}).Error; err != nil {
+					tx.Rollback()
+					return err
+				}
+				if _, _, err := gormDb.Begin(txCtx, 0); err == nil {
+					tx.Rollback()
+					return errors.New("begin in a transaction want error")
+				}
+				return tx.Commit().Error
+			},
+			want: []int64{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[txDbType](t, nil, 0)
+			ctx := context.Background()
+			if err := tt.fc(gormDb, ctx); err != nil {
+				t.Fatalf("fc fail. err:%v", err)
+			}
+
+			ids := []int64{}
+			if err := gormDb.FromCtx(ctx, 0).Model(&txItem{}).Order("id").Pluck("id", &ids).Error; err != nil {
+				t.Fatalf("pluck fail. err:%v", err)
+			}
+			if len(ids) != len(tt.want) {
+				t.Fatalf("got:%v want:%v", ids, tt.want)
+			}
+			for i := range ids {
+				if ids[i] != tt.want[i] {
+					t.Fatalf("got:%v want:%v", ids, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWithTxRetry(t *testing.T) {
+	deadlock := &mysql.MySQLError{Number: 1213, Message: "deadlock"}
+
+	tests := []struct {
+		name       string
+		maxRetry   int
+		failures   int
+		err        error
+		wantCalls  int
+		wantFailed bool
+	}{
+		{
+			name:      "retry deadlock",
+			failures:  2,
+			err:       deadlock,
+			wantCalls: 3,
+		},
+		{
+			name:      "lock wait timeout",
+			failures:  1,
+			err:       &mysql.MySQLError{Number: 1205, Message: "lock wait timeout"},
+			wantCalls: 2,
+		},
+		{
+			name:       "exceed max retry",
+			maxRetry:   2,
+			failures:   5,
+			err:        deadlock,
+			wantCalls:  3,
+			wantFailed: true,
+		},
+		{
+			name:       "not retryable",
+			failures:   1,
+			err:        errTxTest,
+			wantCalls:  1,
+			wantFailed: true,
+		},
+		{
+			name:       "retry disabled",
+			maxRetry:   -1,
+			failures:   1,
+			err:        deadlock,
+			wantCalls:  1,
+			wantFailed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gormDb := gormdbtest.New[txDbType](t, &gormdb.Config{
+				TxMaxRetry:     tt.maxRetry,
+				TxRetryBackoff: time.Millisecond,
+			}, 0)
+			ctx := context.Background()
+
+			calls := 0
+			err := gormDb.WithTx(ctx, 0, func(ctx context.Context) error {
+				calls++
+				if err := gormDb.FromCtx(ctx, 0).Create(&txItem{Id: int64(calls)}).Error; err != nil {
+					return err
+				}
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantFailed {
+				t.Fatalf("err:%v want failed:%v", err, tt.wantFailed)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("calls:%d want:%d", calls, tt.wantCalls)
+			}
+
+			// rows of rolled back attempts are not kept
+			var count int64
+			if err := gormDb.FromCtx(ctx, 0).Model(&txItem{}).Count(&count).Error; err != nil {
+				t.Fatalf("count fail. err:%v", err)
+			}
+			wantCount := int64(1)
+			if tt.wantFailed {
+				wantCount = 0
+			}
+			if count != wantCount {
+				t.Fatalf("count:%d want:%d", count, wantCount)
+			}
+		})
+	}
+}
+
+func TestWithTxCanceledBackoff(t *testing.T) {
+	gormDb := gormdbtest.New[txDbType](t, &gormdb.Config{
+		TxRetryBackoff: time.Hour,
+	}, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	calls := 0
+	err := gormDb.WithTx(ctx, 0, func(context.Context) error {
+		calls++
+		cancel()
+		return &mysql.MySQLError{Number: 1213, Message: "deadlock"}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err:%v want canceled", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls:%d want:1", calls)
+	}
+}
@@ -24,7 +24,7 @@ func Call[RspT proto.Message, ReqT proto.Message](o *ProtobufHandler, ctx contex
 }
 
 func (o *ProtobufHandler) Call(ctx context.Context, req proto.Message) (proto.Message, error) {
-	funcInfo, ok := o.handleFuncs[string(req.ProtoReflect().Descriptor().FullName())]
+	funcInfo, ok := o.chainedFuncs[string(req.ProtoReflect().Descriptor().FullName())]
 	if !ok {
 		return nil, serr.New("handle function not found")
 	}
@@ -32,8 +32,9 @@ func (o *ProtobufHandler) Call(ctx context.Context, req proto.Message) (proto.Me
 	return funcInfo.Call(ctx, req)
 }
 
+// Call of the returned info runs the interceptors
 func (o *ProtobufHandler) GetHandleFuncInfo(reqPbName string) *delivery.HandleFuncInfo {
-	funcInfo, ok := o.handleFuncs[reqPbName]
+	funcInfo, ok := o.chainedFuncs[reqPbName]
 	if !ok {
 		return nil
 	}
@@ -0,0 +1,43 @@
+package protobufhandler
+
+import (
+	"context"
+
+	"github.com/MinamiKotoriCute/jf/pkg/delivery"
+	"google.golang.org/protobuf/proto"
+)
+
+type NextFunc func(ctx context.Context, req proto.Message) (proto.Message, error)
+
+// run around the handle function of info, call next to continue the chain, or return without calling it to stop
+type Interceptor func(ctx context.Context, req proto.Message, info *delivery.HandleFuncInfo, next NextFunc) (proto.Message, error)
+
+// add interceptors run for every handle function, before the interceptors passed to Regist.
+// handle functions registered before are wrapped too
+//
+// not thread safe, call before serving
+func (o *ProtobufHandler) Use(interceptors ...Interceptor) {
+	o.interceptors = append(o.interceptors, interceptors...)
+	for reqName, info := range o.handleFuncs {
+		o.chainedFuncs[reqName] = o.chain(info)
+	}
+}
+
+// return a copy of info whose Call runs the interceptors, global interceptors first, in the order added
+func (o *ProtobufHandler) chain(info *delivery.HandleFuncInfo) *delivery.HandleFuncInfo {
+	interceptors := append(append([]Interceptor{}, o.interceptors...), o.handleInterceptors[info.ReqName]...)
+
+	next := NextFunc(info.Call)
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, n := interceptors[i], next
+		next = func(ctx context.Context, req proto.Message) (proto.Message, error) {
+			return interceptor(ctx, req, info, n)
+		}
+	}
+
+	return &delivery.HandleFuncInfo{
+		ReqName: info.ReqName,
+		NewReq:  info.NewReq,
+		Call:    next,
+	}
+}
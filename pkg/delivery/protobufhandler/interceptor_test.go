@@ -0,0 +1,174 @@
+package protobufhandler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/MinamiKotoriCute/jf/pkg/delivery"
+	"github.com/MinamiKotoriCute/jf/pkg/delivery/protobufhandler"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var errDenied = errors.New("denied")
+
+type interceptorService struct {
+	calls *[]string
+}
+
+func (o *interceptorService) Echo(ctx context.Context, req *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	*o.calls = append(*o.calls, "echo")
+	return req, nil
+}
+
+func (o *interceptorService) Double(ctx context.Context, req *wrapperspb.Int32Value) (*wrapperspb.Int32Value, error) {
+	*o.calls = append(*o.calls, "double")
+	return wrapperspb.Int32(req.Value * 2), nil
+}
+
+func record(calls *[]string, name string) protobufhandler.Interceptor {
+	return func(ctx context.Context, req proto.Message, info *delivery.HandleFuncInfo, next protobufhandler.NextFunc) (proto.Message, error) {
+		*calls = append(*calls, name)
+		return next(ctx, req)
+	}
+}
+
+func deny(calls *[]string, name string) protobufhandler.Interceptor {
+	return func(ctx context.Context, req proto.Message, info *delivery.HandleFuncInfo, next protobufhandler.NextFunc) (proto.Message, error) {
+		*calls = append(*calls, name)
+		return nil, errDenied
+	}
+}
+
+func TestInterceptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error
+		req     proto.Message
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "order",
+			setup: func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error {
+				h.Use(record(calls, "use1"), record(calls, "use2"))
+				return h.Regist(s.Echo, record(calls, "regist1"), record(calls, "regist2"))
+			},
+			req:  wrapperspb.String("a"),
+			want: []string{"use1", "use2", "regist1", "regist2", "echo"},
+		},
+		{
+			name: "use after regist",
+			setup: func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error {
+				if err := h.Regist(s.Echo, record(calls, "regist")); err != nil {
+					return err
+				}
+				h.Use(record(calls, "use"))
+				return nil
+			},
+			req:  wrapperspb.String("a"),
+			want: []string{"use", "regist", "echo"},
+		},
+		{
+			name: "short circuit",
+			setup: func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error {
+				h.Use(deny(calls, "deny"))
+				return h.Regist(s.Echo, record(calls, "regist"))
+			},
+			req:     wrapperspb.String("a"),
+			want:    []string{"deny"},
+			wantErr: errDenied,
+		},
+		{
+			name: "service scoped",
+			setup: func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error {
+				if err := h.RegistService(s, record(calls, "service")); err != nil {
+					return err
+				}
+				return h.Regist(func(ctx context.Context, req *wrapperspb.BoolValue) (*wrapperspb.BoolValue, error) {
+					*calls = append(*calls, "other")
+					return req, nil
+				})
+			},
+			req:  wrapperspb.Bool(true),
+			want: []string{"other"},
+		},
+		{
+			name: "service method",
+			setup: func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error {
+				return h.RegistService(s, record(calls, "service"))
+			},
+			req:  wrapperspb.Int32(2),
+			want: []string{"service", "double"},
+		},
+		{
+			name: "regist again without interceptors",
+			setup: func(h *protobufhandler.ProtobufHandler, s *interceptorService, calls *[]string) error {
+				if err := h.RegistService(s, record(calls, "service")); err != nil {
+					return err
+				}
+				return h.Regists(s.Echo)
+			},
+			req:  wrapperspb.String("a"),
+			want: []string{"service", "echo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := []string{}
+			s := &interceptorService{calls: &calls}
+			h := protobufhandler.NewProtobufHandler()
+			if err := tt.setup(h, s, &calls); err != nil {
+				t.Fatalf("setup fail. err:%v", err)
+			}
+
+			reqName := string(tt.req.ProtoReflect().Descriptor().FullName())
+			calls = calls[:0]
+			if _, err := h.Call(context.Background(), tt.req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("call err:%v want:%v", err, tt.wantErr)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tt.want) {
+				t.Fatalf("call got:%v want:%v", calls, tt.want)
+			}
+
+			// handlers served by other deliveries run the same interceptors
+			calls = calls[:0]
+			if _, err := h.GetHandleFuncInfo(reqName).Call(context.Background(), tt.req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("info call err:%v want:%v", err, tt.wantErr)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tt.want) {
+				t.Fatalf("info call got:%v want:%v", calls, tt.want)
+			}
+
+			calls = calls[:0]
+			if _, err := h.GetHandlers()[reqName].Call(context.Background(), tt.req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("handlers call err:%v want:%v", err, tt.wantErr)
+			}
+			if fmt.Sprint(calls) != fmt.Sprint(tt.want) {
+				t.Fatalf("handlers call got:%v want:%v", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallResponse(t *testing.T) {
+	calls := []string{}
+	h := protobufhandler.NewProtobufHandler()
+	if err := h.RegistService(&interceptorService{calls: &calls}); err != nil {
+		t.Fatalf("regist fail. err:%v", err)
+	}
+
+	rsp, err := protobufhandler.Call[*wrapperspb.Int32Value](h, context.Background(), wrapperspb.Int32(21))
+	if err != nil {
+		t.Fatalf("call fail. err:%v", err)
+	}
+	if rsp.Value != 42 {
+		t.Fatalf("got:%d want:42", rsp.Value)
+	}
+
+	if _, err := h.Call(context.Background(), wrapperspb.Float(1)); err == nil {
+		t.Fatalf("want error of unregistered request")
+	}
+}
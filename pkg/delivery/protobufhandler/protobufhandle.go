@@ -4,14 +4,22 @@ import "github.com/MinamiKotoriCute/jf/pkg/delivery"
 
 type ProtobufHandler struct {
 	handleFuncs map[string]*delivery.HandleFuncInfo
+	// handleFuncs wrapped by interceptors, rebuilt by Use and Regist
+	chainedFuncs map[string]*delivery.HandleFuncInfo
+	interceptors []Interceptor
+	// interceptors passed to Regist, key is request name
+	handleInterceptors map[string][]Interceptor
 }
 
 func NewProtobufHandler() *ProtobufHandler {
 	return &ProtobufHandler{
-		handleFuncs: make(map[string]*delivery.HandleFuncInfo),
+		handleFuncs:        make(map[string]*delivery.HandleFuncInfo),
+		chainedFuncs:       make(map[string]*delivery.HandleFuncInfo),
+		handleInterceptors: make(map[string][]Interceptor),
 	}
 }
 
+// Call of the returned infos runs the interceptors
 func (o *ProtobufHandler) GetHandlers() map[string]*delivery.HandleFuncInfo {
-	return o.handleFuncs
+	return o.chainedFuncs
 }
@@ -5,13 +5,17 @@ import (
 	"github.com/MinamiKotoriCute/serr"
 )
 
-func (o *ProtobufHandler) Regist(f interface{}) error {
+// interceptors only run for f, after the interceptors added by Use
+// and the interceptors passed by an earlier Regist of the same request
+func (o *ProtobufHandler) Regist(f interface{}, interceptors ...Interceptor) error {
 	funcInfo, err := delivery.GetHandleFuncInfo(f)
 	if err != nil {
 		return serr.Wrap(err)
 	}
 
 	o.handleFuncs[funcInfo.ReqName] = funcInfo
+	o.handleInterceptors[funcInfo.ReqName] = append(o.handleInterceptors[funcInfo.ReqName], interceptors...)
+	o.chainedFuncs[funcInfo.ReqName] = o.chain(funcInfo)
 	return nil
 }
 
@@ -24,3 +28,14 @@ func (o *ProtobufHandler) Regists(fs ...interface{}) error {
 
 	return nil
 }
+
+// regist every method of s which is delivery.HandleFuncType, interceptors run for all of them
+func (o *ProtobufHandler) RegistService(s interface{}, interceptors ...Interceptor) error {
+	for _, f := range delivery.GetHandleFuncMethods(s) {
+		if err := o.Regist(f, interceptors...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package trigger
 
 import (
 	"bytes"
+	"context"
 	"log/slog"
 	"runtime/debug"
 	"sync"
@@ -13,12 +14,17 @@ import (
 
 type HandlerFunc func() error
 
+// ctx is canceled by Trigger.Stop
+type ContextHandlerFunc func(ctx context.Context) error
+
 type Trigger struct {
-	done     chan struct{}
-	wg       sync.WaitGroup
-	duration time.Duration
-	name     string
-	handler  HandlerFunc
+	done           chan struct{}
+	cancel         context.CancelFunc
+	wg             sync.WaitGroup
+	duration       time.Duration
+	name           string
+	handler        HandlerFunc
+	contextHandler ContextHandlerFunc
 }
 
 func NewTrigger(duration time.Duration,
@@ -31,9 +37,24 @@ func NewTrigger(duration time.Duration,
 	}
 }
 
+// Stop cancels ctx of a running handler instead of waiting it to finish
+func NewContextTrigger(duration time.Duration,
+	name string,
+	handler ContextHandlerFunc) *Trigger {
+	return &Trigger{
+		duration:       duration,
+		name:           name,
+		contextHandler: handler,
+	}
+}
+
 func (o *Trigger) Start() error {
 	o.wg.Add(1)
-	o.done = make(chan struct{})
+	// Stop sets o.done to nil, so the goroutine keeps its own copy
+	done := make(chan struct{})
+	o.done = done
+	ctx, cancel := context.WithCancel(context.Background())
+	o.cancel = cancel
 
 	go func() {
 		defer o.wg.Done()
@@ -42,15 +63,12 @@ func (o *Trigger) Start() error {
 		defer ticker.Stop()
 
 		for {
-			if o.done == nil {
-				return
-			}
-
 			select {
-			case <-o.done:
+			case <-done:
 				return
 			case <-ticker.C:
-				if err := o.runHandlerAndCapturePanic(); err != nil {
+				// canceled by Stop is not a failure
+				if err := o.runHandlerAndCapturePanic(ctx); err != nil && ctx.Err() == nil {
 					attrs := []interface{}{
 						slog.Any("err", serr.ToJSON(err, true)),
 					}
@@ -66,7 +84,7 @@ func (o *Trigger) Start() error {
 	return nil
 }
 
-func (o *Trigger) runHandlerAndCapturePanic() (err error) {
+func (o *Trigger) runHandlerAndCapturePanic(ctx context.Context) (err error) {
 	defer func() {
 		if v := recover(); v != nil {
 			stack := debug.Stack()
@@ -79,7 +97,11 @@ func (o *Trigger) runHandlerAndCapturePanic() (err error) {
 		}
 	}()
 
-	err = o.handler()
+	if o.contextHandler != nil {
+		err = o.contextHandler(ctx)
+	} else {
+		err = o.handler()
+	}
 	return
 }
 
@@ -90,6 +112,7 @@ func (o *Trigger) Stop() {
 
 	close(o.done)
 	o.done = nil
+	o.cancel()
 	o.wg.Wait()
 }
 